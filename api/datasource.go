@@ -0,0 +1,27 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bi-web/db"
+)
+
+// DataSourcesHandler 返回所有可用的数据源
+func DataSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 启用文件数据源时排在最后，前端据此显示上传入口
+	datasources := db.ListDataSources()
+	if db.FilesEnabled() {
+		datasources = append(datasources, db.DataSourceInfo{Name: db.FileDataSource, ReadOnly: true, File: true})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"datasources": datasources,
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// ExplainRequest 执行计划请求，查询相关字段与 QueryRequest 相同
+type ExplainRequest struct {
+	QueryRequest
+	Analyze bool `json:"analyze,omitempty"` // 是否同时执行 EXPLAIN ANALYZE（会真正执行查询）
+}
+
+// ExplainHandler 返回查询的执行计划
+func ExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExplainRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.QueryID != "" && !db.ValidQueryID(req.QueryID) {
+		http.Error(w, "查询ID格式错误", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := req.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("分析执行计划[%s] (analyze=%v): %s", req.DataSource, req.Analyze, req.Query)
+	startedAt := time.Now()
+	result := db.Explain(r.Context(), opts, req.Analyze)
+	if opts.QueryID == "" {
+		opts.QueryID = result.QueryID
+	}
+	recordExecution(r, store.HistoryExplain, opts, 0, startedAt, db.QueryResult{
+		Error:     result.Error,
+		ErrorCode: result.ErrorCode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+
+	if result.Error != "" {
+		log.Printf("执行计划分析错误: %s", result.Error)
+	} else if len(result.FullScans) > 0 {
+		log.Printf("执行计划包含全表扫描: %v", result.FullScans)
+	}
+}
@@ -0,0 +1,571 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"bi-web/db"
+	"bi-web/store"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// 导出格式
+const (
+	ExportCSV   = "csv"
+	ExportTSV   = "tsv"
+	ExportXLSX  = "xlsx"
+	ExportJSONL = "jsonl"
+
+	ExportParquet     = "parquet"
+	ExportArrow       = "arrow"  // Arrow IPC 文件格式（Feather V2）
+	ExportArrowStream = "arrows" // Arrow IPC 流格式
+)
+
+// xlsxMaxRows 单个工作表最多的行数（含表头），超过后写入新的工作表
+const xlsxMaxRows = 1048576
+
+// ExportRequest 导出请求，查询相关字段与 QueryRequest 相同
+type ExportRequest struct {
+	QueryRequest
+	Format    string `json:"format"`              // csv/tsv/xlsx/jsonl/parquet/arrow/arrows
+	Delimiter string `json:"delimiter,omitempty"` // CSV 分隔符，默认逗号
+	BOM       bool   `json:"bom,omitempty"`       // CSV/TSV 是否写入 UTF-8 BOM，便于中文 Windows 下的 Excel 识别编码
+	Filename  string `json:"filename,omitempty"`  // 下载文件名（不含扩展名）
+	SheetName string `json:"sheetName,omitempty"` // XLSX 工作表名称
+}
+
+// exportWriter 导出文件写入器，在 db.RowWriter 基础上增加结束处理
+type exportWriter interface {
+	db.RowWriter
+	// Close 写完所有行后调用，XLSX 在此时输出文件内容
+	Close() error
+	// Sent 是否已有内容发送给客户端，未发送时出错可以改为返回JSON错误
+	Sent() bool
+	// discard 出错且未发送内容时释放资源
+	discard()
+	// abort 已发送部分内容后出错时调用，在文件中标记导出未完成并结束输出
+	abort(reason string)
+}
+
+// exportIncomplete 写入被截断的导出文件中的标记
+func exportIncomplete(reason string) string {
+	return "导出未完成: " + reason
+}
+
+// sentWriter 记录是否已经向客户端写出内容
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
+
+// ExportHandler 在服务端执行查询并以文件形式流式返回全部结果: POST /api/export
+// 导出不受 QUERY_MAX_ROWS 限制，只受请求中的 limit 约束
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExportRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.QueryID == "" {
+		req.QueryID = db.NewQueryID()
+	} else if !db.ValidQueryID(req.QueryID) {
+		http.Error(w, "查询ID格式错误", http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 超时只限制查询执行，大文件下载到慢速客户端时不会被中途截断
+	opts.ExecTimeoutOnly = true
+	req.Format = strings.ToLower(req.Format)
+	if req.Format == "" {
+		req.Format = ExportCSV
+	}
+
+	ew, err := newExportWriter(w, &req, &opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Query-ID", req.QueryID)
+
+	log.Printf("导出查询[%s] %s (%s): %s", req.DataSource, req.QueryID, req.Format, req.Query)
+	startedAt := time.Now()
+	result := db.StreamSQL(r.Context(), opts, ew)
+	if result.Error == "" {
+		if err := ew.Close(); err != nil {
+			result.Error = fmt.Sprintf("写入导出文件失败: %v", err)
+		}
+	}
+	recordExecution(r, store.HistoryExport, opts, req.SavedQueryID, startedAt, result)
+
+	if result.Error == "" {
+		log.Printf("导出成功: %d 行, 耗时: %s", result.RowCount, result.Duration)
+		return
+	}
+	log.Printf("导出错误: %s", result.Error)
+
+	// 还未输出文件内容时返回JSON错误，否则在文件末尾标记导出未完成，并通过 trailer 告知
+	if !ew.Sent() {
+		ew.discard()
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "application/json")
+		status := http.StatusInternalServerError
+		switch result.ErrorCode {
+		case db.ErrCodeParams, db.ErrCodeReadOnly:
+			status = http.StatusBadRequest
+		case db.ErrCodeTimeout:
+			status = http.StatusGatewayTimeout
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	ew.abort(result.Error)
+	w.Header().Set("X-Export-Error", url.QueryEscape(result.Error))
+}
+
+// newExportWriter 按格式创建写入器并设置响应头，响应头在写入表头时才发送
+func newExportWriter(w http.ResponseWriter, req *ExportRequest, opts *db.QueryOptions) (exportWriter, error) {
+	var ext, contentType string
+	var ew exportWriter
+
+	switch req.Format {
+	case ExportCSV, ExportTSV:
+		delimiter := ','
+		if req.Format == ExportTSV {
+			delimiter = '\t'
+		}
+		if req.Delimiter != "" {
+			r, size := utf8.DecodeRuneInString(req.Delimiter)
+			if size != len(req.Delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+				return nil, fmt.Errorf("分隔符必须是单个字符: %q", req.Delimiter)
+			}
+			delimiter = r
+		}
+		// 数值以完整精度输出为文本
+		opts.NumberMode = db.NumberFloat
+		ext, contentType = req.Format, "text/csv; charset=utf-8"
+		if req.Format == ExportTSV {
+			contentType = "text/tab-separated-values; charset=utf-8"
+		}
+		ew = newCSVExportWriter(w, delimiter, req.BOM)
+	case ExportXLSX:
+		// 数值写成数字单元格
+		opts.NumberMode = db.NumberFloat
+		ext, contentType = "xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		ew = newXLSXExportWriter(w, req.SheetName)
+	case ExportJSONL:
+		ext, contentType = "jsonl", "application/x-ndjson; charset=utf-8"
+		ew = newJSONLExportWriter(w)
+	case ExportParquet, ExportArrow, ExportArrowStream:
+		// 精确数值以文本传入，按列类型转换为整数或 DECIMAL
+		opts.NumberMode = db.NumberString
+		ext, contentType = req.Format, "application/vnd.apache.parquet"
+		switch req.Format {
+		case ExportArrow:
+			contentType = "application/vnd.apache.arrow.file"
+		case ExportArrowStream:
+			contentType = "application/vnd.apache.arrow.stream"
+		}
+		ew = newArrowExportWriter(w, req.Format)
+	default:
+		return nil, fmt.Errorf("不支持的导出格式: %s", req.Format)
+	}
+
+	filename := strings.TrimSpace(req.Filename)
+	if filename == "" {
+		filename = "export-" + time.Now().Format("20060102-150405")
+	}
+	filename += "." + ext
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Trailer", "X-Export-Error")
+	return ew, nil
+}
+
+// contentDisposition 生成附件下载头，中文文件名使用 RFC 5987 编码
+func contentDisposition(filename string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, url.PathEscape(filename))
+}
+
+// csvExportWriter 输出 CSV/TSV
+type csvExportWriter struct {
+	w      http.ResponseWriter
+	out    *sentWriter
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	bom    bool
+	rows   int
+	record []string
+}
+
+func newCSVExportWriter(w http.ResponseWriter, delimiter rune, bom bool) *csvExportWriter {
+	out := &sentWriter{w: w}
+	buf := bufio.NewWriterSize(out, 64*1024)
+	c := csv.NewWriter(buf)
+	c.Comma = delimiter
+	return &csvExportWriter{w: w, out: out, buf: buf, csv: c, bom: bom}
+}
+
+func (c *csvExportWriter) WriteHeader(queryID string, columns []string, types []db.ColumnType) error {
+	if c.bom {
+		if _, err := c.buf.WriteString("\ufeff"); err != nil {
+			return err
+		}
+	}
+	c.record = make([]string, len(columns))
+	return c.csv.Write(columns)
+}
+
+func (c *csvExportWriter) WriteRow(row []interface{}) error {
+	for i, v := range row {
+		c.record[i] = exportText(v)
+	}
+	if err := c.csv.Write(c.record); err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%streamFlushRows == 0 {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *csvExportWriter) flush() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	if err := c.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (c *csvExportWriter) Close() error {
+	return c.flush()
+}
+
+func (c *csvExportWriter) Sent() bool {
+	return c.out.sent
+}
+
+func (c *csvExportWriter) discard() {}
+
+// abort 以单独一行写入未完成的标记，列数与表头不同，读取时容易发现
+func (c *csvExportWriter) abort(reason string) {
+	c.csv.Write([]string{exportIncomplete(reason)})
+	c.flush()
+}
+
+// jsonlExportWriter 每行输出一个以列名为键的JSON对象
+type jsonlExportWriter struct {
+	w    http.ResponseWriter
+	out  *sentWriter
+	buf  *bufio.Writer
+	rows int
+	keys [][]byte // 预先编码的列名
+}
+
+func newJSONLExportWriter(w http.ResponseWriter) *jsonlExportWriter {
+	out := &sentWriter{w: w}
+	return &jsonlExportWriter{w: w, out: out, buf: bufio.NewWriterSize(out, 64*1024)}
+}
+
+func (j *jsonlExportWriter) WriteHeader(queryID string, columns []string, types []db.ColumnType) error {
+	j.keys = make([][]byte, len(columns))
+	for i, name := range columns {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	return nil
+}
+
+// WriteRow 按列顺序手工拼接对象，保持字段顺序与查询一致
+func (j *jsonlExportWriter) WriteRow(row []interface{}) error {
+	j.buf.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+		j.buf.Write(j.keys[i])
+		j.buf.WriteByte(':')
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		j.buf.Write(value)
+	}
+	if _, err := j.buf.WriteString("}\n"); err != nil {
+		return err
+	}
+	j.rows++
+	if j.rows%streamFlushRows == 0 {
+		return j.flush()
+	}
+	return nil
+}
+
+func (j *jsonlExportWriter) flush() error {
+	if err := j.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := j.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (j *jsonlExportWriter) Close() error {
+	return j.flush()
+}
+
+func (j *jsonlExportWriter) Sent() bool {
+	return j.out.sent
+}
+
+func (j *jsonlExportWriter) discard() {}
+
+// abort 以单独一行写入未完成的标记 {"_exportError": "..."}
+func (j *jsonlExportWriter) abort(reason string) {
+	line, _ := json.Marshal(map[string]string{"_exportError": exportIncomplete(reason)})
+	j.buf.Write(line)
+	j.buf.WriteByte('\n')
+	j.flush()
+}
+
+// xlsxExportWriter 按列类型写入带类型的单元格，行数据先写入 excelize 的临时文件，结束时整体输出
+type xlsxExportWriter struct {
+	w         *sentWriter
+	file      *excelize.File
+	sheetName string
+	sheet     int // 当前工作表序号，从1开始
+	stream    *excelize.StreamWriter
+	row       int // 当前工作表已写入的行数
+	columns   []interface{}
+	types     []db.ColumnType
+	dateStyle int
+	timeStyle int
+	cells     []interface{}
+}
+
+func newXLSXExportWriter(w io.Writer, sheetName string) *xlsxExportWriter {
+	sheetName = strings.TrimSpace(sheetName)
+	if sheetName == "" {
+		sheetName = "Sheet1"
+	}
+	return &xlsxExportWriter{w: &sentWriter{w: w}, file: excelize.NewFile(), sheetName: sheetName}
+}
+
+func (x *xlsxExportWriter) WriteHeader(queryID string, columns []string, types []db.ColumnType) error {
+	var err error
+	dateFmt, timeFmt := "yyyy-mm-dd", "yyyy-mm-dd hh:mm:ss"
+	if x.dateStyle, err = x.file.NewStyle(&excelize.Style{CustomNumFmt: &dateFmt}); err != nil {
+		return err
+	}
+	if x.timeStyle, err = x.file.NewStyle(&excelize.Style{CustomNumFmt: &timeFmt}); err != nil {
+		return err
+	}
+
+	x.columns = make([]interface{}, len(columns))
+	for i, name := range columns {
+		x.columns[i] = name
+	}
+	x.types = types
+	x.cells = make([]interface{}, len(columns))
+
+	// 默认工作表改名后作为第一个工作表
+	if x.sheetName != "Sheet1" {
+		if err := x.file.SetSheetName("Sheet1", x.sheetName); err != nil {
+			return err
+		}
+	}
+	return x.startSheet(x.sheetName)
+}
+
+// startSheet 开始写入一个新工作表并写入表头
+func (x *xlsxExportWriter) startSheet(name string) error {
+	if x.stream != nil {
+		if err := x.stream.Flush(); err != nil {
+			return err
+		}
+		if _, err := x.file.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	x.sheet++
+	if x.stream, err = x.file.NewStreamWriter(name); err != nil {
+		return err
+	}
+	x.row = 1
+	return x.stream.SetRow("A1", x.columns, excelize.RowOpts{})
+}
+
+func (x *xlsxExportWriter) WriteRow(row []interface{}) error {
+	if x.row >= xlsxMaxRows {
+		if err := x.startSheet(fmt.Sprintf("%s_%d", x.sheetName, x.sheet+1)); err != nil {
+			return err
+		}
+	}
+
+	for i, v := range row {
+		x.cells[i] = x.cell(x.types[i], v)
+	}
+	x.row++
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.stream.SetRow(cell, x.cells)
+}
+
+// cell 按列类型转换单元格的值：数值写成数字，日期时间写成带格式的日期
+func (x *xlsxExportWriter) cell(t db.ColumnType, v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		// Excel 数字只有15位有效数字，超出时保留为文本
+		if significantDigits(val.String()) <= 15 {
+			if f, err := val.Float64(); err == nil {
+				return f
+			}
+		}
+		return val.String()
+	case string:
+		switch t.Kind {
+		case db.KindDate:
+			if d, err := time.Parse("2006-01-02", val); err == nil {
+				return excelize.Cell{StyleID: x.dateStyle, Value: d}
+			}
+		case db.KindDateTime:
+			if d, err := time.Parse(time.RFC3339Nano, val); err == nil {
+				// Excel 没有时区，按数据库返回的本地时间写入
+				wall := time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), time.UTC)
+				return excelize.Cell{StyleID: x.timeStyle, Value: wall}
+			}
+		}
+		return val
+	case json.RawMessage:
+		return string(val)
+	case int64:
+		if val > 999999999999999 || val < -999999999999999 {
+			return strconv.FormatInt(val, 10)
+		}
+		return val
+	case uint64:
+		if val > 999999999999999 {
+			return strconv.FormatUint(val, 10)
+		}
+		return val
+	default:
+		return val
+	}
+}
+
+func (x *xlsxExportWriter) Close() error {
+	defer x.file.Close()
+	if x.stream == nil || x.w.sent {
+		return errors.New("没有可输出的工作表")
+	}
+	if err := x.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := x.file.WriteTo(x.w)
+	return err
+}
+
+func (x *xlsxExportWriter) Sent() bool {
+	return x.w.sent
+}
+
+func (x *xlsxExportWriter) discard() {
+	x.file.Close()
+}
+
+// abort XLSX 在 Close 时才整体输出，已发送内容说明输出文件本身失败，无法再追加标记
+func (x *xlsxExportWriter) abort(reason string) {
+	x.file.Close()
+}
+
+// significantDigits 返回十进制数字文本的有效数字位数
+func significantDigits(s string) int {
+	s = strings.TrimLeft(s, "+-")
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		s = s[:i]
+	}
+	return len(strings.Trim(strings.Replace(s, ".", "", 1), "0"))
+}
+
+// exportText 把单元格的值转换为 CSV 文本
+func exportText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case json.RawMessage:
+		return string(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case uint64:
+		return strconv.FormatUint(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case bool:
+		if val {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,499 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/decimal256"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// arrowBatchRows 每个 record batch（Parquet 中为一个 row group）的行数，写满后立即输出
+const arrowBatchRows = 64 * 1024
+
+// recordWriter Parquet 和 Arrow IPC 写入器的公共部分
+type recordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// arrowExportWriter 把结果按批转换为 Arrow record batch，输出 Parquet 或 Arrow IPC 文件/流
+type arrowExportWriter struct {
+	w        http.ResponseWriter
+	out      *sentWriter
+	pos      *positionWriter
+	buf      *bufio.Writer
+	format   string
+	builder  *array.RecordBuilder
+	appends  []func(v interface{}) error
+	columns  []string
+	rw       recordWriter
+	rows     int
+	failed   bool // 某行写入到一半出错，构建器中的列长度不一致
+	finished bool
+}
+
+func newArrowExportWriter(w http.ResponseWriter, format string) *arrowExportWriter {
+	out := &sentWriter{w: w}
+	pos := &positionWriter{w: out}
+	return &arrowExportWriter{w: w, out: out, pos: pos, buf: bufio.NewWriterSize(pos, 64*1024), format: format}
+}
+
+// WriteHeader 根据列类型生成 Arrow schema 并创建文件写入器
+func (a *arrowExportWriter) WriteHeader(queryID string, columns []string, types []db.ColumnType) error {
+	fields := make([]arrow.Field, len(columns))
+	for i, name := range columns {
+		t := db.ColumnType{Name: name, Kind: db.KindString}
+		if i < len(types) {
+			t = types[i]
+		}
+		fields[i] = arrow.Field{
+			Name:     name,
+			Type:     arrowType(t),
+			Nullable: t.Nullable == nil || *t.Nullable,
+			Metadata: arrow.NewMetadata([]string{"databaseType"}, []string{t.DatabaseType}),
+		}
+	}
+	if a.format == ExportParquet {
+		// Parquet 没有时长类型，TIME 保存为微秒数
+		for i := range fields {
+			if fields[i].Type.ID() == arrow.DURATION {
+				fields[i].Type = arrow.PrimitiveTypes.Int64
+				fields[i].Metadata = arrow.NewMetadata(
+					append(fields[i].Metadata.Keys(), "unit"), append(fields[i].Metadata.Values(), "us"))
+			}
+		}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	a.columns = columns
+	a.builder = array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	a.appends = make([]func(v interface{}) error, len(fields))
+	for i, f := range fields {
+		if b, ok := a.builder.Field(i).(*array.Int64Builder); ok && f.Metadata.FindKey("unit") >= 0 {
+			a.appends[i] = durationMicrosAppender(b)
+			continue
+		}
+		a.appends[i] = arrowAppender(a.builder.Field(i), f.Type)
+	}
+
+	var err error
+	switch a.format {
+	case ExportParquet:
+		props := parquet.NewWriterProperties(
+			parquet.WithCompression(compress.Codecs.Snappy),
+			parquet.WithMaxRowGroupLength(arrowBatchRows),
+			parquet.WithCreatedBy("bi-web"),
+		)
+		// 同时保存 Arrow schema，读取时能还原时区、无符号整数等类型
+		a.rw, err = pqarrow.NewFileWriter(schema, a.buf, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	case ExportArrow:
+		a.rw, err = ipc.NewFileWriter(a.pos.bufferedBy(a.buf), ipc.WithSchema(schema))
+	case ExportArrowStream:
+		a.rw = ipc.NewWriter(a.buf, ipc.WithSchema(schema))
+	default:
+		err = fmt.Errorf("不支持的导出格式: %s", a.format)
+	}
+	return err
+}
+
+func (a *arrowExportWriter) WriteRow(row []interface{}) error {
+	for i, v := range row {
+		if v == nil {
+			if !a.builder.Schema().Field(i).Nullable {
+				a.failed = true
+				return fmt.Errorf("列 %s 声明为非空但返回了NULL", a.columns[i])
+			}
+			a.builder.Field(i).AppendNull()
+			continue
+		}
+		if err := a.appends[i](v); err != nil {
+			a.failed = true
+			return fmt.Errorf("列 %s: %v", a.columns[i], err)
+		}
+	}
+	a.rows++
+	if a.rows%arrowBatchRows == 0 {
+		return a.flush()
+	}
+	return nil
+}
+
+// flush 把已缓冲的行作为一个 record batch 写出
+func (a *arrowExportWriter) flush() error {
+	rec := a.builder.NewRecord()
+	defer rec.Release()
+	if rec.NumRows() > 0 {
+		if err := a.rw.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := a.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := a.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// Close 写出剩余的行和文件尾
+func (a *arrowExportWriter) Close() error {
+	if a.rw == nil || a.finished {
+		return nil
+	}
+	a.finished = true
+	defer a.builder.Release()
+	if !a.failed {
+		if err := a.flush(); err != nil {
+			return err
+		}
+	}
+	if err := a.rw.Close(); err != nil {
+		return err
+	}
+	return a.buf.Flush()
+}
+
+// abort Parquet 在文件尾的元数据 bi-web.export_error 中记录未完成的原因；
+// Arrow IPC 不写入文件尾（流格式为结束标记），读取时会报告文件不完整
+func (a *arrowExportWriter) abort(reason string) {
+	if a.rw == nil || a.finished {
+		return
+	}
+	if fw, ok := a.rw.(*pqarrow.FileWriter); ok {
+		fw.AppendKeyValueMetadata("bi-web.export_error", exportIncomplete(reason))
+		a.Close()
+		return
+	}
+	a.finished = true
+	defer a.builder.Release()
+	if !a.failed {
+		a.flush()
+	}
+}
+
+func (a *arrowExportWriter) Sent() bool {
+	return a.out.sent
+}
+
+func (a *arrowExportWriter) discard() {
+	if a.builder != nil && !a.finished {
+		a.finished = true
+		a.builder.Release()
+	}
+}
+
+// positionWriter 记录已写出的字节数。Arrow IPC 文件写入器只通过 Seek(0, io.SeekCurrent)
+// 读取当前位置，据此可以直接输出到不可定位的 HTTP 响应
+type positionWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (p *positionWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+// bufferedBy 返回经过 buf 写入、位置包含 buf 中未写出字节的 io.WriteSeeker
+func (p *positionWriter) bufferedBy(buf *bufio.Writer) io.WriteSeeker {
+	return &bufferedSeeker{Writer: buf, pos: p}
+}
+
+type bufferedSeeker struct {
+	*bufio.Writer
+	pos *positionWriter
+}
+
+func (b *bufferedSeeker) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, errors.New("导出输出不支持定位")
+	}
+	return b.pos.pos + int64(b.Buffered()), nil
+}
+
+// arrowType 把列类型映射为 Arrow 类型
+func arrowType(t db.ColumnType) arrow.DataType {
+	unsigned := strings.HasPrefix(t.DatabaseType, "UNSIGNED ")
+	base := strings.TrimPrefix(t.DatabaseType, "UNSIGNED ")
+
+	switch t.Kind {
+	case db.KindInteger:
+		switch base {
+		case "TINYINT":
+			if unsigned {
+				return arrow.PrimitiveTypes.Uint8
+			}
+			return arrow.PrimitiveTypes.Int8
+		case "SMALLINT":
+			if unsigned {
+				return arrow.PrimitiveTypes.Uint16
+			}
+			return arrow.PrimitiveTypes.Int16
+		case "YEAR":
+			return arrow.PrimitiveTypes.Int16
+		case "MEDIUMINT", "INT":
+			if unsigned {
+				return arrow.PrimitiveTypes.Uint32
+			}
+			return arrow.PrimitiveTypes.Int32
+		}
+		if unsigned {
+			return arrow.PrimitiveTypes.Uint64
+		}
+		return arrow.PrimitiveTypes.Int64
+	case db.KindFloat:
+		if base == "FLOAT" {
+			return arrow.PrimitiveTypes.Float32
+		}
+		return arrow.PrimitiveTypes.Float64
+	case db.KindDecimal:
+		if t.Precision == nil || t.Scale == nil {
+			// 驱动未提供精度时无法确定类型，保留文本
+			return arrow.BinaryTypes.String
+		}
+		precision, scale := int32(*t.Precision), int32(*t.Scale)
+		if precision < scale || precision < 1 {
+			precision = scale + 1
+		}
+		if precision > 38 {
+			return &arrow.Decimal256Type{Precision: precision, Scale: scale}
+		}
+		return &arrow.Decimal128Type{Precision: precision, Scale: scale}
+	case db.KindBoolean:
+		return arrow.FixedWidthTypes.Boolean
+	case db.KindDate:
+		return arrow.FixedWidthTypes.Date32
+	case db.KindDateTime:
+		// TIMESTAMP 是确定的时刻，按 UTC 保存；DATETIME 是不带时区的本地时间。
+		// Parquet 不区分两者，DATETIME 读回时显示为 UTC，数值仍是本地时间
+		if base == "TIMESTAMP" {
+			return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+		}
+		return &arrow.TimestampType{Unit: arrow.Microsecond}
+	case db.KindTime:
+		// MySQL TIME 表示时长，范围可超过一天
+		return arrow.FixedWidthTypes.Duration_us
+	case db.KindBinary:
+		return arrow.BinaryTypes.Binary
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+// arrowAppender 返回把单个非空值追加到列构建器的函数。
+// 导出时使用 NumberString 模式，整数和 DECIMAL 以文本传入，不会丢失精度
+func arrowAppender(b array.Builder, dt arrow.DataType) func(v interface{}) error {
+	switch b := b.(type) {
+	case *array.Int8Builder:
+		return intAppender(8, func(n int64) { b.Append(int8(n)) })
+	case *array.Int16Builder:
+		return intAppender(16, func(n int64) { b.Append(int16(n)) })
+	case *array.Int32Builder:
+		return intAppender(32, func(n int64) { b.Append(int32(n)) })
+	case *array.Int64Builder:
+		return intAppender(64, b.Append)
+	case *array.Uint8Builder:
+		return uintAppender(8, func(n uint64) { b.Append(uint8(n)) })
+	case *array.Uint16Builder:
+		return uintAppender(16, func(n uint64) { b.Append(uint16(n)) })
+	case *array.Uint32Builder:
+		return uintAppender(32, func(n uint64) { b.Append(uint32(n)) })
+	case *array.Uint64Builder:
+		return uintAppender(64, b.Append)
+	case *array.Float32Builder:
+		return func(v interface{}) error {
+			f, err := floatValue(v)
+			b.Append(float32(f))
+			return err
+		}
+	case *array.Float64Builder:
+		return func(v interface{}) error {
+			f, err := floatValue(v)
+			b.Append(f)
+			return err
+		}
+	case *array.Decimal128Builder:
+		t := dt.(*arrow.Decimal128Type)
+		return func(v interface{}) error {
+			n, err := decimal128.FromString(exportText(v), t.Precision, t.Scale)
+			if err != nil {
+				return fmt.Errorf("无法转换为 DECIMAL: %v", v)
+			}
+			b.Append(n)
+			return nil
+		}
+	case *array.Decimal256Builder:
+		t := dt.(*arrow.Decimal256Type)
+		return func(v interface{}) error {
+			n, err := decimal256.FromString(exportText(v), t.Precision, t.Scale)
+			if err != nil {
+				return fmt.Errorf("无法转换为 DECIMAL: %v", v)
+			}
+			b.Append(n)
+			return nil
+		}
+	case *array.BooleanBuilder:
+		return func(v interface{}) error {
+			flag, ok := v.(bool)
+			if !ok {
+				return fmt.Errorf("BIT 列的值 %v 不是单个位，请在SQL中转换为整数", v)
+			}
+			b.Append(flag)
+			return nil
+		}
+	case *array.Date32Builder:
+		return func(v interface{}) error {
+			t, err := time.Parse("2006-01-02", exportText(v))
+			if err != nil {
+				return fmt.Errorf("无法解析日期: %v", v)
+			}
+			b.Append(arrow.Date32FromTime(t))
+			return nil
+		}
+	case *array.TimestampBuilder:
+		zoned := dt.(*arrow.TimestampType).TimeZone != ""
+		return func(v interface{}) error {
+			t, err := parseExportTime(exportText(v))
+			if err != nil {
+				return err
+			}
+			if !zoned {
+				// 不带时区的时间保存墙上时间
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+			}
+			b.Append(arrow.Timestamp(t.UnixMicro()))
+			return nil
+		}
+	case *array.DurationBuilder:
+		return func(v interface{}) error {
+			d, err := parseMySQLTime(exportText(v))
+			if err != nil {
+				return err
+			}
+			b.Append(arrow.Duration(d.Microseconds()))
+			return nil
+		}
+	case *array.BinaryBuilder:
+		return func(v interface{}) error {
+			b.Append([]byte(arrowText(v)))
+			return nil
+		}
+	case *array.StringBuilder:
+		return func(v interface{}) error {
+			b.Append(arrowText(v))
+			return nil
+		}
+	default:
+		return func(v interface{}) error {
+			return fmt.Errorf("不支持的 Arrow 类型: %s", dt)
+		}
+	}
+}
+
+// durationMicrosAppender 把 TIME 转换为微秒数，用于不支持时长类型的 Parquet
+func durationMicrosAppender(b *array.Int64Builder) func(v interface{}) error {
+	return func(v interface{}) error {
+		d, err := parseMySQLTime(exportText(v))
+		if err != nil {
+			return err
+		}
+		b.Append(d.Microseconds())
+		return nil
+	}
+}
+
+func intAppender(bits int, appendFn func(int64)) func(v interface{}) error {
+	return func(v interface{}) error {
+		n, err := strconv.ParseInt(exportText(v), 10, bits)
+		if err != nil {
+			return fmt.Errorf("无法转换为 %d 位整数: %v", bits, v)
+		}
+		appendFn(n)
+		return nil
+	}
+}
+
+func uintAppender(bits int, appendFn func(uint64)) func(v interface{}) error {
+	return func(v interface{}) error {
+		n, err := strconv.ParseUint(exportText(v), 10, bits)
+		if err != nil {
+			return fmt.Errorf("无法转换为 %d 位无符号整数: %v", bits, v)
+		}
+		appendFn(n)
+		return nil
+	}
+}
+
+func floatValue(v interface{}) (float64, error) {
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case float32:
+		return float64(f), nil
+	}
+	f, err := strconv.ParseFloat(exportText(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("无法转换为浮点数: %v", v)
+	}
+	return f, nil
+}
+
+// arrowText 字符串列的文本，JSON 列保留原始JSON文本
+func arrowText(v interface{}) string {
+	if raw, ok := v.(json.RawMessage); ok {
+		return string(raw)
+	}
+	return exportText(v)
+}
+
+// parseExportTime 解析 RFC3339 时间，未开启 parseTime 的数据源返回 MySQL 文本格式
+func parseExportTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", s, time.Local)
+	if err != nil {
+		return t, fmt.Errorf("无法解析时间: %s", s)
+	}
+	return t, nil
+}
+
+// parseMySQLTime 解析 MySQL TIME 文本 [-]HHH:MM:SS[.ffffff]
+func parseMySQLTime(s string) (time.Duration, error) {
+	text := strings.TrimPrefix(s, "-")
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("无法解析时长: %s", s)
+	}
+	hours, err1 := strconv.ParseInt(parts[0], 10, 64)
+	minutes, err2 := strconv.ParseInt(parts[1], 10, 64)
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("无法解析时长: %s", s)
+	}
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)).Round(time.Microsecond)
+	if len(text) < len(s) {
+		d = -d
+	}
+	return d, nil
+}
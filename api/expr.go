@@ -0,0 +1,747 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"bi-web/db"
+)
+
+// 表达式语言：用于在合并结果上计算派生列，只能读取当前行的列，不能调用任意函数
+//
+//	数值、'字符串'、null、true、false
+//	列名：如 revenue、q1.revenue；包含空格等字符的列名用反引号括起，如 `订单 数`
+//	运算：+ - * / %，比较 = != <> < <= > >=，逻辑 and or not，括号
+//	函数：coalesce(a, b, ...)、if(条件, a, b)、ratio(a, b)、pct_change(当前, 基准)、abs(x)、round(x[, 小数位数])
+//
+// 两个运算数都是整数或十进制数（数值字面量、整数列、以文本传递的 DECIMAL）时，+ - * 和取负用有理数精确计算，
+// 结果保持运算数的表示方式（如 DECIMAL 文本仍为文本）；除法、%、函数和有浮点数参与的运算结果为 float64。
+// 空值参与算术运算和比较的结果为空，除数为0时结果为空。逻辑运算与 SQL 相同采用三值逻辑，空值表示未知：
+// not null 为空，null and false 为 false，null or true 为 true，其余有空值的结果为空；if 的条件为空时取第三个参数
+
+// maxExprLength 表达式的最大长度
+const maxExprLength = 1000
+
+// exprNode 表达式语法树的节点
+type exprNode interface {
+	eval(row []interface{}) interface{}
+}
+
+// exprFunc 表达式中可用的函数，minArgs/maxArgs 为参数个数范围，maxArgs 为 -1 时不限
+type exprFunc struct {
+	minArgs, maxArgs int
+	call             func(args []interface{}) interface{}
+}
+
+var exprFuncs = map[string]exprFunc{
+	"coalesce": {1, -1, func(args []interface{}) interface{} {
+		for _, v := range args {
+			if v != nil {
+				return v
+			}
+		}
+		return nil
+	}},
+	"ratio": {2, 2, func(args []interface{}) interface{} {
+		return divide(args[0], args[1])
+	}},
+	"pct_change": {2, 2, func(args []interface{}) interface{} {
+		return percentChange(args[0], args[1])
+	}},
+	"abs": {1, 1, func(args []interface{}) interface{} {
+		if f, ok := exprNumber(args[0]); ok {
+			return math.Abs(f)
+		}
+		return nil
+	}},
+	"round": {1, 2, func(args []interface{}) interface{} {
+		f, ok := exprNumber(args[0])
+		if !ok {
+			return nil
+		}
+		digits := 0.0
+		if len(args) == 2 {
+			if digits, ok = exprNumber(args[1]); !ok {
+				return nil
+			}
+		}
+		scale := math.Pow(10, math.Trunc(digits))
+		return math.Round(f*scale) / scale
+	}},
+}
+
+// exprNumber 把值转换为数值，无法转换时返回 false
+func exprNumber(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	if _, isBool := v.(bool); isBool {
+		return 0, false
+	}
+	f, ok := parseMergeNumber(v)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// exprTruth 条件的真假：空值为假，数值非0为真，字符串非空为真。用于 if 的条件，逻辑运算使用 logicValue
+func exprTruth(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+	f, ok := exprNumber(v)
+	return ok && f != 0
+}
+
+// percentChange 相对基准的变化百分比 (当前-基准)/|基准|×100，基准为0或空时为空
+func percentChange(cur, base interface{}) interface{} {
+	x, ok1 := exprNumber(cur)
+	y, ok2 := exprNumber(base)
+	if !ok1 || !ok2 || y == 0 {
+		return nil
+	}
+	return (x - y) / math.Abs(y) * 100
+}
+
+func divide(a, b interface{}) interface{} {
+	x, ok1 := exprNumber(a)
+	y, ok2 := exprNumber(b)
+	if !ok1 || !ok2 || y == 0 {
+		return nil
+	}
+	return x / y
+}
+
+type exprLiteral struct{ value interface{} }
+
+func (n exprLiteral) eval([]interface{}) interface{} { return n.value }
+
+type exprColumn struct {
+	name  string
+	index int
+}
+
+func (n *exprColumn) eval(row []interface{}) interface{} {
+	if n.index < 0 || n.index >= len(row) {
+		return nil
+	}
+	return row[n.index]
+}
+
+type exprUnary struct {
+	op      string
+	operand exprNode
+}
+
+// logicValue 逻辑运算的运算数：空值为未知（known 为 false），其余按 exprTruth 取真假
+func logicValue(v interface{}) (truth, known bool) {
+	if v == nil {
+		return false, false
+	}
+	return exprTruth(v), true
+}
+
+func (n exprUnary) eval(row []interface{}) interface{} {
+	v := n.operand.eval(row)
+	if n.op == "not" {
+		if truth, known := logicValue(v); known {
+			return !truth
+		}
+		return nil
+	}
+	if r, scale, ok := parseMergeRat(v); ok {
+		return exactValue(r.Neg(r), scale, v)
+	}
+	if f, ok := exprNumber(v); ok {
+		return -f
+	}
+	return nil
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+func (n exprBinary) eval(row []interface{}) interface{} {
+	switch n.op {
+	case "and", "or":
+		// 三值逻辑：and 有一方为假即为假，or 有一方为真即为真，否则有未知时为空
+		decisive := n.op == "or"
+		l, lKnown := logicValue(n.left.eval(row))
+		if lKnown && l == decisive {
+			return decisive
+		}
+		r, rKnown := logicValue(n.right.eval(row))
+		if rKnown && r == decisive {
+			return decisive
+		}
+		if lKnown && rKnown {
+			return !decisive
+		}
+		return nil
+	}
+
+	a, b := n.left.eval(row), n.right.eval(row)
+	switch n.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareExpr(n.op, a, b)
+	case "/":
+		return divide(a, b)
+	case "+", "-", "*":
+		if v, ok := exactArith(n.op, a, b); ok {
+			return v
+		}
+	}
+
+	x, ok1 := exprNumber(a)
+	y, ok2 := exprNumber(b)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	switch n.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "%":
+		if y == 0 {
+			return nil
+		}
+		return math.Mod(x, y)
+	}
+	return nil
+}
+
+// maxExactBits 精确计算结果分子和分母的最大位数（约1200位十进制数），超过时改用 float64，避免连乘生成巨大的数
+const maxExactBits = 4096
+
+// exactArith 两个运算数都能精确表示时用有理数计算 +、- 或 *，结果的小数位数为加减时的较大者、乘法时之和，
+// 表示方式与第一个以文本或 tagged 形式传递的运算数相同；不能精确计算时返回 false
+func exactArith(op string, a, b interface{}) (interface{}, bool) {
+	x, xScale, ok1 := parseMergeRat(a)
+	y, yScale, ok2 := parseMergeRat(b)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	r, scale := new(big.Rat), maxInt(xScale, yScale)
+	switch op {
+	case "+":
+		r.Add(x, y)
+	case "-":
+		r.Sub(x, y)
+	default:
+		r.Mul(x, y)
+		scale = xScale + yScale
+	}
+	if scale > maxExactExponent || r.Num().BitLen() > maxExactBits || r.Denom().BitLen() > maxExactBits {
+		return nil, false
+	}
+	like := a
+	switch b.(type) {
+	case string, db.TaggedNumber, map[string]interface{}:
+		switch a.(type) {
+		case string, db.TaggedNumber, map[string]interface{}:
+		default:
+			like = b
+		}
+	}
+	return exactValue(r, scale, like), true
+}
+
+// compareNumbers 按数值比较两个值，都能精确表示时按有理数比较；任一方不是数值时返回 false
+func compareNumbers(a, b interface{}) (int, bool) {
+	if x, _, ok := parseMergeRat(a); ok {
+		if y, _, ok := parseMergeRat(b); ok {
+			return x.Cmp(y), true
+		}
+	}
+	x, ok1 := exprNumber(a)
+	y, ok2 := exprNumber(b)
+	switch {
+	case !ok1 || !ok2:
+		return 0, false
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// compareExpr 比较两个值：都是数值时按数值比较，否则按字符串比较；有空值时结果为空
+func compareExpr(op string, a, b interface{}) interface{} {
+	if a == nil || b == nil {
+		return nil
+	}
+	c, ok := compareNumbers(a, b)
+	if !ok {
+		c = strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type exprCall struct {
+	name string
+	fn   exprFunc
+	args []exprNode
+}
+
+func (n exprCall) eval(row []interface{}) interface{} {
+	// if 只计算选中的分支
+	if n.name == "if" {
+		if exprTruth(n.args[0].eval(row)) {
+			return n.args[1].eval(row)
+		}
+		return n.args[2].eval(row)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		args[i] = a.eval(row)
+	}
+	return n.fn.call(args)
+}
+
+// Expr 解析后的表达式
+type Expr struct {
+	src     string
+	root    exprNode
+	columns []*exprColumn
+}
+
+// Bind 按列名确定表达式引用的列在行中的位置
+func (e *Expr) Bind(columns []string) error {
+	for _, c := range e.columns {
+		if c.index = columnIndex(columns, c.name); c.index < 0 {
+			return fmt.Errorf("表达式 %s 引用的列 %s 不存在", e.src, c.name)
+		}
+	}
+	return nil
+}
+
+// Eval 在一行上计算表达式，结果为 nil、数值、string 或 bool。
+// 精确计算的数值保持运算数的表示方式（int64、json.Number、DECIMAL 文本或 tagged 数值），其余数值为 float64
+func (e *Expr) Eval(row []interface{}) interface{} {
+	v := e.root.eval(row)
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return nil
+	}
+	return v
+}
+
+// numeric 表达式的结果是否一定是数值或空值，用于确定以文本表示的精确结果的列类型
+func (e *Expr) numeric() bool {
+	return numericNode(e.root)
+}
+
+func numericNode(n exprNode) bool {
+	switch t := n.(type) {
+	case exprLiteral:
+		_, isNumber := t.value.(json.Number)
+		return isNumber || t.value == nil
+	case exprUnary:
+		return t.op == "-"
+	case exprBinary:
+		switch t.op {
+		case "+", "-", "*", "/", "%":
+			return true
+		}
+	case exprCall:
+		switch t.name {
+		case "if":
+			return numericNode(t.args[1]) && numericNode(t.args[2])
+		case "coalesce":
+			for _, a := range t.args {
+				if !numericNode(a) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// exprToken 词法单元，kind 为 number、string、ident、quoted（反引号括起的列名）、op 或 eof
+type exprToken struct {
+	kind string
+	text string
+	pos  int
+}
+
+// ParseExpr 解析表达式
+func ParseExpr(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, fmt.Errorf("表达式为空")
+	}
+	if len(src) > maxExprLength {
+		return nil, fmt.Errorf("表达式超过 %d 个字符", maxExprLength)
+	}
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.errorf(t, "多余的内容 %s", t.text)
+	}
+	return &Expr{src: src, root: root, columns: p.columns}, nil
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r >= '0' && r <= '9' || r == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && src[k] >= '0' && src[k] <= '9' {
+					for j = k; j < len(src) && src[j] >= '0' && src[j] <= '9'; j++ {
+					}
+				}
+			}
+			tokens = append(tokens, exprToken{"number", src[i:j], i})
+			i = j
+		case r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(src) {
+					return nil, fmt.Errorf("表达式第 %d 个字符处的字符串没有结束", i+1)
+				}
+				if src[j] == '\'' {
+					if j+1 < len(src) && src[j+1] == '\'' {
+						sb.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(src[j])
+				j++
+			}
+			tokens = append(tokens, exprToken{"string", sb.String(), i})
+			i = j + 1
+		case r == '`':
+			j := strings.IndexByte(src[i+1:], '`')
+			if j <= 0 {
+				return nil, fmt.Errorf("表达式第 %d 个字符处的列名没有结束", i+1)
+			}
+			tokens = append(tokens, exprToken{"quoted", src[i+1 : i+1+j], i})
+			i += j + 2
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, exprToken{"ident", src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"<=", ">=", "!=", "<>", "==", "+", "-", "*", "/", "%", "(", ")", ",", "=", "<", ">"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("表达式第 %d 个字符处有无法识别的字符 %q", i+1, r)
+			}
+			tokens = append(tokens, exprToken{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{"eof", "", len(src)}), nil
+}
+
+type exprParser struct {
+	src     string
+	tokens  []exprToken
+	pos     int
+	columns []*exprColumn
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+// keyword 当前词法单元是否为指定的关键字（不区分大小写）
+func (p *exprParser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == "ident" && strings.EqualFold(t.text, word)
+}
+
+func (p *exprParser) op(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...interface{}) error {
+	return fmt.Errorf("表达式第 %d 个字符处%s", t.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{"or", left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{"and", left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.keyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{"not", operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.op("=", "==", "!=", "<>", "<", "<=", ">", ">="); ok {
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		return exprBinary{op, left, right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.op("+", "-")
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op, left, right}
+	}
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.op("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op, left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.op("-", "+"); ok {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return operand, nil
+		}
+		return exprUnary{"-", operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		// 数值字面量保留原文，与 DECIMAL 运算时可以精确计算
+		if _, err := strconv.ParseFloat(t.text, 64); err != nil {
+			return nil, p.errorf(t, "的数值格式错误: %s", t.text)
+		}
+		return exprLiteral{json.Number(t.text)}, nil
+	case "string":
+		return exprLiteral{t.text}, nil
+	case "quoted":
+		return p.column(t.text), nil
+	case "ident":
+		switch strings.ToLower(t.text) {
+		case "null":
+			return exprLiteral{nil}, nil
+		case "true":
+			return exprLiteral{true}, nil
+		case "false":
+			return exprLiteral{false}, nil
+		case "and", "or", "not":
+			return nil, p.errorf(t, "缺少运算数")
+		}
+		if _, ok := p.op("("); ok {
+			return p.parseCall(t)
+		}
+		return p.column(t.text), nil
+	case "op":
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.op(")"); !ok {
+				return nil, p.errorf(p.peek(), "缺少右括号")
+			}
+			p.next()
+			return inner, nil
+		}
+		return nil, p.errorf(t, "缺少运算数")
+	default:
+		return nil, fmt.Errorf("表达式不完整")
+	}
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	p.next() // (
+	lower := strings.ToLower(name.text)
+	fn, ok := exprFuncs[lower]
+	if lower == "if" {
+		fn, ok = exprFunc{minArgs: 3, maxArgs: 3}, true
+	}
+	if !ok {
+		return nil, p.errorf(name, "的函数 %s 不存在", name.text)
+	}
+
+	var args []exprNode
+	if _, closed := p.op(")"); !closed {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, more := p.op(","); !more {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, ok := p.op(")"); !ok {
+		return nil, p.errorf(p.peek(), "缺少右括号")
+	}
+	p.next()
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, p.errorf(name, "的函数 %s 参数个数错误", name.text)
+	}
+	return exprCall{name: lower, fn: fn, args: args}, nil
+}
+
+func (p *exprParser) column(name string) exprNode {
+	c := &exprColumn{name: name, index: -1}
+	p.columns = append(p.columns, c)
+	return c
+}
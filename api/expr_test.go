@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"bi-web/db"
+)
+
+// evalExpr 解析表达式，按 columns 绑定列后在 row 上计算
+func evalExpr(t *testing.T, src string, columns []string, row []interface{}) interface{} {
+	t.Helper()
+	e, err := ParseExpr(src)
+	if err != nil {
+		t.Fatalf("ParseExpr(%q) 出错: %v", src, err)
+	}
+	if err := e.Bind(columns); err != nil {
+		t.Fatalf("Bind(%q) 出错: %v", src, err)
+	}
+	return e.Eval(row)
+}
+
+func TestExprEval(t *testing.T) {
+	columns := []string{"revenue", "cost", "q1.revenue", "订单 数", "name", "empty", "amount", "big"}
+	row := []interface{}{"120.50", int64(100), 80.0, 0.0, "华东", nil,
+		db.TaggedNumber{Type: "decimal", Value: "10.25"}, db.TaggedNumber{Type: "bigint", Value: "9007199254740993"}}
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		// 优先级与结合性
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"12 / 3 / 2", 2.0},
+		{"-2 * 3", int64(-6)},
+		{"- -2", int64(2)},
+		{"7 % 4 + 1", 4.0},
+		{"1 + 2 > 2", true},
+		{"not 1 > 2", true},
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"not true or true", true},
+		{"not (true or true)", false},
+
+		// 列引用
+		{"revenue - cost", "20.50"},
+		{"ratio(revenue, q1.revenue)", 120.5 / 80},
+		{"`订单 数` = 0", true},
+		{"name = '华东'", true},
+		{"name != 'it''s'", true},
+		{"REVENUE > COST", true},
+
+		// 整数和十进制数精确计算，保持运算数的表示方式
+		{"0.3 - 0.1", json.Number("0.2")},
+		{"0.1 + 0.2 = 0.3", true},
+		{"0.1 * 3", json.Number("0.3")},
+		{"1.5 * 2", int64(3)},
+		{"revenue - 120.40", "0.10"},
+		{"cost - revenue", "-20.50"},
+		{"-revenue", "-120.50"},
+		{"revenue * 2", "241.00"},
+		{"amount - 0.25", db.TaggedNumber{Type: "decimal", Value: "10.00"}},
+		{"big + 1", db.TaggedNumber{Type: "bigint", Value: "9007199254740994"}},
+		{"big * 0.5", db.TaggedNumber{Type: "decimal", Value: "4503599627370496.5"}},
+		{"cost * 9223372036854775807", json.Number("922337203685477580700")},
+		{"revenue > 120.499", true},
+		{"q1.revenue - 0.5", 79.5},
+		{"revenue / 2", 60.25},
+
+		// 空值参与算术运算和比较
+		{"empty + 1", nil},
+		{"-empty", nil},
+		{"null * 0", nil},
+		{"abs(null)", nil},
+		{"empty = null", nil},
+		{"empty > 1", nil},
+		{"name = null", nil},
+		{"coalesce(empty, null, cost)", int64(100)},
+		{"coalesce(null)", nil},
+
+		// 三值逻辑
+		{"not null", nil},
+		{"not empty", nil},
+		{"null and true", nil},
+		{"true and null", nil},
+		{"null and false", false},
+		{"false and null", false},
+		{"null and null", nil},
+		{"null or false", nil},
+		{"false or null", nil},
+		{"null or true", true},
+		{"true or null", true},
+		{"null or null", nil},
+		{"not (empty > 1) or cost > 0", true},
+		{"0 and null", false},
+		{"'x' or null", true},
+
+		// 除数为0
+		{"revenue / 0", nil},
+		{"revenue % 0", nil},
+		{"ratio(revenue, 0)", nil},
+		{"ratio(revenue, `订单 数`)", nil},
+		{"ratio(0, revenue)", 0.0},
+		{"ratio(revenue, empty)", nil},
+		{"pct_change(revenue, 0)", nil},
+		{"pct_change(revenue, `订单 数`)", nil},
+		{"pct_change(empty, 80)", nil},
+		{"pct_change(revenue, q1.revenue)", (120.5 - 80) / 80 * 100},
+		{"pct_change(-50, -100)", 50.0},
+
+		// if
+		{"if(cost > 50, 'high', 'low')", "high"},
+		{"if(cost > 500, 'high', 'low')", "low"},
+		{"if(empty > 0, 'a', 'b')", "b"},
+		{"if(null, 1, 2)", json.Number("2")},
+		{"if(true, 1, revenue / 0)", json.Number("1")},
+
+		// 其他函数
+		{"round(2.345, 2)", 2.35},
+		{"round(2.5)", 3.0},
+		{"abs(cost - 150)", 50.0},
+	}
+
+	for _, tt := range tests {
+		got := evalExpr(t, tt.src, columns, row)
+		if got != tt.want {
+			t.Errorf("%s = %#v，应为 %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+// countingNode 记录被计算次数的节点
+type countingNode struct {
+	value interface{}
+	count *int
+}
+
+func (n countingNode) eval([]interface{}) interface{} {
+	*n.count++
+	return n.value
+}
+
+func TestExprIfEvaluatesChosenBranch(t *testing.T) {
+	for _, cond := range []interface{}{true, false, nil} {
+		var then, otherwise int
+		call := exprCall{name: "if", args: []exprNode{
+			exprLiteral{cond},
+			countingNode{"then", &then},
+			countingNode{"else", &otherwise},
+		}}
+
+		want, wantThen, wantElse := "else", 0, 1
+		if cond == true {
+			want, wantThen, wantElse = "then", 1, 0
+		}
+		if got := call.eval(nil); got != want {
+			t.Errorf("if(%v, ...) = %v，应为 %v", cond, got, want)
+		}
+		if then != wantThen || otherwise != wantElse {
+			t.Errorf("if(%v, ...) 计算了 %d 次第二个参数、%d 次第三个参数，应为 %d 次和 %d 次",
+				cond, then, otherwise, wantThen, wantElse)
+		}
+	}
+}
+
+func TestExprLogicShortCircuit(t *testing.T) {
+	tests := []struct {
+		op   string
+		left interface{}
+	}{
+		{"and", false},
+		{"or", true},
+	}
+	for _, tt := range tests {
+		var right int
+		n := exprBinary{tt.op, exprLiteral{tt.left}, countingNode{nil, &right}}
+		if got := n.eval(nil); got != tt.left {
+			t.Errorf("%v %s ... = %v，应为 %v", tt.left, tt.op, got, tt.left)
+		}
+		if right != 0 {
+			t.Errorf("%v %s ... 不应计算右侧", tt.left, tt.op)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // 错误信息应包含的内容
+	}{
+		{"", "表达式为空"},
+		{"   ", "表达式为空"},
+		{strings.Repeat("1+", 600) + "1", "超过 1000 个字符"},
+		{"'abc", "第 1 个字符处的字符串没有结束"},
+		{"1 + `abc", "第 5 个字符处的列名没有结束"},
+		{"1 # 2", "无法识别的字符 '#'"},
+		{"1 +", "表达式不完整"},
+		{"(1 + 2", "缺少右括号"},
+		{"round(1, 2", "缺少右括号"},
+		{"1 2", "第 3 个字符处多余的内容 2"},
+		{"(1))", "多余的内容 )"},
+		{"1 < 2 < 3", "多余的内容 <"},
+		{"* 2", "第 1 个字符处缺少运算数"},
+		{"1 and or 2", "缺少运算数"},
+		{"sqrt(4)", "函数 sqrt 不存在"},
+		{"if(true, 1)", "函数 if 参数个数错误"},
+		{"ratio(1, 2, 3)", "函数 ratio 参数个数错误"},
+		{"coalesce()", "函数 coalesce 参数个数错误"},
+		{"round()", "函数 round 参数个数错误"},
+		{"abs(1,)", "表达式第 7 个字符处缺少运算数"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.src)
+		if err == nil {
+			t.Errorf("ParseExpr(%q) 应当出错", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseExpr(%q) 的错误为 %q，应包含 %q", tt.src, err.Error(), tt.want)
+		}
+	}
+}
+
+func TestExprBindMissingColumn(t *testing.T) {
+	e, err := ParseExpr("if(revenue > 0, profit, 0)")
+	if err != nil {
+		t.Fatalf("ParseExpr 出错: %v", err)
+	}
+	err = e.Bind([]string{"revenue"})
+	if err == nil || !strings.Contains(err.Error(), "引用的列 profit 不存在") {
+		t.Errorf("Bind 的错误为 %v，应提示列 profit 不存在", err)
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// fileSessionCookie 标识文件数据源会话的 Cookie
+const fileSessionCookie = "bi_session"
+
+// 会话 Cookie 为32位十六进制随机数
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// FileUploadOptions 上传文件表的选项，以 multipart 表单的 options 字段（JSON）提交
+type FileUploadOptions struct {
+	ImportFileOptions
+	Table       string               `json:"table"`             // 表名，为空时取文件名
+	Replace     bool                 `json:"replace"`           // 替换同名的表
+	StopOnError bool                 `json:"stopOnError"`       // 有值转换失败的行时不建表
+	PreviewRows int                  `json:"previewRows"`       // 预览行数
+	Columns     []ImportColumnOption `json:"columns,omitempty"` // 覆盖推断的列定义
+}
+
+// FileUploadResult 上传结果
+type FileUploadResult struct {
+	db.ImportStats
+	Table          *db.FileTable      `json:"table,omitempty"`
+	Columns        []ImportColumnInfo `json:"columns"`
+	TotalRows      int                `json:"totalRows"`
+	PreviewColumns []string           `json:"previewColumns"`
+	Preview        [][]interface{}    `json:"preview"`
+	Duration       string             `json:"duration"`
+}
+
+// fileSession 返回请求所属的文件数据源会话，没有会话 Cookie 时返回空字符串
+// 会话按用户隔离，同一 Cookie 换了用户也看不到之前用户的文件
+func fileSession(r *http.Request) string {
+	cookie, err := r.Cookie(fileSessionCookie)
+	if err != nil || !sessionIDPattern.MatchString(cookie.Value) {
+		return ""
+	}
+	return requestUser(r) + "/" + cookie.Value
+}
+
+// ensureFileSession 返回请求所属的会话，没有时生成新的会话 Cookie
+func ensureFileSession(w http.ResponseWriter, r *http.Request) (string, error) {
+	if session := fileSession(r); session != "" {
+		return session, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     fileSessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return requestUser(r) + "/" + id, nil
+}
+
+// FilesHandler 文件数据源: GET /api/files 列出当前会话的文件表，POST /api/files 上传文件建表
+// multipart 表单：file 为文件，options 为 FileUploadOptions 的JSON
+func FilesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"datasource": db.FileDataSource,
+			"tables":     db.ListFileTables(fileSession(r)),
+		})
+	case "POST":
+		uploadFileTable(w, r)
+	default:
+		http.Error(w, "只支持GET和POST请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// FileHandler 删除文件表: DELETE /api/files/{table}
+func FileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "只支持DELETE请求", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if !db.ValidIdentifier(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := db.DropFileTable(r.Context(), fileSession(r), name); err != nil {
+		log.Printf("删除文件表 %s 失败: %v", name, err)
+		if errors.Is(err, db.ErrTableNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadFileTable 解析上传的文件，推断列类型后在会话数据库中建表
+func uploadFileTable(w http.ResponseWriter, r *http.Request) {
+	startedAt := time.Now()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes+1<<20)
+	filename, data, err := readUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var opts FileUploadOptions
+	if raw := r.FormValue("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			http.Error(w, "上传选项格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts.Table = strings.TrimSpace(opts.Table)
+	if opts.Table == "" {
+		opts.Table = fileTableName(filename)
+	}
+	if !db.ValidIdentifier(opts.Table) {
+		http.Error(w, "表名不合法: "+opts.Table, http.StatusBadRequest)
+		return
+	}
+
+	table, err := readImportTable(filename, data, opts.ImportFileOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := importColumns(table, opts.Columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targets := activeImportColumns(columns)
+	if len(targets) == 0 {
+		http.Error(w, "没有要导入的列", http.StatusBadRequest)
+		return
+	}
+
+	result := &FileUploadResult{Columns: columns, TotalRows: len(table.Rows)}
+	rows, _ := convertImportRows(table, columns, &result.ImportStats)
+	result.PreviewColumns = make([]string, len(targets))
+	for i, c := range targets {
+		result.PreviewColumns[i] = c.Name
+	}
+	result.Preview = rows
+	if previewRows := previewRowLimit(opts.PreviewRows); len(rows) > previewRows {
+		result.Preview = rows[:previewRows]
+	}
+	if opts.StopOnError && result.Failed > 0 {
+		result.Duration = time.Since(startedAt).String()
+		writeImportResult(w, result)
+		return
+	}
+
+	session, err := ensureFileSession(w, r)
+	if err != nil {
+		http.Error(w, "创建会话失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("上传文件 %s 到文件数据源表 %s: %d 行", filename, opts.Table, len(rows))
+	created, err := db.CreateFileTable(r.Context(), session, db.FileTable{
+		Name:     opts.Table,
+		FileName: filename,
+		Sheet:    opts.Sheet,
+		Columns:  targets,
+	}, rows, opts.Replace)
+
+	queryResult := db.QueryResult{}
+	if err != nil {
+		queryResult.Error = err.Error()
+	} else {
+		queryResult.RowCount = int(created.Rows)
+	}
+	recordExecution(r, store.HistoryImport, db.QueryOptions{DataSource: db.FileDataSource, Query: db.InsertSQL(opts.Table, targets, 1)},
+		0, startedAt, queryResult)
+
+	if err != nil {
+		log.Printf("上传文件表错误: %v", err)
+		writeImportError(w, err)
+		return
+	}
+	result.Table = created
+	result.Inserted, result.Created, result.Committed = created.Rows, true, true
+	result.Duration = time.Since(startedAt).String()
+	writeImportResult(w, result)
+}
+
+// 文件名中不便于在SQL中书写的字符替换为下划线
+var fileTableNameReplacer = strings.NewReplacer(" ", "_", "-", "_", ".", "_", "(", "_", ")", "_")
+
+// fileTableName 由文件名生成默认表名，如 “销售 2024.xlsx” 生成 销售_2024
+func fileTableName(filename string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	name = fileTableNameReplacer.Replace(strings.TrimSpace(name))
+	for utf8.RuneCountInString(name) > 64 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if !db.ValidIdentifier(name) {
+		return "file_" + time.Now().Format("150405")
+	}
+	return name
+}
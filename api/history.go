@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// recordExecution 记录一次查询执行：写入执行历史，执行的是已保存查询时同时更新其最近执行统计。
+// 记录失败只写日志，不影响查询结果
+func recordExecution(r *http.Request, kind string, opts db.QueryOptions, savedQueryID int64, startedAt time.Time, result db.QueryResult) {
+	recordSavedQueryRun(r, savedQueryID, result)
+
+	dataSource := opts.DataSource
+	if ds, err := db.GetDataSource(dataSource); err == nil {
+		dataSource = ds.Config.Name
+	}
+	entry := &store.HistoryEntry{
+		QueryID:    opts.QueryID,
+		Kind:       kind,
+		User:       requestUser(r),
+		ClientIP:   clientIP(r),
+		DataSource: dataSource,
+		Query:      opts.Query,
+		StartedAt:  startedAt,
+		DurationMs: time.Since(startedAt).Milliseconds(),
+		RowCount:   int64(result.RowCount),
+		Truncated:  result.Truncated,
+		Error:      result.Error,
+		ErrorCode:  result.ErrorCode,
+	}
+	if savedQueryID > 0 {
+		entry.SavedQueryID = &savedQueryID
+	}
+	if len(opts.Params) > 0 {
+		if params, err := json.Marshal(opts.Params); err == nil {
+			entry.Params = string(params)
+		}
+	}
+
+	// 客户端断开后仍然记录
+	if err := store.RecordHistory(context.WithoutCancel(r.Context()), entry); err != nil {
+		log.Printf("记录执行历史失败: %v", err)
+	}
+}
+
+// clientIP 返回客户端地址。请求来自可信代理时，从 X-Forwarded-For 的末尾向前取第一个不是可信代理的地址，
+// 没有时取 X-Real-IP；否则取连接的对端地址
+func clientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if ip != "" && (i == 0 || !isTrustedProxy(ip)) {
+				return ip
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// HistoryHandler 分页查询执行历史
+// GET /api/history?user=&datasource=&kind=&sqlHash=&savedQueryId=&q=&status=&from=&to=&page=&pageSize=
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := historyFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := store.ListHistory(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// HistoryItemHandler 读取一条执行历史: GET /api/history/{id}
+func HistoryItemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/history/"), 10, 64)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	entry, err := store.GetHistory(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// historyFilter 解析执行历史的查询参数
+func historyFilter(r *http.Request) (store.HistoryFilter, error) {
+	params := r.URL.Query()
+	filter := store.HistoryFilter{
+		User:       params.Get("user"),
+		DataSource: params.Get("datasource"),
+		Kind:       params.Get("kind"),
+		SQLHash:    strings.ToLower(params.Get("sqlHash")),
+		Search:     params.Get("q"),
+		Status:     params.Get("status"),
+	}
+	if filter.Status != "" && filter.Status != "success" && filter.Status != "error" {
+		return filter, fmt.Errorf("status 只能是 success 或 error: %s", filter.Status)
+	}
+
+	var err error
+	if filter.SavedQueryID, err = int64Param(params.Get("savedQueryId")); err != nil {
+		return filter, fmt.Errorf("savedQueryId 格式错误: %v", err)
+	}
+	page, err := int64Param(params.Get("page"))
+	if err != nil {
+		return filter, fmt.Errorf("page 格式错误: %v", err)
+	}
+	pageSize, err := int64Param(params.Get("pageSize"))
+	if err != nil {
+		return filter, fmt.Errorf("pageSize 格式错误: %v", err)
+	}
+	filter.Page, filter.PageSize = int(page), int(pageSize)
+
+	if filter.From, err = timeParam(params.Get("from")); err != nil {
+		return filter, fmt.Errorf("from 格式错误: %v", err)
+	}
+	to := params.Get("to")
+	if filter.To, err = timeParam(to); err != nil {
+		return filter, fmt.Errorf("to 格式错误: %v", err)
+	}
+	if len(to) == len("2006-01-02") {
+		// 只给日期时包含当天
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func int64Param(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// timeParam 解析 RFC3339 时间或本地日期（2006-01-02）
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
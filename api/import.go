@@ -0,0 +1,385 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// 导入预览的默认和最大行数
+const (
+	defaultImportPreviewRows = 20
+	maxImportPreviewRows     = 200
+)
+
+// ImportOptions 导入选项，以 multipart 表单的 options 字段（JSON）提交
+type ImportOptions struct {
+	ImportFileOptions
+	DataSource  string               `json:"datasource"`
+	Table       string               `json:"table"`             // 目标表，为空时只返回推断的结构和预览
+	Mode        string               `json:"mode"`              // create（默认）或 append
+	DryRun      bool                 `json:"dryRun"`            // 只校验不写入
+	StopOnError bool                 `json:"stopOnError"`       // 有任何行出错时不写入任何数据
+	BatchSize   int                  `json:"batchSize"`         // 每条 INSERT 的行数
+	PreviewRows int                  `json:"previewRows"`       // 预览行数
+	Columns     []ImportColumnOption `json:"columns,omitempty"` // 覆盖推断的列定义
+}
+
+// ImportColumnOption 按文件中的列序号覆盖推断结果
+type ImportColumnOption struct {
+	Index    int    `json:"index"` // 从0开始
+	Name     string `json:"name,omitempty"`
+	SQLType  string `json:"sqlType,omitempty"`
+	Nullable *bool  `json:"nullable,omitempty"`
+	Skip     bool   `json:"skip,omitempty"` // 不导入该列
+}
+
+// ImportColumnInfo 文件中一列与目标列的对应关系
+type ImportColumnInfo struct {
+	db.ImportColumn
+	Index  int    `json:"index"`
+	Source string `json:"source"` // 文件中的表头
+	Skip   bool   `json:"skip,omitempty"`
+}
+
+// ImportResult 导入结果。DryRun 或未指定目标表时只包含推断的结构、预览和值转换错误
+type ImportResult struct {
+	db.ImportStats
+	DataSource     string             `json:"datasource,omitempty"`
+	Table          string             `json:"table,omitempty"`
+	Mode           string             `json:"mode"`
+	DryRun         bool               `json:"dryRun"`
+	Columns        []ImportColumnInfo `json:"columns"`
+	DDL            string             `json:"ddl,omitempty"` // create 方式的建表语句
+	TotalRows      int                `json:"totalRows"`     // 文件中的数据行数
+	PreviewColumns []string           `json:"previewColumns"`
+	Preview        [][]interface{}    `json:"preview"` // 转换后的前几行
+	Duration       string             `json:"duration"`
+}
+
+// ImportHandler 把上传的 XLSX/CSV 导入到数据源的表: POST /api/import
+// multipart 表单：file 为文件，options 为 ImportOptions 的JSON
+func ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	startedAt := time.Now()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes+1<<20)
+	filename, data, err := readUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var opts ImportOptions
+	if raw := r.FormValue("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			http.Error(w, "导入选项格式错误: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Mode == "" {
+		opts.Mode = db.ImportCreate
+	}
+	if opts.Mode != db.ImportCreate && opts.Mode != db.ImportAppend {
+		http.Error(w, "mode 只能是 create 或 append", http.StatusBadRequest)
+		return
+	}
+	opts.Table = strings.TrimSpace(opts.Table)
+	if opts.Table != "" && !db.ValidIdentifier(opts.Table) {
+		http.Error(w, "表名不合法: "+opts.Table, http.StatusBadRequest)
+		return
+	}
+
+	table, err := readImportTable(filename, data, opts.ImportFileOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := importColumns(table, opts.Columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := &ImportResult{
+		Table:     opts.Table,
+		Mode:      opts.Mode,
+		DryRun:    opts.DryRun || opts.Table == "",
+		Columns:   columns,
+		TotalRows: len(table.Rows),
+	}
+	if opts.Table != "" {
+		ds, err := db.GetDataSource(opts.DataSource)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result.DataSource = ds.Config.Name
+		if err := db.CheckWritable(ds.Config.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := resolveImportTarget(r, result); err != nil {
+			writeImportError(w, err)
+			return
+		}
+	}
+
+	targets := activeImportColumns(result.Columns)
+	if len(targets) == 0 {
+		http.Error(w, "没有要导入的列", http.StatusBadRequest)
+		return
+	}
+	if opts.Mode == db.ImportCreate && opts.Table != "" {
+		result.DDL = db.CreateTableSQL(opts.Table, targets)
+	}
+	rows, lines := convertImportRows(table, result.Columns, &result.ImportStats)
+
+	result.PreviewColumns = make([]string, len(targets))
+	for i, c := range targets {
+		result.PreviewColumns[i] = c.Name
+	}
+	result.Preview = rows
+	if previewRows := previewRowLimit(opts.PreviewRows); len(rows) > previewRows {
+		result.Preview = rows[:previewRows]
+	}
+
+	if result.DryRun || (opts.StopOnError && result.Failed > 0) {
+		result.Duration = time.Since(startedAt).String()
+		writeImportResult(w, result)
+		return
+	}
+
+	plan := db.ImportPlan{
+		DataSource:  result.DataSource,
+		Table:       opts.Table,
+		Mode:        opts.Mode,
+		Columns:     targets,
+		Rows:        rows,
+		Lines:       lines,
+		BatchSize:   opts.BatchSize,
+		StopOnError: opts.StopOnError,
+	}
+	log.Printf("导入文件 %s 到数据源 %s 表 %s (%s): %d 行", filename, result.DataSource, opts.Table, opts.Mode, len(rows))
+	stats, err := db.ImportRows(r.Context(), plan)
+
+	queryResult := db.QueryResult{}
+	if stats != nil {
+		mergeImportStats(&result.ImportStats, stats)
+		queryResult.RowCount = int(stats.Inserted)
+	}
+	if err != nil {
+		queryResult.Error = err.Error()
+	}
+	recordExecution(r, store.HistoryImport, db.QueryOptions{DataSource: result.DataSource, Query: db.InsertSQL(opts.Table, targets, 1)},
+		0, startedAt, queryResult)
+
+	if err != nil {
+		log.Printf("导入错误: %v", err)
+		writeImportError(w, err)
+		return
+	}
+	result.Duration = time.Since(startedAt).String()
+	writeImportResult(w, result)
+}
+
+// readUpload 读取 multipart 表单中的 file 字段
+func readUpload(r *http.Request) (string, []byte, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", nil, fmt.Errorf("文件不能超过 %d MB", maxImportBytes>>20)
+		}
+		return "", nil, fmt.Errorf("请求格式错误: %v", err)
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return "", nil, errors.New("缺少上传文件 file")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImportBytes+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("读取上传文件失败: %v", err)
+	}
+	if len(data) > maxImportBytes {
+		return "", nil, fmt.Errorf("文件不能超过 %d MB", maxImportBytes>>20)
+	}
+	return header.Filename, data, nil
+}
+
+// importColumns 推断列定义并应用请求中的覆盖
+func importColumns(table *importTable, overrides []ImportColumnOption) ([]ImportColumnInfo, error) {
+	inferred := inferImportColumns(table)
+	columns := make([]ImportColumnInfo, len(inferred))
+	for i, c := range inferred {
+		columns[i] = ImportColumnInfo{ImportColumn: c, Index: i, Source: table.Source[i]}
+	}
+
+	for _, o := range overrides {
+		if o.Index < 0 || o.Index >= len(columns) {
+			return nil, fmt.Errorf("列序号超出范围: %d", o.Index)
+		}
+		c := &columns[o.Index]
+		c.Skip = o.Skip
+		if name := strings.TrimSpace(o.Name); name != "" {
+			if !db.ValidIdentifier(name) {
+				return nil, fmt.Errorf("列名不合法: %s", o.Name)
+			}
+			c.Name = name
+		}
+		if o.SQLType != "" {
+			sqlType := strings.ToUpper(strings.TrimSpace(o.SQLType))
+			if !db.ValidSQLType(sqlType) {
+				return nil, fmt.Errorf("列 %s 的类型不合法: %s", c.Name, o.SQLType)
+			}
+			c.SQLType, c.Kind = sqlType, db.SQLTypeKind(sqlType)
+		}
+		if o.Nullable != nil {
+			c.Nullable = *o.Nullable
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range columns {
+		key := strings.ToLower(c.Name)
+		if !c.Skip && seen[key] {
+			return nil, fmt.Errorf("列名重复: %s", c.Name)
+		}
+		seen[key] = !c.Skip
+	}
+	return columns, nil
+}
+
+// resolveImportTarget 检查目标表：create 方式要求表不存在；append 方式按列名对应到已有的列，使用表中的类型
+func resolveImportTarget(r *http.Request, result *ImportResult) error {
+	existing, err := db.TableColumns(r.Context(), result.DataSource, result.Table)
+	if errors.Is(err, db.ErrTableNotFound) {
+		if result.Mode == db.ImportAppend {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if result.Mode == db.ImportCreate {
+		return fmt.Errorf("%w: %s，追加数据请使用 append 方式", db.ErrTableExists, result.Table)
+	}
+
+	byName := make(map[string]db.ColumnInfo, len(existing))
+	for _, c := range existing {
+		byName[strings.ToLower(c.Name)] = c
+	}
+	var missing []string
+	for i := range result.Columns {
+		c := &result.Columns[i]
+		if c.Skip {
+			continue
+		}
+		target, ok := byName[strings.ToLower(c.Name)]
+		if !ok {
+			missing = append(missing, c.Name)
+			continue
+		}
+		c.Name, c.SQLType, c.Kind, c.Nullable = target.Name, target.Type, db.SQLTypeKind(target.Type), target.Nullable
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: 表 %s 中没有列 %s，可以改名或跳过这些列", errImportColumns, result.Table, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// errImportColumns 文件的列与目标表不匹配
+var errImportColumns = errors.New("列不匹配")
+
+func activeImportColumns(columns []ImportColumnInfo) []db.ImportColumn {
+	var targets []db.ImportColumn
+	for _, c := range columns {
+		if !c.Skip {
+			targets = append(targets, c.ImportColumn)
+		}
+	}
+	return targets
+}
+
+// convertImportRows 把文件中的文本转换为写入的值，转换失败的行记录错误并跳过
+func convertImportRows(table *importTable, columns []ImportColumnInfo, stats *db.ImportStats) ([][]interface{}, []int) {
+	rows := make([][]interface{}, 0, len(table.Rows))
+	lines := make([]int, 0, len(table.Rows))
+	for i, record := range table.Rows {
+		row := make([]interface{}, 0, len(columns))
+		ok := true
+		for _, c := range columns {
+			if c.Skip {
+				continue
+			}
+			v, err := convertImportValue(c.Kind, record[c.Index])
+			if err == nil && v == nil && !c.Nullable {
+				err = errors.New("不能为空")
+			}
+			if err != nil {
+				stats.AddError(db.ImportRowError{Line: table.Lines[i], Column: c.Name, Value: record[c.Index], Error: err.Error()})
+				ok = false
+				break
+			}
+			row = append(row, v)
+		}
+		if ok {
+			rows = append(rows, row)
+			lines = append(lines, table.Lines[i])
+		}
+	}
+	return rows, lines
+}
+
+// mergeImportStats 合并写入阶段的结果，行错误接在值转换错误之后
+func mergeImportStats(result *db.ImportStats, stats *db.ImportStats) {
+	result.Inserted, result.Created, result.Committed = stats.Inserted, stats.Created, stats.Committed
+	for _, e := range stats.Errors {
+		result.AddError(e)
+	}
+	result.Failed += stats.Failed - int64(len(stats.Errors))
+}
+
+// previewRowLimit 计算预览行数
+func previewRowLimit(requested int) int {
+	if requested <= 0 {
+		return defaultImportPreviewRows
+	}
+	if requested > maxImportPreviewRows {
+		return maxImportPreviewRows
+	}
+	return requested
+}
+
+func writeImportResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeImportError 按错误类型返回状态码
+func writeImportError(w http.ResponseWriter, err error) {
+	var roErr *db.ReadOnlyError
+	switch {
+	case errors.As(err, &roErr):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, db.ErrTableExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrTableNotFound), errors.Is(err, errImportColumns), errors.Is(err, db.ErrUnknownDataSource):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
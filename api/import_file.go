@@ -0,0 +1,507 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"bi-web/db"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// maxImportBytes 上传文件的大小上限
+const maxImportBytes = 50 << 20
+
+// ImportFileOptions 上传文件的解析选项
+type ImportFileOptions struct {
+	Sheet     string `json:"sheet,omitempty"`     // XLSX 工作表，默认第一个
+	Delimiter string `json:"delimiter,omitempty"` // CSV 分隔符，默认根据首行自动识别
+	Encoding  string `json:"encoding,omitempty"`  // CSV 编码：utf-8 或 gbk，默认自动识别
+	NoHeader  bool   `json:"noHeader,omitempty"`  // 首行不是表头，列名使用 column_1、column_2...
+}
+
+// importTable 从上传文件读取的表格
+type importTable struct {
+	Header []string   // 处理后的列名
+	Source []string   // 文件中的原始表头，没有表头时为空
+	Rows   [][]string // 与 Header 等宽
+	Lines  []int      // 每行在文件中的行号
+}
+
+// 日期和时间的识别格式，Excel 单元格按显示格式读出
+var (
+	importDateLayouts = []string{"2006-01-02", "2006/01/02", "2006/1/2", "2006-1-2", "2006.1.2", "2006年1月2日", "01-02-06"}
+	importTimeLayouts = []string{"15:04:05.999999999", "15:04:05", "15:04"}
+)
+
+// readImportTable 按扩展名解析 CSV 或 XLSX 文件
+func readImportTable(filename string, data []byte, opts ImportFileOptions) (*importTable, error) {
+	var records [][]string
+	var lines []int
+	var err error
+
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".csv", ".tsv", ".txt":
+		records, lines, err = readCSVRecords(data, ext, opts)
+	case ".xlsx", ".xlsm":
+		records, lines, err = readXLSXRecords(data, opts.Sheet)
+	case ".xls":
+		return nil, errors.New("不支持 .xls 格式，请另存为 .xlsx 后上传")
+	default:
+		return nil, fmt.Errorf("不支持的文件格式: %s", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 去掉空行
+	table := &importTable{}
+	width := 0
+	for i, record := range records {
+		empty := true
+		for _, v := range record {
+			if strings.TrimSpace(v) != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			continue
+		}
+		table.Rows = append(table.Rows, record)
+		table.Lines = append(table.Lines, lines[i])
+		if len(record) > width {
+			width = len(record)
+		}
+	}
+	if len(table.Rows) == 0 {
+		return nil, errors.New("文件中没有数据")
+	}
+
+	var header []string
+	if !opts.NoHeader {
+		header = table.Rows[0]
+		table.Rows, table.Lines = table.Rows[1:], table.Lines[1:]
+	}
+	table.Header = importColumnNames(header, width)
+	table.Source = make([]string, width)
+	copy(table.Source, header)
+	for i, row := range table.Rows {
+		if len(row) < width {
+			table.Rows[i] = append(row, make([]string, width-len(row))...)
+		}
+	}
+	return table, nil
+}
+
+// readCSVRecords 读取 CSV/TSV，自动识别编码和分隔符
+func readCSVRecords(data []byte, ext string, opts ImportFileOptions) ([][]string, []int, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	switch strings.ToLower(opts.Encoding) {
+	case "", "auto":
+		if !utf8.Valid(data) {
+			data = decodeGB18030(data)
+		}
+	case "utf-8", "utf8":
+	case "gbk", "gb2312", "gb18030":
+		data = decodeGB18030(data)
+	default:
+		return nil, nil, fmt.Errorf("不支持的编码: %s", opts.Encoding)
+	}
+
+	delimiter, err := csvDelimiter(data, ext, opts.Delimiter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("解析CSV失败: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+	return records, lines, nil
+}
+
+// decodeGB18030 把中文 Windows 上常见的 GBK 编码转换为 UTF-8
+func decodeGB18030(data []byte) []byte {
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+// csvDelimiter 确定分隔符：指定时使用指定值，TSV 使用制表符，否则取首行中出现最多的候选字符
+func csvDelimiter(data []byte, ext, delimiter string) (rune, error) {
+	if delimiter != "" {
+		if delimiter == `\t` {
+			return '\t', nil
+		}
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return 0, fmt.Errorf("分隔符必须是单个字符: %q", delimiter)
+		}
+		return r, nil
+	}
+	if ext == ".tsv" {
+		return '\t', nil
+	}
+
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	best, bestCount := ',', 0
+	for _, r := range []rune{',', '\t', ';', '|'} {
+		if n := bytes.Count(firstLine, []byte(string(r))); n > bestCount {
+			best, bestCount = r, n
+		}
+	}
+	return best, nil
+}
+
+// readXLSXRecords 读取工作表的所有行，单元格按显示格式读出
+func readXLSXRecords(data []byte, sheet string) ([][]string, []int, error) {
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析XLSX失败: %v", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if sheet == "" && len(sheets) > 0 {
+		sheet = sheets[0]
+	}
+	if idx, err := f.GetSheetIndex(sheet); err != nil || idx < 0 {
+		return nil, nil, fmt.Errorf("工作表不存在: %s，可选: %s", sheet, strings.Join(sheets, ", "))
+	}
+
+	records, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取工作表 %s 失败: %v", sheet, err)
+	}
+	lines := make([]int, len(records))
+	for i := range records {
+		lines[i] = i + 1
+	}
+	return records, lines, nil
+}
+
+// importColumnNames 根据表头生成列名：去掉首尾空白，空列名和不合法的列名用 column_N 代替，重名时加后缀
+func importColumnNames(header []string, width int) []string {
+	names := make([]string, width)
+	used := make(map[string]bool, width)
+	for i := 0; i < width; i++ {
+		name := ""
+		if i < len(header) {
+			name = strings.Join(strings.Fields(header[i]), " ")
+			if utf8.RuneCountInString(name) > 60 {
+				name = string([]rune(name)[:60])
+			}
+		}
+		if !db.ValidIdentifier(name) {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		// MySQL 列名不区分大小写
+		unique := name
+		for n := 2; used[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		used[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return names
+}
+
+// inferImportColumns 根据每列的全部值推断类型，空值视为 NULL
+func inferImportColumns(table *importTable) []db.ImportColumn {
+	columns := make([]db.ImportColumn, len(table.Header))
+	for i, name := range table.Header {
+		columns[i] = inferImportColumn(name, table.Rows, i)
+	}
+	return columns
+}
+
+func inferImportColumn(name string, rows [][]string, index int) db.ImportColumn {
+	col := db.ImportColumn{Name: name}
+	isInt, isDecimal, isFloat, isBool, isDate, isDateTime := true, true, true, true, true, true
+	intDigits, scale, maxLen, maxBytes, values := 0, 0, 0, 0, 0
+
+	for _, row := range rows {
+		v := strings.TrimSpace(row[index])
+		if v == "" {
+			col.Nullable = true
+			continue
+		}
+		values++
+		if n := utf8.RuneCountInString(row[index]); n > maxLen {
+			maxLen = n
+		}
+		if len(row[index]) > maxBytes {
+			maxBytes = len(row[index])
+		}
+
+		num, numeric := normalizeNumber(v)
+		if numeric && hasLeadingZero(num) {
+			// 编号、邮编等带前导零的数字按文本保存
+			numeric, isFloat = false, false
+		}
+		if isInt && (!numeric || strings.Contains(num, ".") || !validInteger(num)) {
+			isInt = false
+		}
+		if isDecimal {
+			if !numeric {
+				isDecimal = false
+			} else {
+				whole, frac, _ := strings.Cut(strings.TrimPrefix(num, "-"), ".")
+				intDigits, scale = maxInt(intDigits, len(whole)), maxInt(scale, len(frac))
+			}
+		}
+		if isFloat {
+			if !numeric && !isFloatText(v) {
+				isFloat = false
+			}
+		}
+		if isBool {
+			_, isBool = parseImportBool(v)
+		}
+		if isDate {
+			_, isDate = parseImportDate(v)
+		}
+		if isDateTime {
+			// 日期和日期时间混合的列按日期时间保存，日期的时间部分为零点
+			if _, ok := parseImportDateTime(v); !ok {
+				_, isDateTime = parseImportDate(v)
+			}
+		}
+	}
+
+	switch {
+	case values == 0:
+		col.Kind, col.SQLType = db.KindString, "VARCHAR(255)"
+		col.Nullable = true
+	case isInt:
+		col.Kind, col.SQLType = db.KindInteger, "BIGINT"
+	case isDecimal && intDigits+scale <= 65 && scale <= 30:
+		col.Kind, col.SQLType = db.KindDecimal, fmt.Sprintf("DECIMAL(%d,%d)", maxInt(intDigits+scale, 1), scale)
+	case isFloat:
+		col.Kind, col.SQLType = db.KindFloat, "DOUBLE"
+	case isBool:
+		col.Kind, col.SQLType = db.KindBoolean, "BOOLEAN"
+	case isDate:
+		col.Kind, col.SQLType = db.KindDate, "DATE"
+	case isDateTime:
+		col.Kind, col.SQLType = db.KindDateTime, "DATETIME(6)"
+	case maxLen <= 255:
+		col.Kind, col.SQLType = db.KindString, "VARCHAR(255)"
+	case maxBytes <= 65535:
+		col.Kind, col.SQLType = db.KindString, "TEXT"
+	default:
+		col.Kind, col.SQLType = db.KindString, "MEDIUMTEXT"
+	}
+	return col
+}
+
+// normalizeNumber 识别十进制数字，允许千分位逗号，返回去掉逗号的文本
+func normalizeNumber(v string) (string, bool) {
+	s := strings.TrimPrefix(v, "-")
+	if strings.Contains(s, ",") {
+		whole, frac, hasFrac := strings.Cut(s, ".")
+		groups := strings.Split(whole, ",")
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return "", false
+		}
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return "", false
+			}
+		}
+		s = strings.Join(groups, "")
+		if hasFrac {
+			s += "." + frac
+		}
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || !allDigits(whole) || (hasFrac && (frac == "" || !allDigits(frac))) {
+		return "", false
+	}
+	if strings.HasPrefix(v, "-") {
+		s = "-" + s
+	}
+	return s, true
+}
+
+// validInteger 可以作为 BIGINT 的整数文本
+func validInteger(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// hasLeadingZero 整数部分是否有多余的前导零，如 007、00.5
+func hasLeadingZero(num string) bool {
+	whole, _, _ := strings.Cut(strings.TrimPrefix(num, "-"), ".")
+	return len(whole) > 1 && whole[0] == '0'
+}
+
+// isFloatText 科学计数法等浮点数文本，不接受 NaN、Inf 和十六进制
+func isFloatText(v string) bool {
+	if strings.ContainsAny(strings.ToLower(v), "nix_") {
+		return false
+	}
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func parseImportBool(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "true", "是":
+		return true, true
+	case "false", "否":
+		return false, true
+	}
+	return false, false
+}
+
+func parseImportDate(v string) (time.Time, bool) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseImportDateTime 解析日期加时间，也接受 RFC3339
+func parseImportDateTime(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	datePart, timePart, ok := strings.Cut(strings.Replace(v, "T", " ", 1), " ")
+	if !ok {
+		return time.Time{}, false
+	}
+	date, ok := parseImportDate(datePart)
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(timePart)); err == nil {
+			return date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// convertImportValue 按列的归类把文本转换为写入数据库的值，空文本为 NULL
+func convertImportValue(kind, v string) (interface{}, error) {
+	s := strings.TrimSpace(v)
+	if s == "" {
+		return nil, nil
+	}
+
+	switch kind {
+	case db.KindInteger:
+		num, ok := normalizeNumber(s)
+		if !ok {
+			return nil, errors.New("不是整数")
+		}
+		if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+			return n, nil
+		}
+		if n, err := strconv.ParseUint(num, 10, 64); err == nil {
+			return n, nil
+		}
+		return nil, errors.New("不是整数或超出范围")
+	case db.KindDecimal:
+		num, ok := normalizeNumber(s)
+		if !ok {
+			// 科学计数法等格式转换为精确的十进制文本
+			r, valid := new(big.Rat).SetString(s)
+			if !valid {
+				return nil, errors.New("不是数字")
+			}
+			num = r.FloatString(30)
+			num = strings.TrimRight(strings.TrimRight(num, "0"), ".")
+		}
+		return num, nil
+	case db.KindFloat:
+		if num, ok := normalizeNumber(s); ok {
+			s = num
+		}
+		if !isFloatText(s) {
+			return nil, errors.New("不是数字")
+		}
+		return strconv.ParseFloat(s, 64)
+	case db.KindBoolean:
+		if b, ok := parseImportBool(s); ok {
+			if b {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		if s == "0" || s == "1" {
+			return strconv.Atoi(s)
+		}
+		return nil, errors.New("不是布尔值")
+	case db.KindDate:
+		if t, ok := parseImportDate(s); ok {
+			return t.Format("2006-01-02"), nil
+		}
+		if t, ok := parseImportDateTime(s); ok {
+			return t.Format("2006-01-02"), nil
+		}
+		return nil, errors.New("不是日期")
+	case db.KindDateTime:
+		if t, ok := parseImportDateTime(s); ok {
+			return t.Format("2006-01-02 15:04:05.999999"), nil
+		}
+		if t, ok := parseImportDate(s); ok {
+			return t.Format("2006-01-02 15:04:05"), nil
+		}
+		return nil, errors.New("不是日期时间")
+	default:
+		// 文本保留原值
+		return v, nil
+	}
+}
@@ -10,8 +10,12 @@ import (
 )
 
 // MergeRequest 合并查询请求结构
+// queries 为客户端已获取的查询结果；sources 为由服务端执行的查询，二者只能使用一种
 type MergeRequest struct {
-	Queries []db.QueryResult `json:"queries"`
+	MergeOptions
+	Queries     []db.QueryResult `json:"queries,omitempty"`
+	Sources     []MergeSource    `json:"sources,omitempty"`
+	Concurrency int              `json:"concurrency,omitempty"` // 同时执行的查询数，默认且最多为4
 }
 
 // MergeHandler 处理合并查询请求
@@ -22,101 +26,215 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req MergeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // 保持参数和结果中数字的原始精度
+	if err := decoder.Decode(&req); err != nil {
 		http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if len(req.Queries) > 0 && len(req.Sources) > 0 {
+		http.Error(w, "queries 和 sources 只能使用一种", http.StatusBadRequest)
+		return
+	}
+	if err := req.MergeOptions.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queries, labels := req.Queries, make([]string, len(req.Queries))
+	for i := range labels {
+		labels[i] = mergeLabel("", i)
+	}
+	var sourceStats []MergeQueryStat
+	if len(req.Sources) > 0 {
+		opts, err := prepareMergeSources(r, req.Sources)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("服务端合并查询: 执行 %d 个查询", len(req.Sources))
+		queries = executeMergeSources(r, req.Sources, opts, req.Concurrency)
+		sourceStats = mergeSourceStats(req.Sources, opts, queries)
+		labels = make([]string, len(req.Sources))
+		for i, src := range req.Sources {
+			labels[i] = src.Label
+		}
+	} else {
+		log.Printf("合并查询: %d 个查询结果", len(req.Queries))
+	}
 
-	log.Printf("合并查询: %d 个查询结果", len(req.Queries))
-	
 	// 处理合并逻辑
-	result := mergeResults(req.Queries)
-	
+	result := mergeResults(queries, labels, req.MergeOptions)
+	if sourceStats != nil {
+		result["queryStats"] = sourceStats
+		if failed := failedMergeQueries(sourceStats); failed == len(sourceStats) {
+			result = map[string]interface{}{"error": "所有查询都执行失败", "queryStats": sourceStats}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
 
-// 合并查询结果
-func mergeResults(queries []db.QueryResult) map[string]interface{} {
+// 合并查询结果，labels 为各查询的名称，opts 由调用方校验
+func mergeResults(queries []db.QueryResult, labels []string, opts MergeOptions) map[string]interface{} {
 	if len(queries) == 0 {
 		return map[string]interface{}{
 			"error": "没有可合并的查询结果",
 		}
 	}
 
-	// 收集所有标签（第一列）
-	allLabels := make(map[string]bool)
-	for _, query := range queries {
-		if len(query.Rows) == 0 {
-			continue
+	// 确定每个查询的连接键和值列
+	inputs := make([]*mergeInput, len(queries))
+	width := 0
+	keyCount := -1
+	var queryStats []MergeQueryStat
+	truncated := false
+	for i, query := range queries {
+		in, err := resolveMergeInput(labels[i], query, opts)
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}
 		}
-		
-		for _, row := range query.Rows {
-			if len(row) == 0 {
-				continue
+		if !in.failed {
+			if keyCount >= 0 && len(in.keyIdx) != keyCount {
+				return map[string]interface{}{"error": fmt.Sprintf("查询 %s 的连接键列数与其他查询不一致", in.label)}
 			}
-			
-			label := fmt.Sprintf("%v", row[0])
-			allLabels[label] = true
+			keyCount = len(in.keyIdx)
 		}
+		in.offset = width
+		width += len(in.values)
+		inputs[i] = in
+		truncated = truncated || query.Truncated
+
+		// 收集查询统计信息
+		queryStats = append(queryStats, MergeQueryStat{
+			QueryIndex: i + 1,
+			Label:      labels[i],
+			RowCount:   query.RowCount,
+			Truncated:  query.Truncated,
+			Duration:   query.Duration,
+		})
 	}
 
-	// 准备合并结果
-	mergedColumns := []string{"标签"}
-	var queryStats []map[string]interface{}
-	
-	for i, query := range queries {
-		if len(query.Columns) > 1 {
-			mergedColumns = append(mergedColumns, query.Columns[1])
-		} else {
-			mergedColumns = append(mergedColumns, fmt.Sprintf("查询 %d", i+1))
+	// 键列名称：指定了 keys 时使用 keys，否则沿用第一个成功查询的列名；都未指定时为“标签”
+	keyNames := opts.Keys
+	if len(keyNames) == 0 {
+		keyNames = []string{defaultMergeKeyName}
+		if len(opts.QueryKeys) > 0 {
+			for _, in := range inputs {
+				if !in.failed {
+					keyNames = make([]string, len(in.keyIdx))
+					for j, k := range in.keyIdx {
+						keyNames[j] = in.result.Columns[k]
+					}
+					break
+				}
+			}
 		}
-		
-		// 收集查询统计信息
-		stat := map[string]interface{}{
-			"queryIndex": i + 1,
-			"rowCount":   query.RowCount,
+	}
+	valueNames := mergedColumnNames(inputs, keyNames)
+	mergedColumns := append(append([]string{}, keyNames...), valueNames...)
+
+	// 时间序列合并：先把时间键归入时间段再连接
+	var timeInfo *TimeSeriesInfo
+	timeKey := 0
+	if ts := opts.TimeSeries; ts != nil {
+		var err error
+		if timeKey, err = ts.timeKeyIndex(keyNames); err != nil {
+			return map[string]interface{}{"error": err.Error()}
 		}
-		if query.Duration != "" {
-			stat["duration"] = query.Duration
+		timeInfo = &TimeSeriesInfo{
+			Key:         keyNames[timeKey],
+			Granularity: ts.Granularity,
+			Timezone:    ts.loc.String(),
+			Fill:        ts.Fill,
+			Aggregate:   ts.Aggregate,
+			SkippedRows: bucketMergeInputs(inputs, timeKey, ts),
 		}
-		queryStats = append(queryStats, stat)
 	}
 
-	// 构建合并行数据
 	var mergedRows [][]interface{}
-	for label := range allLabels {
-		row := []interface{}{label}
-		
-		for _, query := range queries {
-			value := findValueForLabelInQuery(query, label)
-			row = append(row, value)
+	var types []db.ColumnType
+	var compareInfo *ComparisonInfo
+	if opts.Compare != nil {
+		// 对比模式：当前期和基准期按位置或偏移后的日期对齐
+		var err error
+		mergedColumns, mergedRows, types, compareInfo, err = compareMergeInputs(inputs, keyNames, opts.Compare)
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+	} else {
+		// 构建合并行数据
+		mergedRows = make([][]interface{}, 0)
+		for _, r := range joinMergeInputs(inputs, width, opts.Join) {
+			row := make([]interface{}, 0, len(mergedColumns))
+			row = append(row, r.keys...)
+			row = append(row, r.values...)
+			mergedRows = append(mergedRows, row)
+		}
+		types = mergedColumnTypes(inputs, keyNames, valueNames)
+		if ts := opts.TimeSeries; ts != nil {
+			fillable := fillableColumns(inputs, width, types, len(keyNames), ts.Fill)
+			rows, buckets, err := fillTimeSeries(mergedRows, len(keyNames), timeKey, fillable, ts)
+			if err != nil {
+				return map[string]interface{}{"error": err.Error()}
+			}
+			mergedRows = rows
+			timeInfo.Buckets = len(buckets)
+			if len(buckets) > 0 {
+				timeInfo.Start, timeInfo.End = buckets[0], buckets[len(buckets)-1]
+			}
+			if types != nil {
+				types[timeKey] = ts.keyColumnType(keyNames[timeKey])
+			}
+		}
+	}
+	if len(opts.Derived) > 0 {
+		var err error
+		if mergedColumns, types, err = applyDerivedColumns(mergedColumns, mergedRows, types, opts.Derived); err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+	}
+	if opts.Sort != nil {
+		if err := sortMergedRows(mergedRows, mergedColumns, len(keyNames), types, opts.Sort); err != nil {
+			return map[string]interface{}{"error": err.Error()}
 		}
-		
-		mergedRows = append(mergedRows, row)
 	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"columns":            mergedColumns,
 		"rows":               mergedRows,
+		"keys":               keyNames,
+		"join":               opts.Join,
 		"visualizationTypes": []string{"table", "bar", "line"},
 		"merged":             true,
 		"queryStats":         queryStats,
 	}
+	if truncated {
+		// 合并结果只基于被截断查询的前若干行
+		result["truncated"] = true
+	}
+	if types != nil {
+		result["columnTypes"] = types
+	}
+	if timeInfo != nil {
+		result["timeSeries"] = timeInfo
+	}
+	if compareInfo != nil {
+		delete(result, "join")
+		result["comparison"] = compareInfo
+		result["visualizationTypes"] = []string{"table", "bar", "line", "comparison"}
+	}
+	return result
 }
 
-// 查找标签对应的值
-func findValueForLabelInQuery(query db.QueryResult, label string) interface{} {
-	for _, row := range query.Rows {
-		if len(row) == 0 {
-			continue
-		}
-		
-		rowLabel := fmt.Sprintf("%v", row[0])
-		if rowLabel == label && len(row) > 1 {
-			return row[1]
+// failedMergeQueries 统计执行失败的查询数
+func failedMergeQueries(stats []MergeQueryStat) int {
+	failed := 0
+	for _, stat := range stats {
+		if stat.Error != "" {
+			failed++
 		}
 	}
-	
-	return nil
-}
\ No newline at end of file
+	return failed
+}
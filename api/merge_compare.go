@@ -0,0 +1,303 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+)
+
+// 对比模式中两个查询的对齐方式
+const (
+	AlignPosition = "position" // 按行的位置对齐：当前期第 i 行与基准期第 i 行
+	AlignDate     = "date"     // 按日期对齐：当前期的时间键减去偏移后与基准期的时间键匹配
+)
+
+// 对比方向
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	DirectionFlat = "flat"
+)
+
+// 偏移的格式：数字加单位，h 小时、d 天、w 周、m 月、y 年
+var compareOffsetPattern = regexp.MustCompile(`^(\d+)\s*([hdwmy])$`)
+
+// MergeCompare 环比/同比对比：第一个查询为当前期，第二个查询为基准期
+type MergeCompare struct {
+	Align    string `json:"align,omitempty"`    // position（默认）或 date
+	Offset   string `json:"offset,omitempty"`   // 按日期对齐时基准期相对当前期提前的时间，如 7d、1w、1m、1y，默认 7d
+	Key      string `json:"key,omitempty"`      // 按日期对齐时的时间键，需是连接键之一，默认第一个连接键
+	Timezone string `json:"timezone,omitempty"` // 按日期对齐时解析和偏移时间键使用的 IANA 时区名，默认服务器时区
+
+	amount int
+	unit   string
+	loc    *time.Location
+}
+
+// ComparisonSeries 一个值列的对比结果所在的列，供图表区分当前期、基准期和差值序列
+type ComparisonSeries struct {
+	Column    string `json:"column"`
+	Current   string `json:"current"`
+	Baseline  string `json:"baseline"`
+	Delta     string `json:"delta"`
+	PctChange string `json:"pctChange"`
+	Direction string `json:"direction"`
+}
+
+// ComparisonInfo 响应中对比模式的说明
+type ComparisonInfo struct {
+	Current  string             `json:"current"`  // 当前期查询的名称
+	Baseline string             `json:"baseline"` // 基准期查询的名称
+	Align    string             `json:"align"`
+	Offset   string             `json:"offset,omitempty"`
+	Timezone string             `json:"timezone,omitempty"`
+	Matched  int                `json:"matched"` // 找到基准期的行数
+	Series   []ComparisonSeries `json:"series"`
+}
+
+func (c *MergeCompare) validate() error {
+	switch c.Align {
+	case "":
+		c.Align = AlignPosition
+	case AlignPosition:
+	case AlignDate:
+		if c.Offset == "" {
+			c.Offset = "7d"
+		}
+		m := compareOffsetPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(c.Offset)))
+		if m == nil {
+			return fmt.Errorf("偏移格式错误: %s，应为数字加单位 h、d、w、m 或 y，如 7d", c.Offset)
+		}
+		c.amount, _ = strconv.Atoi(m[1])
+		c.unit = m[2]
+
+		c.loc = time.Local
+		if c.Timezone != "" {
+			loc, err := time.LoadLocation(c.Timezone)
+			if err != nil {
+				return fmt.Errorf("时区无效: %s", c.Timezone)
+			}
+			c.loc = loc
+		}
+	default:
+		return fmt.Errorf("不支持的对齐方式: %s，只能是 position 或 date", c.Align)
+	}
+	return nil
+}
+
+// shift 返回当前期时间对应的基准期时间，按天及以上的偏移在时间所在的时区计算，跨夏令时的日期仍然对齐
+func (c *MergeCompare) shift(t time.Time) time.Time {
+	switch c.unit {
+	case "h":
+		return t.Add(-time.Duration(c.amount) * time.Hour)
+	case "w":
+		return t.AddDate(0, 0, -7*c.amount)
+	case "m":
+		return t.AddDate(0, -c.amount, 0)
+	case "y":
+		return t.AddDate(-c.amount, 0, 0)
+	default:
+		return t.AddDate(0, 0, -c.amount)
+	}
+}
+
+// compareKey 按日期对齐时用于匹配的键：时间统一为 UTC，其余键原样拼接
+func compareKey(row []interface{}, keyIdx []int, timeKey int, t time.Time) string {
+	parts := make([]string, len(keyIdx))
+	for j, i := range keyIdx {
+		if j == timeKey {
+			parts[j] = t.UTC().Format(time.RFC3339Nano)
+		} else {
+			parts[j] = fmt.Sprintf("%v", cell(row, i))
+		}
+	}
+	return strings.Join(parts, "\x1f")
+}
+
+// compareMergeInputs 对齐当前期和基准期，每个值列输出当前期、基准期、差值、变化百分比和方向五列。
+// 结果的行与当前期的行一一对应，没有对应基准期的行基准期为空
+func compareMergeInputs(inputs []*mergeInput, keyNames []string, c *MergeCompare) ([]string, [][]interface{}, []db.ColumnType, *ComparisonInfo, error) {
+	if len(inputs) != 2 {
+		return nil, nil, nil, nil, fmt.Errorf("对比模式需要正好两个查询，第一个为当前期，第二个为基准期")
+	}
+	cur, base := inputs[0], inputs[1]
+	for _, in := range inputs {
+		if in.failed {
+			return nil, nil, nil, nil, fmt.Errorf("对比模式需要两个查询都执行成功，查询 %s 失败", in.label)
+		}
+	}
+
+	info := &ComparisonInfo{Current: cur.label, Baseline: base.label, Align: c.Align}
+	timeKey := 0
+	if c.Align == AlignDate {
+		info.Offset = c.Offset
+		info.Timezone = c.loc.String()
+		if c.Key != "" {
+			if timeKey = columnIndex(keyNames, c.Key); timeKey < 0 {
+				return nil, nil, nil, nil, fmt.Errorf("时间键 %s 不是连接键", c.Key)
+			}
+		}
+	}
+
+	// 值列按名称配对，只比较两个查询中都有的列
+	type pair struct{ cur, base int }
+	var pairs []pair
+	for j, name := range cur.values {
+		if k := columnIndex(base.values, name); k >= 0 && cur.valueIdx[j] >= 0 && base.valueIdx[k] >= 0 {
+			pairs = append(pairs, pair{cur.valueIdx[j], base.valueIdx[k]})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, nil, nil, nil, fmt.Errorf("两个查询没有同名的值列可以对比")
+	}
+
+	// 为当前期的每一行找到基准期的行
+	matches := make([][]interface{}, len(cur.result.Rows))
+	if c.Align == AlignDate {
+		byKey := make(map[string][]interface{}, len(base.result.Rows))
+		for _, row := range base.result.Rows {
+			if t, ok := parseMergeTime(cell(row, base.keyIdx[timeKey]), c.loc); ok {
+				key := compareKey(row, base.keyIdx, timeKey, t)
+				if _, dup := byKey[key]; !dup {
+					byKey[key] = row
+				}
+			}
+		}
+		for i, row := range cur.result.Rows {
+			if t, ok := parseMergeTime(cell(row, cur.keyIdx[timeKey]), c.loc); ok {
+				matches[i] = byKey[compareKey(row, cur.keyIdx, timeKey, c.shift(t))]
+			}
+		}
+	} else {
+		for i := range cur.result.Rows {
+			if i < len(base.result.Rows) {
+				matches[i] = base.result.Rows[i]
+			}
+		}
+	}
+
+	// 列：当前期的键、基准期的键，然后每个值列五列
+	columns := append([]string{}, keyNames...)
+	for _, name := range keyNames {
+		columns = append(columns, base.label+"."+name)
+	}
+	for _, p := range pairs {
+		name := cur.result.Columns[p.cur]
+		s := ComparisonSeries{
+			Column:    name,
+			Current:   cur.label + "." + name,
+			Baseline:  base.label + "." + name,
+			Delta:     name + ".delta",
+			PctChange: name + ".pct_change",
+			Direction: name + ".direction",
+		}
+		info.Series = append(info.Series, s)
+		columns = append(columns, s.Current, s.Baseline, s.Delta, s.PctChange, s.Direction)
+	}
+
+	rows := make([][]interface{}, len(cur.result.Rows))
+	for i, row := range cur.result.Rows {
+		out := make([]interface{}, 0, len(columns))
+		for _, k := range cur.keyIdx {
+			out = append(out, cell(row, k))
+		}
+		baseRow := matches[i]
+		for _, k := range base.keyIdx {
+			out = append(out, cell(baseRow, k))
+		}
+		if baseRow != nil {
+			info.Matched++
+		}
+		for _, p := range pairs {
+			curValue, baseValue := cell(row, p.cur), cell(baseRow, p.base)
+			out = append(out, curValue, baseValue,
+				subtract(curValue, baseValue), percentChange(curValue, baseValue), direction(curValue, baseValue))
+		}
+		rows[i] = out
+	}
+
+	var types []db.ColumnType
+	if len(cur.result.ColumnTypes) == len(cur.result.Columns) && len(base.result.ColumnTypes) == len(base.result.Columns) {
+		for j, k := range cur.keyIdx {
+			t := cur.result.ColumnTypes[k]
+			t.Name = columns[j]
+			types = append(types, t)
+		}
+		for j, k := range base.keyIdx {
+			t := base.result.ColumnTypes[k]
+			t.Name = columns[len(keyNames)+j]
+			types = append(types, t)
+		}
+		for n, p := range pairs {
+			s := info.Series[n]
+			curType, baseType := cur.result.ColumnTypes[p.cur], base.result.ColumnTypes[p.base]
+			curType.Name, baseType.Name = s.Current, s.Baseline
+			types = append(types, curType, baseType,
+				deltaType(s.Delta, curType, baseType),
+				db.ColumnType{Name: s.PctChange, Kind: db.KindFloat},
+				db.ColumnType{Name: s.Direction, Kind: db.KindString})
+		}
+	}
+	return columns, rows, types, info, nil
+}
+
+// cell 返回行中的一个值，行为空或列不存在时返回 nil
+func cell(row []interface{}, i int) interface{} {
+	if i < 0 || i >= len(row) {
+		return nil
+	}
+	return row[i]
+}
+
+// deltaType 差值列的类型：两个值列都是整数或 DECIMAL 时差值精确计算，沿用小数位数较多的列的类型，否则为 float
+func deltaType(name string, cur, base db.ColumnType) db.ColumnType {
+	exact := func(t db.ColumnType) bool { return t.Kind == db.KindInteger || t.Kind == db.KindDecimal }
+	if !exact(cur) || !exact(base) {
+		return db.ColumnType{Name: name, Kind: db.KindFloat}
+	}
+	scale := func(t db.ColumnType) int64 {
+		if t.Scale == nil {
+			return 0
+		}
+		return *t.Scale
+	}
+	t := cur
+	if base.Kind == db.KindDecimal && (cur.Kind == db.KindInteger || scale(base) > scale(cur)) {
+		t = base
+	}
+	// 差值可能超出原列的精度，没有基准期时为空
+	t.Name, t.Precision, t.Nullable = name, nil, nil
+	return t
+}
+
+// subtract 当前期减基准期，任一方不是数值时为空。整数和 DECIMAL 精确计算，保持当前期值的表示方式
+func subtract(cur, base interface{}) interface{} {
+	if v, ok := exactArith("-", cur, base); ok {
+		return v
+	}
+	x, ok1 := exprNumber(cur)
+	y, ok2 := exprNumber(base)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	return x - y
+}
+
+// direction 变化方向：up、down 或 flat，任一方不是数值时为空
+func direction(cur, base interface{}) interface{} {
+	c, ok := compareNumbers(cur, base)
+	switch {
+	case !ok:
+		return nil
+	case c > 0:
+		return DirectionUp
+	case c < 0:
+		return DirectionDown
+	default:
+		return DirectionFlat
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bi-web/db"
+)
+
+// maxDerivedColumns 一次合并最多计算的派生列数
+const maxDerivedColumns = 20
+
+// DerivedColumn 在合并结果上按表达式计算的列，如 {"name": "conversion", "expr": "ratio(orders, visits)"}
+// 表达式可以引用合并结果中的列以及在它之前定义的派生列
+type DerivedColumn struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+
+	expr *Expr
+}
+
+// validateDerivedColumns 检查派生列的名称并解析表达式
+func validateDerivedColumns(derived []DerivedColumn) error {
+	if len(derived) > maxDerivedColumns {
+		return fmt.Errorf("最多定义 %d 个派生列", maxDerivedColumns)
+	}
+	names := make(map[string]bool, len(derived))
+	for i := range derived {
+		d := &derived[i]
+		d.Name = strings.TrimSpace(d.Name)
+		if d.Name == "" {
+			return fmt.Errorf("第 %d 个派生列缺少名称", i+1)
+		}
+		if names[strings.ToLower(d.Name)] {
+			return fmt.Errorf("派生列名称重复: %s", d.Name)
+		}
+		names[strings.ToLower(d.Name)] = true
+
+		expr, err := ParseExpr(d.Expr)
+		if err != nil {
+			return fmt.Errorf("派生列 %s: %w", d.Name, err)
+		}
+		d.expr = expr
+	}
+	return nil
+}
+
+// applyDerivedColumns 依次计算派生列并追加到每一行的末尾，types 为空时不生成列类型
+func applyDerivedColumns(columns []string, rows [][]interface{}, types []db.ColumnType, derived []DerivedColumn) ([]string, []db.ColumnType, error) {
+	for _, d := range derived {
+		if columnIndex(columns, d.Name) >= 0 {
+			return nil, nil, fmt.Errorf("派生列 %s 与已有的列重名", d.Name)
+		}
+		if err := d.expr.Bind(columns); err != nil {
+			return nil, nil, fmt.Errorf("派生列 %s: %w", d.Name, err)
+		}
+
+		kind := ""
+		for i, row := range rows {
+			v := d.expr.Eval(row)
+			rows[i] = append(row, v)
+			kind = derivedKind(kind, v, d.expr.numeric())
+		}
+		columns = append(columns, d.Name)
+		if types != nil {
+			if kind == "" {
+				kind = db.KindFloat
+			}
+			types = append(types, db.ColumnType{Name: d.Name, Kind: kind})
+		}
+	}
+	return columns, types, nil
+}
+
+// derivedKind 由派生列的值确定列类型：全部是数值时按精确计算的结果为 integer 或 decimal，有 float64 时为 float；
+// 全部是布尔值时为 boolean，否则为 string。numeric 为表达式的结果是否一定是数值，此时文本是精确计算的 DECIMAL
+func derivedKind(kind string, v interface{}, numeric bool) string {
+	var k string
+	switch t := v.(type) {
+	case nil:
+		return kind
+	case float64:
+		k = db.KindFloat
+	case int, int64, uint64:
+		k = db.KindInteger
+	case json.Number:
+		k = db.KindInteger
+		if strings.ContainsAny(t.String(), ".eE") {
+			k = db.KindDecimal
+		}
+	case db.TaggedNumber:
+		k = db.KindDecimal
+		if t.Type == "bigint" {
+			k = db.KindInteger
+		}
+	case bool:
+		k = db.KindBoolean
+	case string, map[string]interface{}:
+		k = db.KindString
+		if numeric {
+			k = db.KindDecimal
+		}
+	default:
+		k = db.KindString
+	}
+	switch {
+	case kind == "" || kind == k:
+		return k
+	case kind == db.KindBoolean || k == db.KindBoolean || kind == db.KindString || k == db.KindString:
+		return db.KindString
+	case kind == db.KindFloat || k == db.KindFloat:
+		return db.KindFloat
+	}
+	// 整数与 DECIMAL 混合
+	return db.KindDecimal
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// 服务端合并最多执行的查询数，以及默认和最多同时执行的查询数
+const (
+	maxMergeSources = 10
+	maxMergeWorkers = 4
+)
+
+// MergeSource 由服务端执行的一个合并查询，可以直接给出SQL，也可以只给出已保存查询的ID
+type MergeSource struct {
+	QueryRequest
+	Label string `json:"label,omitempty"` // 合并结果中标识该查询的名称，默认 q1、q2……
+}
+
+// MergeQueryStat 合并中每个查询的执行统计
+type MergeQueryStat struct {
+	QueryIndex   int    `json:"queryIndex"` // 从1开始
+	Label        string `json:"label"`
+	QueryID      string `json:"queryId,omitempty"`
+	DataSource   string `json:"datasource,omitempty"`
+	SavedQueryID int64  `json:"savedQueryId,omitempty"`
+	RowCount     int    `json:"rowCount"`
+	Truncated    bool   `json:"truncated,omitempty"`
+	Duration     string `json:"duration,omitempty"`
+	Error        string `json:"error,omitempty"`
+	ErrorCode    string `json:"errorCode,omitempty"`
+}
+
+// mergeLabel 返回第 i 个查询（从0开始）的名称
+func mergeLabel(label string, i int) string {
+	if label = strings.TrimSpace(label); label != "" {
+		return label
+	}
+	return fmt.Sprintf("q%d", i+1)
+}
+
+// prepareMergeSources 校验合并查询，读取已保存查询的SQL，返回每个查询的执行选项
+// 任何一个查询无效时整个请求失败，不执行任何查询
+func prepareMergeSources(r *http.Request, sources []MergeSource) ([]db.QueryOptions, error) {
+	if len(sources) > maxMergeSources {
+		return nil, fmt.Errorf("一次最多合并 %d 个查询", maxMergeSources)
+	}
+
+	labels := make(map[string]bool, len(sources))
+	opts := make([]db.QueryOptions, len(sources))
+	for i := range sources {
+		src := &sources[i]
+		src.Label = mergeLabel(src.Label, i)
+		if labels[src.Label] {
+			return nil, fmt.Errorf("查询名称重复: %s", src.Label)
+		}
+		labels[src.Label] = true
+
+		if err := loadSavedQuery(r, &src.QueryRequest); err != nil {
+			return nil, fmt.Errorf("查询 %s: %w", src.Label, err)
+		}
+
+		if src.QueryID == "" {
+			src.QueryID = db.NewQueryID()
+		} else if !db.ValidQueryID(src.QueryID) {
+			return nil, fmt.Errorf("查询 %s: 查询ID格式错误", src.Label)
+		}
+		o, err := src.options(r)
+		if err != nil {
+			return nil, fmt.Errorf("查询 %s: %w", src.Label, err)
+		}
+		opts[i] = o
+	}
+	return opts, nil
+}
+
+// loadSavedQuery 请求只给出已保存查询ID时，读取已保存查询的SQL、数据源和参数声明
+func loadSavedQuery(r *http.Request, req *QueryRequest) error {
+	if strings.TrimSpace(req.Query) == "" && req.SavedQueryID > 0 {
+		saved, err := store.GetSavedQuery(r.Context(), req.SavedQueryID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("已保存查询 %d 不存在", req.SavedQueryID)
+		} else if err != nil {
+			return err
+		}
+		req.Query = saved.Query
+		if req.DataSource == "" {
+			req.DataSource = saved.DataSource
+		}
+		if len(req.ParamDefs) == 0 {
+			req.ParamDefs = saved.ParamDefs
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return errors.New("缺少SQL或已保存查询ID")
+	}
+	return nil
+}
+
+// executeMergeSources 用有限数量的工作协程并发执行合并查询，结果按请求中的顺序返回
+// 每个查询单独记录执行历史；某个查询失败不影响其他查询
+func executeMergeSources(r *http.Request, sources []MergeSource, opts []db.QueryOptions, concurrency int) []db.QueryResult {
+	workers := concurrency
+	if workers <= 0 || workers > maxMergeWorkers {
+		workers = maxMergeWorkers
+	}
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+
+	results := make([]db.QueryResult, len(sources))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				startedAt := time.Now()
+				log.Printf("合并查询 %s [%s] %s: %s", sources[i].Label, opts[i].DataSource, opts[i].QueryID, opts[i].Query)
+				results[i] = db.ExecuteSQL(r.Context(), opts[i])
+				recordExecution(r, store.HistoryMerge, opts[i], sources[i].SavedQueryID, startedAt, results[i])
+			}
+		}()
+	}
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// mergeSourceStats 返回服务端执行的各查询的统计
+func mergeSourceStats(sources []MergeSource, opts []db.QueryOptions, results []db.QueryResult) []MergeQueryStat {
+	stats := make([]MergeQueryStat, len(results))
+	for i, result := range results {
+		stats[i] = MergeQueryStat{
+			QueryIndex:   i + 1,
+			Label:        sources[i].Label,
+			QueryID:      opts[i].QueryID,
+			DataSource:   opts[i].DataSource,
+			SavedQueryID: sources[i].SavedQueryID,
+			RowCount:     result.RowCount,
+			Truncated:    result.Truncated,
+			Duration:     result.Duration,
+			Error:        result.Error,
+			ErrorCode:    result.ErrorCode,
+		}
+		if stats[i].DataSource == "" {
+			if ds, err := db.GetDataSource(""); err == nil {
+				stats[i].DataSource = ds.Config.Name
+			}
+		}
+	}
+	return stats
+}
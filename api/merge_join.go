@@ -0,0 +1,299 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"bi-web/db"
+)
+
+// 合并的连接方式
+const (
+	JoinInner = "inner" // 只保留所有查询都有的键
+	JoinLeft  = "left"  // 保留第一个查询的所有键
+	JoinFull  = "full"  // 保留任一查询出现过的键（默认）
+)
+
+// defaultMergeKeyName 未指定连接键时，合并结果中键列的名称
+const defaultMergeKeyName = "标签"
+
+// MergeOptions 合并方式：连接键、带入的值列和连接方式
+type MergeOptions struct {
+	Keys       []string            `json:"keys,omitempty"`       // 所有查询共用的连接键列名，可以是多列；默认各查询的第一列
+	QueryKeys  map[string][]string `json:"queryKeys,omitempty"`  // 按查询名称覆盖连接键列名，列数需与 keys 相同
+	Values     map[string][]string `json:"values,omitempty"`     // 按查询名称指定带入的值列，默认为除键列外的所有列
+	Join       string              `json:"join,omitempty"`       // inner、left 或 full
+	Sort       *MergeSort          `json:"sort,omitempty"`       // 排序方式，默认保持各键首次出现的顺序
+	TimeSeries *MergeTimeSeries    `json:"timeSeries,omitempty"` // 按时间段对齐时间键并补齐空缺
+	Derived    []DerivedColumn     `json:"derived,omitempty"`    // 在合并结果上计算的派生列
+	Compare    *MergeCompare       `json:"compare,omitempty"`    // 对比模式：第一个查询为当前期，第二个为基准期
+}
+
+// mergeInput 参与合并的一个查询结果及其键列、值列的位置
+type mergeInput struct {
+	label    string
+	result   db.QueryResult
+	failed   bool // 查询出错，值列全部为空，不参与连接
+	keyIdx   []int
+	valueIdx []int // 为 -1 时该列在结果中不存在（只出现在失败的查询中）
+	values   []string
+	offset   int // 值列在合并行中的起始位置
+}
+
+// mergedRow 合并过程中的一行
+type mergedRow struct {
+	key    string
+	keys   []interface{}
+	values []interface{}
+}
+
+// validate 检查合并选项
+func (o *MergeOptions) validate() error {
+	switch o.Join {
+	case "":
+		o.Join = JoinFull
+	case JoinInner, JoinLeft, JoinFull:
+	default:
+		return fmt.Errorf("不支持的连接方式: %s，只能是 inner、left 或 full", o.Join)
+	}
+	for label, keys := range o.QueryKeys {
+		if len(o.Keys) > 0 && len(keys) != len(o.Keys) {
+			return fmt.Errorf("查询 %s 的连接键列数与 keys 不一致", label)
+		}
+	}
+	if o.Sort != nil {
+		if err := o.Sort.validate(); err != nil {
+			return err
+		}
+	}
+	if o.TimeSeries != nil {
+		if err := o.TimeSeries.validate(); err != nil {
+			return err
+		}
+	}
+	if o.Compare != nil {
+		if o.TimeSeries != nil {
+			return fmt.Errorf("对比模式不能与 timeSeries 同时使用")
+		}
+		if err := o.Compare.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateDerivedColumns(o.Derived); err != nil {
+		return err
+	}
+	return nil
+}
+
+// columnIndex 按名称查找列，优先精确匹配，其次不区分大小写
+func columnIndex(columns []string, name string) int {
+	for i, c := range columns {
+		if c == name {
+			return i
+		}
+	}
+	for i, c := range columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveMergeInput 按合并选项确定查询结果的键列和值列
+func resolveMergeInput(label string, result db.QueryResult, opts MergeOptions) (*mergeInput, error) {
+	in := &mergeInput{label: label, result: result}
+	in.failed = result.Error != "" || len(result.Columns) == 0
+
+	keys := opts.QueryKeys[label]
+	if len(keys) == 0 {
+		keys = opts.Keys
+	}
+	if !in.failed {
+		if len(keys) == 0 {
+			in.keyIdx = []int{0}
+		}
+		for _, name := range keys {
+			i := columnIndex(result.Columns, name)
+			if i < 0 {
+				return nil, fmt.Errorf("查询 %s 中没有连接键列 %s", label, name)
+			}
+			in.keyIdx = append(in.keyIdx, i)
+		}
+	}
+
+	if names, ok := opts.Values[label]; ok {
+		for _, name := range names {
+			i := -1
+			if !in.failed {
+				if i = columnIndex(result.Columns, name); i < 0 {
+					return nil, fmt.Errorf("查询 %s 中没有值列 %s", label, name)
+				}
+				name = result.Columns[i]
+			}
+			in.valueIdx = append(in.valueIdx, i)
+			in.values = append(in.values, name)
+		}
+		return in, nil
+	}
+
+	if in.failed {
+		// 失败的查询保留一列空值，便于看出它参与了合并
+		in.valueIdx, in.values = []int{-1}, []string{label}
+		return in, nil
+	}
+	isKey := make(map[int]bool, len(in.keyIdx))
+	for _, i := range in.keyIdx {
+		isKey[i] = true
+	}
+	for i, name := range result.Columns {
+		if !isKey[i] {
+			in.valueIdx = append(in.valueIdx, i)
+			in.values = append(in.values, name)
+		}
+	}
+	return in, nil
+}
+
+// mergeKey 把一行的键列拼接成用于匹配的字符串
+func mergeKey(row []interface{}, keyIdx []int) (string, []interface{}, bool) {
+	keys := make([]interface{}, len(keyIdx))
+	parts := make([]string, len(keyIdx))
+	for j, i := range keyIdx {
+		if i >= len(row) {
+			return "", nil, false
+		}
+		keys[j] = row[i]
+		parts[j] = fmt.Sprintf("%v", row[i])
+	}
+	return strings.Join(parts, "\x1f"), keys, true
+}
+
+// joinMergeInputs 依次把每个查询连接到已合并的行上，行的顺序为首次出现的顺序
+func joinMergeInputs(inputs []*mergeInput, width int, join string) []*mergedRow {
+	var rows []*mergedRow
+	started := false
+	for _, in := range inputs {
+		if in.failed {
+			if join == JoinLeft && !started {
+				// 第一个查询失败时左连接没有任何行
+				return nil
+			}
+			continue
+		}
+
+		// 本查询的行按键分组，保持原有顺序
+		var order []string
+		byKey := make(map[string][]*mergedRow)
+		for _, row := range in.result.Rows {
+			key, keys, ok := mergeKey(row, in.keyIdx)
+			if !ok {
+				continue
+			}
+			r := &mergedRow{key: key, keys: keys, values: make([]interface{}, width)}
+			for j, i := range in.valueIdx {
+				if i >= 0 && i < len(row) {
+					r.values[in.offset+j] = row[i]
+				}
+			}
+			if _, seen := byKey[key]; !seen {
+				order = append(order, key)
+			}
+			byKey[key] = append(byKey[key], r)
+		}
+
+		if !started {
+			for _, key := range order {
+				rows = append(rows, byKey[key]...)
+			}
+			started = true
+			continue
+		}
+
+		matched := make(map[string]bool)
+		joined := make([]*mergedRow, 0, len(rows))
+		for _, left := range rows {
+			rights, ok := byKey[left.key]
+			if !ok {
+				if join != JoinInner {
+					joined = append(joined, left)
+				}
+				continue
+			}
+			matched[left.key] = true
+			// 一对多时每个匹配的行各产生一行
+			for _, right := range rights {
+				r := &mergedRow{key: left.key, keys: left.keys, values: append([]interface{}(nil), left.values...)}
+				copy(r.values[in.offset:], right.values[in.offset:in.offset+len(in.valueIdx)])
+				joined = append(joined, r)
+			}
+		}
+		if join == JoinFull {
+			for _, key := range order {
+				if !matched[key] {
+					joined = append(joined, byKey[key]...)
+				}
+			}
+		}
+		rows = joined
+	}
+	return rows
+}
+
+// mergedColumnNames 生成值列的名称，在多个查询中重名或与键列重名的列加上查询名称前缀，如 q1.revenue
+func mergedColumnNames(inputs []*mergeInput, keyNames []string) []string {
+	count := make(map[string]int)
+	for _, name := range keyNames {
+		count[strings.ToLower(name)]++
+	}
+	for _, in := range inputs {
+		for _, name := range in.values {
+			count[strings.ToLower(name)]++
+		}
+	}
+
+	var names []string
+	for _, in := range inputs {
+		for _, name := range in.values {
+			if count[strings.ToLower(name)] > 1 {
+				name = in.label + "." + name
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mergedColumnTypes 合并结果的列类型，取自各查询结果；列类型未知时返回空
+func mergedColumnTypes(inputs []*mergeInput, keyNames, valueNames []string) []db.ColumnType {
+	var keySource *mergeInput
+	for _, in := range inputs {
+		if !in.failed {
+			keySource = in
+			break
+		}
+	}
+	if keySource == nil || len(keySource.result.ColumnTypes) != len(keySource.result.Columns) {
+		return nil
+	}
+
+	types := make([]db.ColumnType, 0, len(keyNames)+len(valueNames))
+	for j, i := range keySource.keyIdx {
+		t := keySource.result.ColumnTypes[i]
+		t.Name = keyNames[j]
+		types = append(types, t)
+	}
+	n := 0
+	for _, in := range inputs {
+		for _, i := range in.valueIdx {
+			t := db.ColumnType{Kind: db.KindString}
+			if i >= 0 && len(in.result.ColumnTypes) == len(in.result.Columns) {
+				t = in.result.ColumnTypes[i]
+			}
+			t.Name = valueNames[n]
+			types = append(types, t)
+			n++
+		}
+	}
+	return types
+}
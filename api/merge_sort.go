@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+)
+
+// 排序时值的比较方式
+const (
+	SortAuto   = "auto"   // 根据列类型和数据识别
+	SortDate   = "date"   // 按日期时间
+	SortNumber = "number" // 按数值
+	SortString = "string" // 按字符串
+)
+
+// sortByLabel 按连接键排序
+const sortByLabel = "label"
+
+// MergeSort 合并结果的排序方式，未指定时保持各键首次出现的顺序
+type MergeSort struct {
+	By   string `json:"by"`             // label（按连接键，多列时依次比较）或合并结果中的列名
+	As   string `json:"as,omitempty"`   // auto（默认）、date、number 或 string
+	Desc bool   `json:"desc,omitempty"` // 降序；空值总是排在最后
+}
+
+// 识别日期时间时尝试的格式
+var mergeTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"2006-01",
+	"2006/1/2",
+}
+
+func (s *MergeSort) validate() error {
+	if strings.TrimSpace(s.By) == "" {
+		return fmt.Errorf("排序缺少 by")
+	}
+	switch s.As {
+	case "":
+		s.As = SortAuto
+	case SortAuto, SortDate, SortNumber, SortString:
+	default:
+		return fmt.Errorf("不支持的排序方式: %s，只能是 auto、date、number 或 string", s.As)
+	}
+	return nil
+}
+
+// parseMergeTime 把值解析为时间，不带时区的文本按 loc 解释
+func parseMergeTime(v interface{}, loc *time.Location) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range mergeTimeLayouts {
+			if parsed, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseMergeNumber 把值解析为数值，DECIMAL 和大整数可能以字符串或带类型标记的对象给出
+func parseMergeNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	case db.TaggedNumber:
+		f, err := strconv.ParseFloat(n.Value, 64)
+		return f, err == nil
+	case map[string]interface{}:
+		// 客户端回传的 tagged 模式数值
+		if s, ok := n["value"].(string); ok {
+			f, err := strconv.ParseFloat(s, 64)
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// detectSortType 根据列类型或数据识别比较方式：所有非空值都是数值时按数值，都是日期时按日期，否则按字符串
+func detectSortType(rows [][]interface{}, col int, colType *db.ColumnType) string {
+	if colType != nil {
+		switch colType.Kind {
+		case db.KindInteger, db.KindFloat, db.KindDecimal:
+			return SortNumber
+		case db.KindDate, db.KindDateTime:
+			return SortDate
+		}
+	}
+
+	isNumber, isDate, seen := true, true, false
+	for _, row := range rows {
+		v := row[col]
+		if v == nil {
+			continue
+		}
+		seen = true
+		if isNumber {
+			_, isNumber = parseMergeNumber(v)
+		}
+		if isDate {
+			_, isDate = parseMergeTime(v, time.UTC)
+		}
+		if !isNumber && !isDate {
+			break
+		}
+	}
+	switch {
+	case !seen:
+		return SortString
+	case isNumber:
+		return SortNumber
+	case isDate:
+		return SortDate
+	default:
+		return SortString
+	}
+}
+
+// sortKey 一个单元格按比较方式解析后的值
+type sortKey struct {
+	null bool
+	num  float64
+	str  string
+}
+
+func makeSortKey(v interface{}, as string) sortKey {
+	if v == nil {
+		return sortKey{null: true}
+	}
+	switch as {
+	case SortNumber:
+		if f, ok := parseMergeNumber(v); ok {
+			return sortKey{num: f}
+		}
+		return sortKey{null: true}
+	case SortDate:
+		if t, ok := parseMergeTime(v, time.UTC); ok {
+			return sortKey{num: float64(t.UnixNano())}
+		}
+		return sortKey{null: true}
+	default:
+		return sortKey{str: fmt.Sprintf("%v", v)}
+	}
+}
+
+// compareSortKeys 比较两个值，空值（包括无法按比较方式解析的值）总是排在最后
+func compareSortKeys(a, b sortKey, as string, desc bool) int {
+	switch {
+	case a.null && b.null:
+		return 0
+	case a.null:
+		return 1
+	case b.null:
+		return -1
+	}
+
+	c := 0
+	if as == SortNumber || as == SortDate {
+		if a.num < b.num {
+			c = -1
+		} else if a.num > b.num {
+			c = 1
+		}
+	} else {
+		c = strings.Compare(a.str, b.str)
+	}
+	if desc {
+		c = -c
+	}
+	return c
+}
+
+// sortMergedRows 按排序方式对合并行做稳定排序，相等的行保持原有顺序
+func sortMergedRows(rows [][]interface{}, columns []string, keyCount int, types []db.ColumnType, s *MergeSort) error {
+	var cols []int
+	if s.By == sortByLabel {
+		for i := 0; i < keyCount; i++ {
+			cols = append(cols, i)
+		}
+	} else if i := columnIndex(columns, s.By); i >= 0 {
+		cols = []int{i}
+	} else {
+		return fmt.Errorf("排序列 %s 不存在", s.By)
+	}
+
+	kinds := make([]string, len(cols))
+	for j, col := range cols {
+		kinds[j] = s.As
+		if kinds[j] == SortAuto {
+			var colType *db.ColumnType
+			if col < len(types) {
+				colType = &types[col]
+			}
+			kinds[j] = detectSortType(rows, col, colType)
+		}
+	}
+
+	keys := make([][]sortKey, len(rows))
+	for i, row := range rows {
+		keys[i] = make([]sortKey, len(cols))
+		for j, col := range cols {
+			keys[i][j] = makeSortKey(row[col], kinds[j])
+		}
+	}
+
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		for j := range cols {
+			if c := compareSortKeys(keys[order[a]][j], keys[order[b]][j], kinds[j], s.Desc); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	sorted := make([][]interface{}, len(rows))
+	for i, idx := range order {
+		sorted[i] = rows[idx]
+	}
+	copy(rows, sorted)
+	return nil
+}
@@ -0,0 +1,583 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+)
+
+// 时间序列合并的时间粒度
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week" // 以周一为一周的开始
+	GranularityMonth = "month"
+)
+
+// 时间序列合并中缺失值的填充方式
+const (
+	FillNull     = "null"     // 保留为空（默认）
+	FillZero     = "zero"     // 填0，只用于数值列
+	FillPrevious = "previous" // 沿用同一序列中上一个时间段的值
+)
+
+// 同一时间段内有多行时的聚合方式
+const (
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateCount = "count"
+	AggregateFirst = "first"
+	AggregateLast  = "last"
+)
+
+// maxTimeBuckets 时间序列合并最多生成的时间段数
+const maxTimeBuckets = 10000
+
+// maxTimeSeriesRows 补齐时间段后最多的行数，即序列数与时间段数的乘积
+const maxTimeSeriesRows = 1000000
+
+// 各粒度时间段标签的格式
+var bucketLayouts = map[string]string{
+	GranularityHour:  "2006-01-02 15:00",
+	GranularityDay:   "2006-01-02",
+	GranularityWeek:  "2006-01-02",
+	GranularityMonth: "2006-01",
+}
+
+// MergeTimeSeries 时间序列合并：把时间键按粒度归入时间段后再连接，并补齐时间段之间的空缺
+type MergeTimeSeries struct {
+	Key         string `json:"key,omitempty"`       // 时间键的列名，需是连接键之一，默认第一个连接键
+	Granularity string `json:"granularity"`         // hour、day、week 或 month
+	Timezone    string `json:"timezone,omitempty"`  // IANA 时区名，如 Asia/Shanghai，默认服务器时区
+	Fill        string `json:"fill,omitempty"`      // null（默认）、zero 或 previous
+	Aggregate   string `json:"aggregate,omitempty"` // 同一查询在同一时间段有多行时的聚合方式，默认 sum
+	Start       string `json:"start,omitempty"`     // 时间范围的开始，默认为数据中最早的时间段
+	End         string `json:"end,omitempty"`       // 时间范围的结束（包含），默认为数据中最晚的时间段
+
+	loc        *time.Location
+	start, end time.Time
+}
+
+// TimeSeriesInfo 响应中时间序列合并的说明
+type TimeSeriesInfo struct {
+	Key         string `json:"key"`
+	Granularity string `json:"granularity"`
+	Timezone    string `json:"timezone"`
+	Fill        string `json:"fill"`
+	Aggregate   string `json:"aggregate"`
+	Start       string `json:"start,omitempty"`
+	End         string `json:"end,omitempty"`
+	Buckets     int    `json:"buckets"`
+	SkippedRows int    `json:"skippedRows,omitempty"` // 时间键无法解析而跳过的行数
+}
+
+func (ts *MergeTimeSeries) validate() error {
+	if _, ok := bucketLayouts[ts.Granularity]; !ok {
+		return fmt.Errorf("不支持的时间粒度: %s，只能是 hour、day、week 或 month", ts.Granularity)
+	}
+	switch ts.Fill {
+	case "":
+		ts.Fill = FillNull
+	case FillNull, FillZero, FillPrevious:
+	default:
+		return fmt.Errorf("不支持的填充方式: %s，只能是 null、zero 或 previous", ts.Fill)
+	}
+	switch ts.Aggregate {
+	case "":
+		ts.Aggregate = AggregateSum
+	case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount, AggregateFirst, AggregateLast:
+	default:
+		return fmt.Errorf("不支持的聚合方式: %s", ts.Aggregate)
+	}
+
+	ts.loc = time.Local
+	if ts.Timezone != "" {
+		loc, err := time.LoadLocation(ts.Timezone)
+		if err != nil {
+			return fmt.Errorf("时区无效: %s", ts.Timezone)
+		}
+		ts.loc = loc
+	}
+
+	ts.start, ts.end = time.Time{}, time.Time{}
+	if ts.Start != "" {
+		t, ok := parseMergeTime(ts.Start, ts.loc)
+		if !ok {
+			return fmt.Errorf("时间范围开始格式错误: %s", ts.Start)
+		}
+		ts.start = ts.bucket(t)
+	}
+	if ts.End != "" {
+		t, ok := parseMergeTime(ts.End, ts.loc)
+		if !ok {
+			return fmt.Errorf("时间范围结束格式错误: %s", ts.End)
+		}
+		ts.end = ts.bucket(t)
+	}
+	if !ts.start.IsZero() && !ts.end.IsZero() && ts.end.Before(ts.start) {
+		return fmt.Errorf("时间范围结束早于开始")
+	}
+	return nil
+}
+
+// bucket 返回时间所在时间段的开始
+func (ts *MergeTimeSeries) bucket(t time.Time) time.Time {
+	t = t.In(ts.loc)
+	y, m, d := t.Date()
+	switch ts.Granularity {
+	case GranularityHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, ts.loc)
+	case GranularityWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, ts.loc)
+	case GranularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, ts.loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, ts.loc)
+	}
+}
+
+// next 返回下一个时间段的开始；按日历计算，夏令时切换的日子也是一个时间段
+func (ts *MergeTimeSeries) next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch ts.Granularity {
+	case GranularityHour:
+		return t.Add(time.Hour)
+	case GranularityWeek:
+		return time.Date(y, m, d+7, 0, 0, 0, 0, ts.loc)
+	case GranularityMonth:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, ts.loc)
+	default:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, ts.loc)
+	}
+}
+
+func (ts *MergeTimeSeries) label(t time.Time) string {
+	return t.Format(bucketLayouts[ts.Granularity])
+}
+
+// keyColumnType 时间键在合并结果中的列类型
+func (ts *MergeTimeSeries) keyColumnType(name string) db.ColumnType {
+	kind := db.KindDate
+	if ts.Granularity == GranularityHour {
+		kind = db.KindDateTime
+	}
+	return db.ColumnType{Name: name, Kind: kind}
+}
+
+// timeKeyIndex 返回时间键在连接键中的位置
+func (ts *MergeTimeSeries) timeKeyIndex(keyNames []string) (int, error) {
+	if ts.Key == "" {
+		return 0, nil
+	}
+	if i := columnIndex(keyNames, ts.Key); i >= 0 {
+		return i, nil
+	}
+	return 0, fmt.Errorf("时间键 %s 不是连接键", ts.Key)
+}
+
+// bucketMergeInputs 把每个查询结果的时间键替换为时间段标签，同一查询中键相同的行按聚合方式合并为一行，
+// 返回时间键无法解析而跳过的行数
+func bucketMergeInputs(inputs []*mergeInput, timeKey int, ts *MergeTimeSeries) int {
+	skipped := 0
+	for _, in := range inputs {
+		if in.failed {
+			continue
+		}
+		col := in.keyIdx[timeKey]
+		isKey := make(map[int]bool, len(in.keyIdx))
+		for _, i := range in.keyIdx {
+			isKey[i] = true
+		}
+
+		var order []string
+		groups := make(map[string][][]interface{})
+		for _, row := range in.result.Rows {
+			if col >= len(row) {
+				skipped++
+				continue
+			}
+			t, ok := parseMergeTime(row[col], ts.loc)
+			if !ok {
+				skipped++
+				continue
+			}
+			bucketed := append([]interface{}(nil), row...)
+			bucketed[col] = ts.label(ts.bucket(t))
+			key, _, ok := mergeKey(bucketed, in.keyIdx)
+			if !ok {
+				skipped++
+				continue
+			}
+			if _, seen := groups[key]; !seen {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], bucketed)
+		}
+
+		result := in.result
+		if len(result.ColumnTypes) == len(result.Columns) {
+			// 计数和平均值改变了值列的类型
+			result.ColumnTypes = append([]db.ColumnType(nil), result.ColumnTypes...)
+			for i := range result.ColumnTypes {
+				if !isKey[i] {
+					result.ColumnTypes[i] = aggregatedType(result.ColumnTypes[i], ts.Aggregate)
+				}
+			}
+		}
+		result.Rows = make([][]interface{}, 0, len(order))
+		for _, key := range order {
+			rows := groups[key]
+			row := rows[0]
+			if len(rows) > 1 || ts.Aggregate == AggregateCount {
+				for i := range row {
+					if !isKey[i] {
+						row[i] = aggregateColumn(rows, i, ts.Aggregate)
+					}
+				}
+			}
+			result.Rows = append(result.Rows, row)
+		}
+		result.RowCount = len(result.Rows)
+		in.result = result
+	}
+	return skipped
+}
+
+// aggregateColumn 按聚合方式合并同一时间段内多行的一列。sum/avg/min/max 遇到非数值时取第一个非空值。
+// 整数和以文本传递的 DECIMAL 用精确的有理数计算，结果保留原值的小数位数，不经过 float64
+func aggregateColumn(rows [][]interface{}, col int, how string) interface{} {
+	var values []interface{}
+	for _, row := range rows {
+		if col < len(row) && row[col] != nil {
+			values = append(values, row[col])
+		}
+	}
+	switch how {
+	case AggregateCount:
+		return len(values)
+	case AggregateFirst:
+		if len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	case AggregateLast:
+		if len(values) > 0 {
+			return values[len(values)-1]
+		}
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	if v, ok := aggregateExact(values, how); ok {
+		return v
+	}
+
+	nums := make([]float64, len(values))
+	for i, v := range values {
+		f, ok := parseMergeNumber(v)
+		if !ok {
+			return values[0]
+		}
+		nums[i] = f
+	}
+	acc := nums[0]
+	for _, f := range nums[1:] {
+		switch how {
+		case AggregateMin:
+			if f < acc {
+				acc = f
+			}
+		case AggregateMax:
+			if f > acc {
+				acc = f
+			}
+		default:
+			acc += f
+		}
+	}
+	if how == AggregateAvg {
+		acc /= float64(len(nums))
+	}
+	return acc
+}
+
+// avgExtraScale DECIMAL 平均值比原值多保留的小数位数，与 MySQL 的 div_precision_increment 默认值一致
+const avgExtraScale = 4
+
+// aggregateExact 用有理数精确聚合整数和十进制文本，有值不能精确表示（如 float64）或不是数值时返回 false。
+// min/max 返回原值；sum 按最大的小数位数输出；avg 在此基础上多保留 avgExtraScale 位，整数的平均值为 float64
+func aggregateExact(values []interface{}, how string) (interface{}, bool) {
+	nums := make([]*big.Rat, len(values))
+	scale, integer := 0, true
+	for i, v := range values {
+		r, s, ok := parseMergeRat(v)
+		if !ok {
+			return nil, false
+		}
+		nums[i] = r
+		scale = maxInt(scale, s)
+		// 只有 JSON 数值和整数类型的整数算作整数列，文本和 tagged 数值是 DECIMAL 或字符串模式的 BIGINT
+		switch v.(type) {
+		case int, int64, uint64:
+		case json.Number:
+			integer = integer && r.IsInt()
+		default:
+			integer = false
+		}
+	}
+
+	switch how {
+	case AggregateMin, AggregateMax:
+		pick := 0
+		for i, r := range nums {
+			if c := r.Cmp(nums[pick]); (how == AggregateMin && c < 0) || (how == AggregateMax && c > 0) {
+				pick = i
+			}
+		}
+		return values[pick], true
+	}
+
+	acc := new(big.Rat)
+	for _, r := range nums {
+		acc.Add(acc, r)
+	}
+	if how == AggregateAvg {
+		acc.Quo(acc, new(big.Rat).SetInt64(int64(len(nums))))
+		if integer {
+			// 整数列的平均值为浮点数
+			f, _ := acc.Float64()
+			return f, true
+		}
+		scale += avgExtraScale
+	}
+	return exactValue(acc, scale, values[0]), true
+}
+
+// exactValue 按原值 like 的表示方式输出精确计算的结果：文本仍输出文本，tagged 数值保持标记（有小数位时标记为 decimal），
+// JSON 数值和整数输出为 JSON 数值
+func exactValue(r *big.Rat, scale int, like interface{}) interface{} {
+	text := r.FloatString(scale)
+	switch first := like.(type) {
+	case string:
+		return text
+	case db.TaggedNumber:
+		if scale > 0 {
+			first.Type = "decimal"
+		}
+		return db.TaggedNumber{Type: first.Type, Value: text}
+	case map[string]interface{}:
+		tagged := make(map[string]interface{}, len(first))
+		for k, v := range first {
+			tagged[k] = v
+		}
+		tagged["value"] = text
+		if _, ok := tagged["$type"]; ok && scale > 0 {
+			tagged["$type"] = "decimal"
+		}
+		return tagged
+	}
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return json.Number(text)
+}
+
+// parseMergeRat 把整数和十进制文本解析为有理数，同时返回小数位数；float64 等不精确的值返回 false
+func parseMergeRat(v interface{}) (*big.Rat, int, bool) {
+	var text string
+	switch n := v.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), 0, true
+	case int64:
+		return new(big.Rat).SetInt64(n), 0, true
+	case uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(n)), 0, true
+	case json.Number:
+		text = n.String()
+	case string:
+		text = strings.TrimSpace(n)
+	case db.TaggedNumber:
+		text = n.Value
+	case map[string]interface{}:
+		s, ok := n["value"].(string)
+		if !ok {
+			return nil, 0, false
+		}
+		text = s
+	default:
+		return nil, 0, false
+	}
+
+	// big.Rat 还接受分数和十六进制等写法，这里只接受十进制数
+	if _, ok := parseMergeNumber(text); !ok || strings.ContainsAny(text, "/xXpP_") {
+		return nil, 0, false
+	}
+	scale, ok := decimalScale(text)
+	if !ok {
+		return nil, 0, false
+	}
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, 0, false
+	}
+	return r, scale, true
+}
+
+// maxExactExponent 精确计算时允许的最大指数，避免 1e-100000000 之类的值生成巨大的有理数
+const maxExactExponent = 400
+
+// decimalScale 十进制文本的小数位数，如 12.30 为 2，1.5e-3 为 4；指数过大时返回 false
+func decimalScale(text string) (int, bool) {
+	mantissa, exp := text, 0
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		var err error
+		mantissa = text[:i]
+		if exp, err = strconv.Atoi(text[i+1:]); err != nil || exp > maxExactExponent || exp < -maxExactExponent {
+			return 0, false
+		}
+	}
+	scale := 0
+	if _, frac, ok := strings.Cut(mantissa, "."); ok {
+		scale = len(frac)
+	}
+	return maxInt(scale-exp, 0), true
+}
+
+// fillTimeSeries 为每个序列（时间键以外的键相同的行）生成完整的时间段，缺失的时间段补一行，
+// 再按填充方式填充空值。结果按序列首次出现的顺序、序列内按时间排列。
+// fillable 为可以填充的值列：失败的查询的列保持为空，zero 只填充数值列
+func fillTimeSeries(rows [][]interface{}, keyCount, timeKey int, fillable []bool, ts *MergeTimeSeries) ([][]interface{}, []string, error) {
+	layout := bucketLayouts[ts.Granularity]
+	type series struct {
+		keys    []interface{}
+		buckets map[string][][]interface{}
+	}
+
+	start, end := ts.start, ts.end
+	var order []string
+	groups := make(map[string]*series)
+	for _, row := range rows {
+		label, _ := row[timeKey].(string)
+		t, err := time.ParseInLocation(layout, label, ts.loc)
+		if err != nil {
+			continue
+		}
+		if (!ts.start.IsZero() && t.Before(ts.start)) || (!ts.end.IsZero() && t.After(ts.end)) {
+			continue
+		}
+		if ts.start.IsZero() && (start.IsZero() || t.Before(start)) {
+			start = t
+		}
+		if ts.end.IsZero() && (end.IsZero() || t.After(end)) {
+			end = t
+		}
+
+		var parts []string
+		for j := 0; j < keyCount; j++ {
+			if j != timeKey {
+				parts = append(parts, fmt.Sprintf("%v", row[j]))
+			}
+		}
+		key := strings.Join(parts, "\x1f")
+		s, ok := groups[key]
+		if !ok {
+			s = &series{keys: row[:keyCount], buckets: make(map[string][][]interface{})}
+			groups[key] = s
+			order = append(order, key)
+		}
+		s.buckets[label] = append(s.buckets[label], row)
+	}
+	if len(order) == 0 && (ts.start.IsZero() || ts.end.IsZero()) {
+		return [][]interface{}{}, nil, nil
+	}
+	if len(order) == 0 {
+		// 没有数据但指定了时间范围时，只有时间键的单一序列才能补齐
+		if keyCount != 1 {
+			return [][]interface{}{}, nil, nil
+		}
+		order = []string{""}
+		groups[""] = &series{keys: make([]interface{}, keyCount), buckets: map[string][][]interface{}{}}
+	}
+
+	var labels []string
+	for t := start; !t.After(end); t = ts.next(t) {
+		if len(labels) >= maxTimeBuckets {
+			return nil, nil, fmt.Errorf("时间段超过 %d 个，请缩小时间范围或使用更大的粒度", maxTimeBuckets)
+		}
+		labels = append(labels, ts.label(t))
+	}
+	if len(order)*len(labels) > maxTimeSeriesRows {
+		return nil, nil, fmt.Errorf("补齐时间段后超过 %d 行（%d 个序列 × %d 个时间段），请缩小时间范围、使用更大的粒度或减少序列",
+			maxTimeSeriesRows, len(order), len(labels))
+	}
+
+	width := len(fillable)
+	filled := make([][]interface{}, 0, len(order)*len(labels))
+	for _, key := range order {
+		s := groups[key]
+		last := make([]interface{}, width)
+		for _, label := range labels {
+			bucketRows := s.buckets[label]
+			if len(bucketRows) == 0 {
+				row := make([]interface{}, keyCount+width)
+				copy(row, s.keys)
+				row[timeKey] = label
+				bucketRows = [][]interface{}{row}
+			}
+			for _, row := range bucketRows {
+				for j := 0; j < width; j++ {
+					v := row[keyCount+j]
+					if v == nil && fillable[j] {
+						switch ts.Fill {
+						case FillZero:
+							row[keyCount+j] = 0
+						case FillPrevious:
+							row[keyCount+j] = last[j]
+						}
+					}
+					if v != nil {
+						last[j] = v
+					}
+				}
+				filled = append(filled, row)
+			}
+		}
+	}
+	return filled, labels, nil
+}
+
+// fillableColumns 返回可以填充的值列
+func fillableColumns(inputs []*mergeInput, width int, types []db.ColumnType, keyCount int, fill string) []bool {
+	fillable := make([]bool, width)
+	for _, in := range inputs {
+		if in.failed {
+			continue
+		}
+		for j := range in.values {
+			fillable[in.offset+j] = true
+		}
+	}
+	if fill == FillZero && types != nil {
+		for j := range fillable {
+			switch types[keyCount+j].Kind {
+			case db.KindInteger, db.KindFloat, db.KindDecimal:
+			default:
+				fillable[j] = false
+			}
+		}
+	}
+	return fillable
+}
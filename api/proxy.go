@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	proxyMu        sync.RWMutex
+	trustedProxies []*net.IPNet
+)
+
+// SetTrustedProxies 设置可信的反向代理地址，每项为 IP 或 CIDR
+// 只有直接来自这些地址的请求才采信 X-Forwarded-User、X-Forwarded-For 和 X-Real-IP，
+// 其他请求的用户记为 anonymous，客户端地址取连接的对端地址
+func SetTrustedProxies(list []string) error {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("可信代理地址格式错误: %s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("可信代理地址格式错误: %s", item)
+		}
+		nets = append(nets, n)
+	}
+
+	proxyMu.Lock()
+	trustedProxies = nets
+	proxyMu.Unlock()
+	return nil
+}
+
+// isTrustedProxy 判断地址是否为可信代理
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	proxyMu.RLock()
+	defer proxyMu.RUnlock()
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP 返回连接的对端地址
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromTrustedProxy 请求是否由可信代理转发
+func fromTrustedProxy(r *http.Request) bool {
+	return isTrustedProxy(remoteIP(r))
+}
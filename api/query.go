@@ -2,19 +2,55 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"bi-web/db"
+	"bi-web/store"
 )
 
 // QueryRequest 查询请求结构
 type QueryRequest struct {
-	Query string `json:"query"`
+	Query      string `json:"query"`
+	DataSource string `json:"datasource,omitempty"` // 数据源名称，为空时使用默认数据源
+	TimeoutMs  int    `json:"timeoutMs,omitempty"`  // 查询超时（毫秒），为空时使用默认超时
+	QueryID    string `json:"queryId,omitempty"`    // 客户端指定的查询ID，便于执行中取消
+	Limit      int    `json:"limit,omitempty"`      // 最多返回的行数，不能超过服务端上限
+	NumberMode string `json:"numberMode,omitempty"` // 精确数值编码方式: auto/float/string/tagged
+
+	// ParamDefs 命名参数声明，Params 参数值，SQL中以 :name 或 {{name}} 引用
+	ParamDefs []db.ParamDef          `json:"paramDefs,omitempty"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+
+	// SavedQueryID 执行的是已保存查询时传入，用于记录最近一次执行统计
+	SavedQueryID int64 `json:"savedQueryId,omitempty"`
 }
 
 
 
+// options 将请求转换为查询执行选项，文件数据源使用请求所属的会话
+func (req QueryRequest) options(r *http.Request) (db.QueryOptions, error) {
+	numberMode, err := db.ParseNumberMode(req.NumberMode)
+	if err != nil {
+		return db.QueryOptions{}, err
+	}
+
+	return db.QueryOptions{
+		DataSource: req.DataSource,
+		Query:      req.Query,
+		Timeout:    time.Duration(req.TimeoutMs) * time.Millisecond,
+		QueryID:    req.QueryID,
+		MaxRows:    req.Limit,
+		NumberMode: numberMode,
+		ParamDefs:  req.ParamDefs,
+		Params:     req.Params,
+		Session:    fileSession(r),
+	}, nil
+}
+
 // QueryHandler 处理SQL查询请求
 func QueryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -23,13 +59,36 @@ func QueryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req QueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // 保持参数中数字的原始精度
+	if err := decoder.Decode(&req); err != nil {
 		http.Error(w, "请求格式错误", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("执行查询: %s", req.Query)
-	result := db.ExecuteSQL(req.Query)
+	if req.QueryID == "" {
+		req.QueryID = db.NewQueryID()
+	} else if !db.ValidQueryID(req.QueryID) {
+		http.Error(w, "查询ID格式错误", http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Query-ID", req.QueryID)
+
+	log.Printf("执行查询[%s] %s: %s", req.DataSource, req.QueryID, req.Query)
+	startedAt := time.Now()
+	if wantsNDJSON(r) {
+		result := streamQuery(w, r, opts)
+		recordExecution(r, store.HistoryStream, opts, req.SavedQueryID, startedAt, result)
+		return
+	}
+
+	result := db.ExecuteSQL(r.Context(), opts)
+	recordExecution(r, store.HistoryQuery, opts, req.SavedQueryID, startedAt, result)
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
@@ -39,4 +98,39 @@ func QueryHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		log.Printf("查询成功: 返回 %d 行数据, 耗时: %s", len(result.Rows), result.Duration)
 	}
+}
+
+// CancelQueryHandler 处理取消查询请求: POST /api/query/{id}/cancel
+func CancelQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/query/"), "/")
+	if len(parts) != 2 || parts[1] != "cancel" || !db.ValidQueryID(parts[0]) {
+		http.NotFound(w, r)
+		return
+	}
+	queryID := parts[0]
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := db.CancelQuery(r.Context(), queryID); err != nil {
+		log.Printf("取消查询 %s 失败: %v", queryID, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, db.ErrQueryNotFound) {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"queryId": queryID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queryId":   queryID,
+		"cancelled": true,
+	})
 }
\ No newline at end of file
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"bi-web/store"
+	"bi-web/utils"
+)
+
+// RevisionDiff 两个修订之间的差异
+type RevisionDiff struct {
+	SavedQueryID int64            `json:"savedQueryId"`
+	From         int64            `json:"from"`
+	To           int64            `json:"to"`
+	Changes      []FieldChange    `json:"changes"` // SQL以外发生变化的字段
+	Lines        []utils.DiffLine `json:"lines"`   // SQL逐行对比
+	Added        int              `json:"added"`
+	Removed      int              `json:"removed"`
+}
+
+// FieldChange 字段的修改前后值
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// savedQueryRevisions 列出修订历史
+func savedQueryRevisions(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revisions, err := store.ListRevisions(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revisions": revisions})
+}
+
+// savedQueryRevision 读取指定修订
+func savedQueryRevision(w http.ResponseWriter, r *http.Request, id, revision int64) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rev, err := store.GetRevision(r.Context(), id, revision)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rev)
+}
+
+// restoreSavedQuery 恢复到指定修订
+func restoreSavedQuery(w http.ResponseWriter, r *http.Request, id, revision int64) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := ownSavedQuery(w, r, id); !ok {
+		return
+	}
+	q, err := store.RestoreRevision(r.Context(), id, revision, requestUser(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("已保存查询 %d 恢复到修订 %d (新修订 %d)", id, revision, q.Revision)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q)
+}
+
+// diffSavedQuery 对比两个修订，to 默认为当前修订，from 默认为 to 的上一个修订
+func diffSavedQuery(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, err := revisionParam(r, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := revisionParam(r, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to == 0 {
+		q, err := store.GetSavedQuery(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		to = q.Revision
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	if from <= 0 {
+		http.Error(w, "没有可对比的更早修订", http.StatusBadRequest)
+		return
+	}
+
+	oldRev, err := store.GetRevision(r.Context(), id, from)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	newRev, err := store.GetRevision(r.Context(), id, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffRevisions(oldRev, newRev))
+}
+
+// diffRevisions 计算两个修订的字段变化和SQL逐行差异
+func diffRevisions(oldRev, newRev *store.SavedQueryRevision) RevisionDiff {
+	diff := RevisionDiff{
+		SavedQueryID: newRev.SavedQueryID,
+		From:         oldRev.Revision,
+		To:           newRev.Revision,
+		Changes:      []FieldChange{},
+		Lines:        utils.DiffLines(oldRev.Query, newRev.Query),
+	}
+
+	fields := []FieldChange{
+		{"title", oldRev.Title, newRev.Title},
+		{"description", oldRev.Description, newRev.Description},
+		{"tags", oldRev.Tags, newRev.Tags},
+		{"datasource", oldRev.DataSource, newRev.DataSource},
+		{"paramDefs", oldRev.ParamDefs, newRev.ParamDefs},
+	}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.Old, f.New) {
+			diff.Changes = append(diff.Changes, f)
+		}
+	}
+
+	for _, line := range diff.Lines {
+		switch line.Op {
+		case utils.DiffInsert:
+			diff.Added++
+		case utils.DiffDelete:
+			diff.Removed++
+		}
+	}
+	return diff
+}
+
+// revisionParam 读取修订号查询参数，未指定时返回0
+func revisionParam(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("修订号 %s 格式错误: %s", name, value)
+	}
+	return revision, nil
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// anonymousUser 请求未携带用户信息时使用的用户名
+const anonymousUser = "anonymous"
+
+// SavedQueryRequest 新建或更新已保存查询的请求
+type SavedQueryRequest struct {
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	DataSource  string        `json:"datasource,omitempty"`
+	Query       string        `json:"query"`
+	ParamDefs   []db.ParamDef `json:"paramDefs,omitempty"`
+
+	// BaseRevision 更新时客户端所基于的修订号，与当前修订不一致时返回409
+	BaseRevision int64 `json:"baseRevision,omitempty"`
+}
+
+// requestUser 返回发起请求的用户，由前置的认证代理通过 X-Forwarded-User 传入，
+// 请求不是来自可信代理时忽略该请求头
+func requestUser(r *http.Request) string {
+	if !fromTrustedProxy(r) {
+		return anonymousUser
+	}
+	if user := strings.TrimSpace(r.Header.Get("X-Forwarded-User")); user != "" {
+		return user
+	}
+	return anonymousUser
+}
+
+// validate 检查请求字段
+func (req *SavedQueryRequest) validate() error {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return errors.New("查询标题不能为空")
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return errors.New("查询内容不能为空")
+	}
+	if req.DataSource != "" {
+		if _, err := db.GetDataSource(req.DataSource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SavedQueriesHandler 已保存查询列表和新建
+// GET  /api/saved-queries?owner=&tag=&datasource=&q=
+// POST /api/saved-queries
+func SavedQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		params := r.URL.Query()
+		queries, err := store.ListSavedQueries(r.Context(), store.SavedQueryFilter{
+			Owner:      params.Get("owner"),
+			Tag:        params.Get("tag"),
+			DataSource: params.Get("datasource"),
+			Search:     params.Get("q"),
+		})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"savedQueries": queries})
+
+	case "POST":
+		req, ok := decodeSavedQueryRequest(w, r)
+		if !ok {
+			return
+		}
+		q := &store.SavedQuery{
+			Owner:       requestUser(r),
+			Title:       req.Title,
+			Description: req.Description,
+			Tags:        req.Tags,
+			DataSource:  req.DataSource,
+			Query:       req.Query,
+			ParamDefs:   req.ParamDefs,
+		}
+		if err := store.CreateSavedQuery(r.Context(), q); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Printf("保存查询 %d: %s (%s)", q.ID, q.Title, q.Owner)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(q)
+
+	default:
+		http.Error(w, "只支持GET和POST请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// SavedQueryHandler 单个已保存查询及其修订历史
+// GET/PUT/DELETE /api/saved-queries/{id}
+// GET  /api/saved-queries/{id}/revisions
+// GET  /api/saved-queries/{id}/revisions/{rev}
+// POST /api/saved-queries/{id}/revisions/{rev}/restore
+// GET  /api/saved-queries/{id}/diff?from=1&to=2
+func SavedQueryHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/saved-queries/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		savedQueryItem(w, r, id)
+	case len(parts) == 2 && parts[1] == "revisions":
+		savedQueryRevisions(w, r, id)
+	case len(parts) >= 3 && len(parts) <= 4 && parts[1] == "revisions":
+		revision, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || revision <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if len(parts) == 3 {
+			savedQueryRevision(w, r, id, revision)
+		} else if parts[3] == "restore" {
+			restoreSavedQuery(w, r, id, revision)
+		} else {
+			http.NotFound(w, r)
+		}
+	case len(parts) == 2 && parts[1] == "diff":
+		diffSavedQuery(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// savedQueryItem 已保存查询的读取、更新和删除
+func savedQueryItem(w http.ResponseWriter, r *http.Request, id int64) {
+	switch r.Method {
+	case "GET":
+		q, err := store.GetSavedQuery(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(q)
+
+	case "PUT":
+		req, ok := decodeSavedQueryRequest(w, r)
+		if !ok {
+			return
+		}
+		q, ok := ownSavedQuery(w, r, id)
+		if !ok {
+			return
+		}
+		q.Title = req.Title
+		q.Description = req.Description
+		q.Tags = req.Tags
+		q.DataSource = req.DataSource
+		q.Query = req.Query
+		q.ParamDefs = req.ParamDefs
+		if err := store.UpdateSavedQuery(r.Context(), q, requestUser(r), req.BaseRevision); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Printf("更新已保存查询 %d: %s (修订 %d)", q.ID, q.Title, q.Revision)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(q)
+
+	case "DELETE":
+		if _, ok := ownSavedQuery(w, r, id); !ok {
+			return
+		}
+		if err := store.DeleteSavedQuery(r.Context(), id); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Printf("删除已保存查询 %d", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "只支持GET、PUT和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// ownSavedQuery 读取已保存查询并检查当前用户是否为所有者，只有所有者可以修改、恢复和删除查询
+// 失败时已写入错误响应
+func ownSavedQuery(w http.ResponseWriter, r *http.Request, id int64) (*store.SavedQuery, bool) {
+	q, err := store.GetSavedQuery(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return nil, false
+	}
+	if user := requestUser(r); q.Owner != user {
+		log.Printf("用户 %s 无权修改已保存查询 %d (所有者 %s)", user, id, q.Owner)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "只有查询的所有者可以修改或删除该查询"})
+		return nil, false
+	}
+	return q, true
+}
+
+// decodeSavedQueryRequest 解析并校验请求体，失败时已写入错误响应
+func decodeSavedQueryRequest(w http.ResponseWriter, r *http.Request) (*SavedQueryRequest, bool) {
+	var req SavedQueryRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // 保持参数默认值中数字的原始精度
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// recordSavedQueryRun 记录已保存查询的执行统计，失败只记录日志
+func recordSavedQueryRun(r *http.Request, id int64, result db.QueryResult) {
+	if id <= 0 {
+		return
+	}
+	run := store.SavedQueryRun{
+		At:       time.Now(),
+		Duration: result.Duration,
+		RowCount: int64(result.RowCount),
+		Error:    result.Error,
+	}
+	// 客户端断开后仍然记录
+	if err := store.RecordSavedQueryRun(context.WithoutCancel(r.Context()), id, run); err != nil {
+		log.Printf("记录已保存查询 %d 的执行统计失败: %v", id, err)
+	}
+}
+
+// writeStoreError 写入元数据库错误，记录不存在时返回404
+func writeStoreError(w http.ResponseWriter, err error) {
+	log.Printf("元数据库操作失败: %v", err)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, store.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, store.ErrNotOpen):
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"bi-web/db"
+)
+
+// SchemaHandler 返回数据源的表结构: GET /api/schema?datasource=x&database=y
+func SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	writeSchema(w, r, false)
+}
+
+// SchemaRefreshHandler 重新读取数据源的表结构: POST /api/schema/refresh?datasource=x&database=y
+func SchemaRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	writeSchema(w, r, true)
+}
+
+func writeSchema(w http.ResponseWriter, r *http.Request, refresh bool) {
+	dataSource := r.URL.Query().Get("datasource")
+	database := r.URL.Query().Get("database")
+
+	w.Header().Set("Content-Type", "application/json")
+	if dataSource == db.FileDataSource {
+		json.NewEncoder(w).Encode(db.FileSchema(fileSession(r)))
+		return
+	}
+	schema, err := db.GetSchema(r.Context(), dataSource, database, refresh)
+	if err != nil {
+		log.Printf("读取表结构失败: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, db.ErrUnknownDataSource) {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(schema)
+}
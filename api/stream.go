@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"bi-web/db"
+)
+
+// NDJSONContentType 流式查询结果的内容类型
+const NDJSONContentType = "application/x-ndjson"
+
+// 每写出多少行刷新一次响应
+const streamFlushRows = 500
+
+// wantsNDJSON 判断客户端是否请求流式结果
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), NDJSONContentType)
+}
+
+// streamHeader 流式结果的首行，描述列信息
+type streamHeader struct {
+	Type        string          `json:"type"`
+	QueryID     string          `json:"queryId"`
+	Columns     []string        `json:"columns"`
+	ColumnTypes []db.ColumnType `json:"columnTypes"`
+}
+
+// streamTrailer 流式结果的末行，描述执行统计或错误
+type streamTrailer struct {
+	Type      string `json:"type"`
+	QueryID   string `json:"queryId,omitempty"`
+	RowCount  int    `json:"rowCount"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// ndjsonWriter 将查询结果逐行写为 NDJSON
+// 首行为 {"type":"header",...}，中间每行是一个JSON数组，末行为 {"type":"trailer",...}
+type ndjsonWriter struct {
+	w        http.ResponseWriter
+	enc      *json.Encoder
+	flusher  http.Flusher
+	rowCount int
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	flusher, _ := w.(http.Flusher)
+	return &ndjsonWriter{w: w, enc: json.NewEncoder(w), flusher: flusher}
+}
+
+func (n *ndjsonWriter) WriteHeader(queryID string, columns []string, types []db.ColumnType) error {
+	return n.enc.Encode(streamHeader{Type: "header", QueryID: queryID, Columns: columns, ColumnTypes: types})
+}
+
+func (n *ndjsonWriter) WriteRow(row []interface{}) error {
+	if err := n.enc.Encode(row); err != nil {
+		return err
+	}
+	n.rowCount++
+	if n.rowCount%streamFlushRows == 0 {
+		n.flush()
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) writeTrailer(result db.QueryResult) {
+	n.enc.Encode(streamTrailer{
+		Type:      "trailer",
+		QueryID:   result.QueryID,
+		RowCount:  result.RowCount,
+		Truncated: result.Truncated,
+		Limit:     result.Limit,
+		Duration:  result.Duration,
+		Error:     result.Error,
+		ErrorCode: result.ErrorCode,
+	})
+	n.flush()
+}
+
+func (n *ndjsonWriter) flush() {
+	if n.flusher != nil {
+		n.flusher.Flush()
+	}
+}
+
+// streamQuery 以 NDJSON 流式返回查询结果，返回不含行数据的执行摘要
+func streamQuery(w http.ResponseWriter, r *http.Request, opts db.QueryOptions) db.QueryResult {
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	nw := newNDJSONWriter(w)
+	result := db.StreamSQL(r.Context(), opts, nw)
+
+	if result.QueryID == "" {
+		result.QueryID = opts.QueryID
+	}
+	nw.writeTrailer(result)
+
+	if result.Error != "" {
+		log.Printf("流式查询错误: %s", result.Error)
+	} else {
+		log.Printf("流式查询成功: 返回 %d 行数据, 耗时: %s", result.RowCount, result.Duration)
+	}
+	return result
+}
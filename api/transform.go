@@ -0,0 +1,518 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"bi-web/db"
+	"bi-web/store"
+)
+
+// 变换操作
+const (
+	TransformPivot   = "pivot"   // 长表转宽表
+	TransformUnpivot = "unpivot" // 宽表转长表
+	TransformTotals  = "totals"  // 追加小计和合计行
+)
+
+// 变换的限制：最多的步骤数，以及透视最多生成的列数
+const (
+	maxTransformSteps = 10
+	maxPivotColumns   = 1000
+)
+
+// 合计行和小计行的默认标签，透视列取值为空时的列名
+const (
+	defaultTotalLabel    = "合计"
+	defaultSubtotalLabel = "小计"
+	pivotNullColumn      = "(空)"
+)
+
+// TransformRequest 变换请求：对给出的查询结果或由服务端执行的查询依次执行各步骤
+type TransformRequest struct {
+	Result *db.QueryResult `json:"result,omitempty"` // 要变换的查询结果，如 /api/query 的响应
+	Source *QueryRequest   `json:"source,omitempty"` // 或者由服务端执行的查询，可以只给出 savedQueryId
+	Steps  []TransformStep `json:"steps"`
+}
+
+// TransformStep 一个变换步骤，不同操作使用的字段不同
+type TransformStep struct {
+	Op        string   `json:"op"`                  // pivot、unpivot 或 totals
+	Index     []string `json:"index,omitempty"`     // pivot/unpivot：保持为行的列
+	Pivot     string   `json:"pivot,omitempty"`     // pivot：取值展开为列的列
+	Values    []string `json:"values,omitempty"`    // pivot：聚合的值列；unpivot：转为行的列；totals：计算合计的列，默认所有数值列
+	Aggregate string   `json:"aggregate,omitempty"` // pivot/totals：sum（默认）、avg、count、min 或 max
+
+	Fill        interface{} `json:"fill,omitempty"`        // pivot：没有数据的单元格的值，默认为空
+	NameColumn  string      `json:"nameColumn,omitempty"`  // unpivot：原列名所在列的名称，默认 name
+	ValueColumn string      `json:"valueColumn,omitempty"` // unpivot：值所在列的名称，默认 value
+	DropNulls   bool        `json:"dropNulls,omitempty"`   // unpivot：跳过空值
+
+	GroupBy []string `json:"groupBy,omitempty"` // totals：按这些列逐级输出小计
+	Label   string   `json:"label,omitempty"`   // totals：合计行的标签，默认“合计”
+}
+
+// TransformResult 变换结果，与查询结果的格式相同
+type TransformResult struct {
+	db.QueryResult
+	TotalRows []int `json:"totalRows,omitempty"` // 小计和合计行的下标
+}
+
+// TransformHandler 处理变换请求: POST /api/transform
+func TransformHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransformRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // 保持结果中数字的原始精度
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "请求格式错误: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (req.Result == nil) == (req.Source == nil) {
+		http.Error(w, "result 和 source 需要且只能给出一个", http.StatusBadRequest)
+		return
+	}
+	if err := validateTransformSteps(req.Steps); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result db.QueryResult
+	if req.Source != nil {
+		src := req.Source
+		if err := loadSavedQuery(r, src); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if src.QueryID == "" {
+			src.QueryID = db.NewQueryID()
+		} else if !db.ValidQueryID(src.QueryID) {
+			http.Error(w, "查询ID格式错误", http.StatusBadRequest)
+			return
+		}
+		opts, err := src.options(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Query-ID", src.QueryID)
+
+		log.Printf("变换查询[%s] %s: %s", src.DataSource, src.QueryID, src.Query)
+		startedAt := time.Now()
+		result = db.ExecuteSQL(r.Context(), opts)
+		recordExecution(r, store.HistoryTransform, opts, src.SavedQueryID, startedAt, result)
+	} else {
+		result = *req.Result
+	}
+
+	out := TransformResult{QueryResult: result}
+	if result.Error == "" {
+		var err error
+		if out, err = applyTransformSteps(result, req.Steps); err != nil {
+			out = TransformResult{QueryResult: db.QueryResult{QueryID: result.QueryID, Error: err.Error()}}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+	if out.Error != "" {
+		log.Printf("变换错误: %s", out.Error)
+	}
+}
+
+// validateTransformSteps 检查变换步骤，合计必须是最后一步
+func validateTransformSteps(steps []TransformStep) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("缺少变换步骤")
+	}
+	if len(steps) > maxTransformSteps {
+		return fmt.Errorf("最多 %d 个变换步骤", maxTransformSteps)
+	}
+	for i := range steps {
+		s := &steps[i]
+		switch s.Op {
+		case TransformPivot:
+			if s.Pivot == "" {
+				return fmt.Errorf("第 %d 步: 透视缺少 pivot 列", i+1)
+			}
+		case TransformUnpivot:
+			if s.NameColumn == "" {
+				s.NameColumn = "name"
+			}
+			if s.ValueColumn == "" {
+				s.ValueColumn = "value"
+			}
+		case TransformTotals:
+			if i != len(steps)-1 {
+				return fmt.Errorf("第 %d 步: totals 必须是最后一步", i+1)
+			}
+			if s.Label == "" {
+				s.Label = defaultTotalLabel
+			}
+		default:
+			return fmt.Errorf("第 %d 步: 不支持的变换 %s，只能是 pivot、unpivot 或 totals", i+1, s.Op)
+		}
+		if s.Op != TransformUnpivot {
+			switch s.Aggregate {
+			case "":
+				s.Aggregate = AggregateSum
+			case AggregateSum, AggregateAvg, AggregateCount, AggregateMin, AggregateMax:
+			default:
+				return fmt.Errorf("第 %d 步: 不支持的聚合方式 %s，只能是 sum、avg、count、min 或 max", i+1, s.Aggregate)
+			}
+		}
+	}
+	return nil
+}
+
+// applyTransformSteps 依次执行变换步骤
+func applyTransformSteps(result db.QueryResult, steps []TransformStep) (TransformResult, error) {
+	out := TransformResult{QueryResult: result}
+	if len(out.ColumnTypes) != len(out.Columns) {
+		out.ColumnTypes = nil
+	}
+	for i, step := range steps {
+		var err error
+		switch step.Op {
+		case TransformPivot:
+			err = pivotResult(&out.QueryResult, step)
+		case TransformUnpivot:
+			err = unpivotResult(&out.QueryResult, step)
+		case TransformTotals:
+			out.TotalRows, err = totalsResult(&out.QueryResult, step)
+		}
+		if err != nil {
+			return out, fmt.Errorf("第 %d 步 %s: %w", i+1, step.Op, err)
+		}
+	}
+	out.RowCount = len(out.Rows)
+	return out, nil
+}
+
+// resolveColumns 按名称查找列，names 为空时返回 nil
+func resolveColumns(columns []string, names []string) ([]int, error) {
+	var idx []int
+	for _, name := range names {
+		i := columnIndex(columns, name)
+		if i < 0 {
+			return nil, fmt.Errorf("列 %s 不存在", name)
+		}
+		idx = append(idx, i)
+	}
+	return idx, nil
+}
+
+// otherColumns 返回不在 exclude 中的列
+func otherColumns(count int, exclude ...[]int) []int {
+	skip := make(map[int]bool)
+	for _, idx := range exclude {
+		for _, i := range idx {
+			skip[i] = true
+		}
+	}
+	var rest []int
+	for i := 0; i < count; i++ {
+		if !skip[i] {
+			rest = append(rest, i)
+		}
+	}
+	return rest
+}
+
+// aggregatedType 聚合后值列的类型
+func aggregatedType(t db.ColumnType, how string) db.ColumnType {
+	switch {
+	case how == AggregateCount:
+		return db.ColumnType{Name: t.Name, Kind: db.KindInteger}
+	case how == AggregateAvg && t.Kind == db.KindInteger:
+		return db.ColumnType{Name: t.Name, Kind: db.KindFloat}
+	}
+	return t
+}
+
+// pivotResult 长表转宽表：index 列相同的行合并为一行，pivot 列的每个取值（按首次出现的顺序）展开为一列，
+// 单元格为 values 列按聚合方式计算的值。有多个值列时列名为“取值.值列”
+func pivotResult(res *db.QueryResult, step TransformStep) error {
+	pivotCol := columnIndex(res.Columns, step.Pivot)
+	if pivotCol < 0 {
+		return fmt.Errorf("列 %s 不存在", step.Pivot)
+	}
+	index, err := resolveColumns(res.Columns, step.Index)
+	if err != nil {
+		return err
+	}
+	values, err := resolveColumns(res.Columns, step.Values)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(index) == 0 && len(values) == 0:
+		// 都未指定时最后一列为值列，其余为行
+		rest := otherColumns(len(res.Columns), []int{pivotCol})
+		if len(rest) == 0 {
+			return fmt.Errorf("没有可以聚合的值列")
+		}
+		values, index = rest[len(rest)-1:], rest[:len(rest)-1]
+	case len(index) == 0:
+		index = otherColumns(len(res.Columns), []int{pivotCol}, values)
+	case len(values) == 0:
+		values = otherColumns(len(res.Columns), []int{pivotCol}, index)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("没有可以聚合的值列")
+	}
+
+	// 按行键和透视列取值分组，都保持首次出现的顺序
+	var rowOrder, pivotOrder []string
+	rowKeys := make(map[string][]interface{})
+	pivotSeen := make(map[string]bool)
+	cells := make(map[string]map[string][][]interface{})
+	for _, row := range res.Rows {
+		key, keys, ok := mergeKey(row, index)
+		if !ok || pivotCol >= len(row) {
+			continue
+		}
+		name := pivotNullColumn
+		if row[pivotCol] != nil {
+			name = fmt.Sprintf("%v", row[pivotCol])
+		}
+		if _, seen := rowKeys[key]; !seen {
+			rowKeys[key] = keys
+			rowOrder = append(rowOrder, key)
+			cells[key] = make(map[string][][]interface{})
+		}
+		if !pivotSeen[name] {
+			if len(pivotOrder) >= maxPivotColumns {
+				return fmt.Errorf("%s 的取值超过 %d 个", step.Pivot, maxPivotColumns)
+			}
+			pivotSeen[name] = true
+			pivotOrder = append(pivotOrder, name)
+		}
+		cells[key][name] = append(cells[key][name], row)
+	}
+
+	columns := make([]string, 0, len(index)+len(pivotOrder)*len(values))
+	var types []db.ColumnType
+	for _, i := range index {
+		columns = append(columns, res.Columns[i])
+		if res.ColumnTypes != nil {
+			types = append(types, res.ColumnTypes[i])
+		}
+	}
+	for _, name := range pivotOrder {
+		for _, v := range values {
+			column := name
+			if len(values) > 1 {
+				column = name + "." + res.Columns[v]
+			}
+			columns = append(columns, column)
+			if res.ColumnTypes != nil {
+				t := aggregatedType(res.ColumnTypes[v], step.Aggregate)
+				t.Name = column
+				types = append(types, t)
+			}
+		}
+	}
+
+	rows := make([][]interface{}, 0, len(rowOrder))
+	for _, key := range rowOrder {
+		row := append(make([]interface{}, 0, len(columns)), rowKeys[key]...)
+		for _, name := range pivotOrder {
+			group := cells[key][name]
+			for _, v := range values {
+				if len(group) == 0 {
+					row = append(row, step.Fill)
+					continue
+				}
+				row = append(row, aggregateColumn(group, v, step.Aggregate))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	res.Columns, res.ColumnTypes, res.Rows = columns, types, rows
+	return nil
+}
+
+// unpivotResult 宽表转长表：values 中的每一列转为一行，原列名写入 nameColumn，值写入 valueColumn
+func unpivotResult(res *db.QueryResult, step TransformStep) error {
+	index, err := resolveColumns(res.Columns, step.Index)
+	if err != nil {
+		return err
+	}
+	values, err := resolveColumns(res.Columns, step.Values)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		values = otherColumns(len(res.Columns), index)
+	} else if len(index) == 0 {
+		index = otherColumns(len(res.Columns), values)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("没有要转为行的列")
+	}
+
+	columns := make([]string, 0, len(index)+2)
+	var types []db.ColumnType
+	for _, i := range index {
+		columns = append(columns, res.Columns[i])
+		if res.ColumnTypes != nil {
+			types = append(types, res.ColumnTypes[i])
+		}
+	}
+	columns = append(columns, step.NameColumn, step.ValueColumn)
+	if res.ColumnTypes != nil {
+		// 值列类型一致时沿用，都是数值时为 float，否则为 string
+		valueType := res.ColumnTypes[values[0]]
+		for _, v := range values[1:] {
+			t := res.ColumnTypes[v]
+			if t.Kind == valueType.Kind && t.DatabaseType == valueType.DatabaseType {
+				continue
+			}
+			if isNumericKind(t.Kind) && isNumericKind(valueType.Kind) {
+				valueType = db.ColumnType{Kind: db.KindFloat}
+			} else {
+				valueType = db.ColumnType{Kind: db.KindString}
+			}
+		}
+		valueType.Name = step.ValueColumn
+		types = append(types, db.ColumnType{Name: step.NameColumn, Kind: db.KindString}, valueType)
+	}
+
+	rows := make([][]interface{}, 0, len(res.Rows)*len(values))
+	for _, row := range res.Rows {
+		for _, v := range values {
+			value := cell(row, v)
+			if value == nil && step.DropNulls {
+				continue
+			}
+			out := make([]interface{}, 0, len(columns))
+			for _, i := range index {
+				out = append(out, cell(row, i))
+			}
+			rows = append(rows, append(out, res.Columns[v], value))
+		}
+	}
+
+	res.Columns, res.ColumnTypes, res.Rows = columns, types, rows
+	return nil
+}
+
+func isNumericKind(kind string) bool {
+	return kind == db.KindInteger || kind == db.KindFloat || kind == db.KindDecimal
+}
+
+// totalsResult 追加合计行，指定 groupBy 时按各级分组（按首次出现的顺序）重排行并在每组之后追加小计行。
+// 小计的标签写在下一级分组列中，最后一级写在第一个既不是分组列也不是合计列的列中；合计的标签写在第一列。
+// 返回小计和合计行的下标
+func totalsResult(res *db.QueryResult, step TransformStep) ([]int, error) {
+	groupBy, err := resolveColumns(res.Columns, step.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+	values, err := resolveColumns(res.Columns, step.Values)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		// 默认合计所有数值列
+		for _, i := range otherColumns(len(res.Columns), groupBy) {
+			var t *db.ColumnType
+			if res.ColumnTypes != nil {
+				t = &res.ColumnTypes[i]
+			}
+			if detectSortType(res.Rows, i, t) == SortNumber {
+				values = append(values, i)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("没有可以合计的数值列")
+	}
+	labelCol := -1
+	if rest := otherColumns(len(res.Columns), groupBy, values); len(rest) > 0 {
+		labelCol = rest[0]
+	}
+	labelled := make(map[int]bool)
+
+	// total 生成一组行的合计行，prefix 为该组各级分组列的值
+	total := func(rows [][]interface{}, prefix []interface{}, label string, at int) []interface{} {
+		out := make([]interface{}, len(res.Columns))
+		for l, v := range prefix {
+			out[groupBy[l]] = v
+		}
+		if at >= 0 {
+			out[at] = label
+			labelled[at] = true
+		}
+		for _, v := range values {
+			out[v] = aggregateColumn(rows, v, step.Aggregate)
+		}
+		return out
+	}
+
+	var rows [][]interface{}
+	var totalRows []int
+	var emit func(group [][]interface{}, level int, prefix []interface{})
+	emit = func(group [][]interface{}, level int, prefix []interface{}) {
+		if level == len(groupBy) {
+			rows = append(rows, group...)
+			return
+		}
+		var order []string
+		byKey := make(map[string][][]interface{})
+		first := make(map[string]interface{})
+		for _, row := range group {
+			key := fmt.Sprintf("%v", cell(row, groupBy[level]))
+			if _, seen := byKey[key]; !seen {
+				order = append(order, key)
+				first[key] = cell(row, groupBy[level])
+			}
+			byKey[key] = append(byKey[key], row)
+		}
+		at := labelCol
+		if level+1 < len(groupBy) {
+			at = groupBy[level+1]
+		}
+		for _, key := range order {
+			sub := append(append([]interface{}{}, prefix...), first[key])
+			emit(byKey[key], level+1, sub)
+			totalRows = append(totalRows, len(rows))
+			rows = append(rows, total(byKey[key], sub, defaultSubtotalLabel, at))
+		}
+	}
+	emit(res.Rows, 0, nil)
+
+	// 合计的标签写在第一列，第一列是合计列时不写标签
+	at := 0
+	for _, v := range values {
+		if v == 0 {
+			at = -1
+		}
+	}
+	totalRows = append(totalRows, len(rows))
+	rows = append(rows, total(res.Rows, nil, step.Label, at))
+
+	if res.ColumnTypes != nil {
+		for i := range labelled {
+			if res.ColumnTypes[i].Kind != db.KindString {
+				res.ColumnTypes[i] = db.ColumnType{Name: res.ColumnTypes[i].Name, Kind: db.KindString}
+			}
+		}
+		if step.Aggregate == AggregateAvg {
+			for _, v := range values {
+				res.ColumnTypes[v] = aggregatedType(res.ColumnTypes[v], step.Aggregate)
+			}
+		}
+	}
+	res.Rows = rows
+	return totalRows, nil
+}
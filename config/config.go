@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config 应用配置结构
@@ -14,9 +17,48 @@ type Config struct {
 	DBUser     string
 	DBPassword string
 	DBName     string
+	DBReadOnly bool
 	Port       string
+
+	// QueryTimeout 默认查询超时，MaxQueryTimeout 请求可指定的最大超时
+	QueryTimeout    time.Duration
+	MaxQueryTimeout time.Duration
+
+	// MaxRows 单次查询最多返回的行数，0 表示不限制
+	MaxRows int
+
+	// DataSources 所有已配置的数据源，第一个为默认数据源
+	DataSources []DataSourceConfig
+
+	// StorePath 元数据库（SQLite）文件路径，保存已保存查询等应用数据
+	StorePath string
+
+	// FileDir 文件数据源的会话数据库目录，FileSessionTTL 会话空闲多久后删除上传的文件
+	FileDir        string
+	FileSessionTTL time.Duration
+
+	// TrustedProxies 可信的反向代理地址（IP 或 CIDR），只有来自这些地址的请求才采信
+	// X-Forwarded-User、X-Forwarded-For 和 X-Real-IP
+	TrustedProxies []string
 }
 
+// DataSourceConfig 单个数据源配置
+type DataSourceConfig struct {
+	Name     string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+	ReadOnly bool // 只读模式，只允许执行单条只读语句
+}
+
+// DefaultDataSourceName 由 DB_* 变量构成的默认数据源名称
+const DefaultDataSourceName = "default"
+
+// FileDataSourceName 文件数据源的保留名称，DATASOURCES 中不能使用
+const FileDataSourceName = "files"
+
 // LoadConfig 加载应用配置
 // 配置加载优先级：
 // 1. 环境变量
@@ -39,19 +81,83 @@ func LoadConfig() *Config {
 		DBUser:     getEnv("DB_USER", "root"),
 		DBPassword: getEnv("DB_PASSWORD", ""),
 		DBName:     getEnv("DB_NAME", "test"),
+		DBReadOnly: getBoolEnv("DB_READ_ONLY", false),
 		Port:       getEnv("PORT", "8081"),
+
+		QueryTimeout:    getDurationEnv("QUERY_TIMEOUT", 60*time.Second),
+		MaxQueryTimeout: getDurationEnv("QUERY_MAX_TIMEOUT", 10*time.Minute),
+		MaxRows:         getIntEnv("QUERY_MAX_ROWS", 10000),
+
+		StorePath: getEnv("STORE_PATH", "data/bi-web.db"),
+
+		FileDir:        getEnv("FILE_DIR", filepath.Join(os.TempDir(), "bi-web-files")),
+		FileSessionTTL: getDurationEnv("FILE_SESSION_TTL", 2*time.Hour),
+
+		TrustedProxies: getListEnv("TRUSTED_PROXIES"),
 	}
+	config.DataSources = loadDataSources(config)
 	
-	log.Printf("数据库配置: %s@%s:%s/%s", 
+	log.Printf("数据库配置: %s@%s:%s/%s (只读: %v)", 
 		config.DBUser, 
 		config.DBHost, 
 		config.DBPort, 
-		config.DBName)
+		config.DBName,
+		config.DBReadOnly)
+	for _, ds := range config.DataSources[1:] {
+		log.Printf("数据源 %s: %s@%s:%s/%s (只读: %v)", ds.Name, ds.User, ds.Host, ds.Port, ds.Database, ds.ReadOnly)
+	}
+	log.Printf("查询超时: 默认 %v, 最大 %v", config.QueryTimeout, config.MaxQueryTimeout)
+	log.Printf("查询最大行数: %d", config.MaxRows)
+	log.Printf("元数据库: %s", config.StorePath)
+	log.Printf("文件数据源: %s (会话空闲 %v 后删除)", config.FileDir, config.FileSessionTTL)
+	log.Printf("可信代理: %v", config.TrustedProxies)
 	log.Printf("应用端口: %s", config.Port)
 	
 	return config
 }
 
+// loadDataSources 加载数据源列表
+// DATASOURCES 为逗号分隔的数据源名称，例如 DATASOURCES=sales,crm，
+// 每个数据源通过 DS_<NAME>_HOST/PORT/USER/PASSWORD/NAME 配置，
+// 未设置的项沿用 DB_* 的值
+func loadDataSources(c *Config) []DataSourceConfig {
+	sources := []DataSourceConfig{{
+		Name:     DefaultDataSourceName,
+		Host:     c.DBHost,
+		Port:     c.DBPort,
+		User:     c.DBUser,
+		Password: c.DBPassword,
+		Database: c.DBName,
+		ReadOnly: c.DBReadOnly,
+	}}
+
+	seen := map[string]bool{DefaultDataSourceName: true}
+	for _, name := range strings.Split(os.Getenv("DATASOURCES"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if name == FileDataSourceName {
+			log.Printf("数据源名称 %s 为文件数据源保留，已忽略", name)
+			continue
+		}
+		seen[name] = true
+
+		prefix := "DS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		sources = append(sources, DataSourceConfig{
+			Name:     name,
+			Host:     getEnv(prefix+"HOST", c.DBHost),
+			Port:     getEnv(prefix+"PORT", c.DBPort),
+			User:     getEnv(prefix+"USER", c.DBUser),
+			Password: getEnv(prefix+"PASSWORD", c.DBPassword),
+			Database: getEnv(prefix+"NAME", name),
+			ReadOnly: getBoolEnv(prefix+"READ_ONLY", c.DBReadOnly),
+		})
+	}
+
+	return sources
+}
+
 // 从.env文件加载环境变量
 func loadEnvFile(filename string) {
 	file, err := os.Open(filename)
@@ -108,18 +214,86 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// 获取布尔类型的环境变量，支持 true/false/1/0 等写法
+func getBoolEnv(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("环境变量 %s 格式错误: %s，使用默认值 %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// 获取整数类型的环境变量，格式错误或为负数时返回默认值
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("环境变量 %s 格式错误: %s，使用默认值 %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// 获取时长类型的环境变量，如 30s、5m，格式错误时返回默认值
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("环境变量 %s 格式错误: %s，使用默认值 %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// 获取逗号分隔的列表类型的环境变量，忽略空项
+func getListEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // String 返回配置的字符串表示
 func (c *Config) String() string {
+	names := make([]string, 0, len(c.DataSources))
+	for _, ds := range c.DataSources {
+		names = append(names, ds.Name)
+	}
 	return "Config{" +
 		"DBHost=" + c.DBHost + ", " +
 		"DBPort=" + c.DBPort + ", " +
 		"DBUser=" + c.DBUser + ", " +
 		"DBPassword=****" + ", " +
 		"DBName=" + c.DBName + ", " +
+		"DataSources=[" + strings.Join(names, ",") + "], " +
+		"QueryTimeout=" + c.QueryTimeout.String() + ", " +
+		"MaxQueryTimeout=" + c.MaxQueryTimeout.String() + ", " +
+		"MaxRows=" + strconv.Itoa(c.MaxRows) + ", " +
+		"StorePath=" + c.StorePath + ", " +
 		"Port=" + c.Port + "}"
 }
 
 // GetDSN 返回数据库连接字符串
 func (c *Config) GetDSN() string {
 	return c.DBUser + ":" + c.DBPassword + "@tcp(" + c.DBHost + ":" + c.DBPort + ")/" + c.DBName + "?parseTime=true&charset=utf8mb4"
+}
+
+// GetDSN 返回数据源的连接字符串
+func (d DataSourceConfig) GetDSN() string {
+	return d.User + ":" + d.Password + "@tcp(" + d.Host + ":" + d.Port + ")/" + d.Database +
+		"?charset=utf8mb4&parseTime=True&loc=Local&sql_mode='STRICT_TRANS_TABLES,NO_ZERO_DATE,NO_ZERO_IN_DATE,ERROR_FOR_DIVISION_BY_ZERO'"
 }
\ No newline at end of file
@@ -1,144 +1,470 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	"bi-web/config"
-	_ "github.com/go-sql-driver/mysql"
+	"bi-web/sqlparse"
+	"github.com/go-sql-driver/mysql"
 )
 
-// DB 全局数据库连接
-var DB *sql.DB
+// 查询错误码，便于前端区分错误类型
+const (
+	ErrCodeTimeout   = "timeout"   // 查询超时
+	ErrCodeCancelled = "cancelled" // 查询被取消
+	ErrCodeReadOnly  = "readonly"  // 只读数据源拒绝执行
+	ErrCodeParams    = "params"    // 参数校验失败
+)
+
+// ReadOnlyError 只读数据源拒绝执行的语句
+type ReadOnlyError struct {
+	Reason error
+}
+
+func (e *ReadOnlyError) Error() string {
+	return e.Reason.Error()
+}
+
+func (e *ReadOnlyError) Unwrap() error {
+	return e.Reason
+}
+
+// QueryResult 查询结果结构
+type QueryResult struct {
+	QueryID     string          `json:"queryId,omitempty"` // 查询ID，可用于取消查询
+	Columns     []string        `json:"columns"`
+	ColumnTypes []ColumnType    `json:"columnTypes,omitempty"` // 列类型信息
+	Rows        [][]interface{} `json:"rows"`
+	Error       string          `json:"error,omitempty"`
+	ErrorCode   string          `json:"errorCode,omitempty"` // 错误码，如 timeout
+	Duration    string          `json:"duration,omitempty"`  // 执行耗时
+	RowCount    int             `json:"rowCount,omitempty"`  // 行数
+	Truncated   bool            `json:"truncated,omitempty"` // 结果是否因行数限制被截断
+	Limit       int             `json:"limit,omitempty"`     // 本次查询使用的行数限制
+}
+
+// QueryOptions 查询执行选项
+type QueryOptions struct {
+	DataSource string        // 数据源名称，为空时使用默认数据源
+	Query      string        // SQL文本
+	Timeout    time.Duration // 查询超时，为0时使用默认超时，超过上限时取上限
+	QueryID    string        // 查询ID，为空时自动生成
+	MaxRows    int           // 最多读取的行数，0 表示不限制
+	NumberMode NumberMode    // 精确数值的编码方式，为空时使用 NumberAuto
+	Session    string        // 文件数据源的会话标识，只在 DataSource 为 FileDataSource 时使用
+
+	// ExecTimeoutOnly 超时只限制语句执行到开始返回结果为止，之后读取和写出结果不受超时限制，
+	// 用于导出等受客户端下载速度影响的场景
+	ExecTimeoutOnly bool
+
+	// ParamDefs 命名参数声明，Params 参数值
+	// SQL中的 :name 或 {{name}} 会被替换为驱动占位符，未声明的参数按字符串处理
+	ParamDefs []ParamDef
+	Params    map[string]interface{}
+}
+
+// 查询超时和行数设置，由 Connect 根据配置初始化
+var (
+	defaultQueryTimeout = 60 * time.Second
+	maxQueryTimeout     = 10 * time.Minute
+	maxRows             = 10000
+)
+
+// SetMaxRows 设置非流式查询最多返回的行数，0 表示不限制
+func SetMaxRows(n int) {
+	if n >= 0 {
+		maxRows = n
+	}
+}
+
+// effectiveRowLimit 计算实际使用的行数限制，请求的限制不能超过全局上限
+func effectiveRowLimit(requested int) int {
+	if requested <= 0 || (maxRows > 0 && requested > maxRows) {
+		return maxRows
+	}
+	return requested
+}
+
+// SetQueryTimeouts 设置默认和最大查询超时
+func SetQueryTimeouts(def, max time.Duration) {
+	if max > 0 {
+		maxQueryTimeout = max
+	}
+	if def > 0 {
+		defaultQueryTimeout = def
+	}
+	if defaultQueryTimeout > maxQueryTimeout {
+		defaultQueryTimeout = maxQueryTimeout
+	}
+}
+
+// effectiveTimeout 计算实际使用的查询超时
+func effectiveTimeout(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return defaultQueryTimeout
+	}
+	if requested > maxQueryTimeout {
+		return maxQueryTimeout
+	}
+	return requested
+}
+
+// execution 单次查询的执行状态
+type execution struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	conn      *sql.Conn
+	tx        *sql.Tx       // 只读数据源上的只读事务
+	files     *fileSession  // 文件数据源的会话，执行期间登记为使用中
+	sql       string        // 替换命名参数后的SQL
+	args      []interface{} // 占位符对应的参数值
+	running   *RunningQuery
+	discard   bool        // 关闭时断开执行连接而不是归还连接池
+	stopKill  func() bool // 取消超时或断开时的 KILL QUERY
+	timer     *time.Timer // 到达超时时以 context.DeadlineExceeded 为原因取消上下文
+	timeout   time.Duration
+	startTime time.Time
+}
+
+// startExecution 从数据源获取专用连接并登记为正在执行的查询
+// 无论是否出错，调用方都需要调用 close 释放资源
+func startExecution(ctx context.Context, opts QueryOptions) (*execution, error) {
+	e := &execution{
+		timeout:   effectiveTimeout(opts.Timeout),
+		startTime: time.Now(),
+	}
+	// 用定时器而不是截止时间实现超时，ExecTimeoutOnly 时可以在开始返回结果后停止计时
+	ctx, cancel := context.WithCancelCause(ctx)
+	e.ctx, e.cancel = ctx, func() { cancel(nil) }
+	e.timer = time.AfterFunc(e.timeout, func() { cancel(context.DeadlineExceeded) })
 
-// Connect 连接到数据库
-func Connect(cfg *config.Config) error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&sql_mode='STRICT_TRANS_TABLES,NO_ZERO_DATE,NO_ZERO_IN_DATE,ERROR_FOR_DIVISION_BY_ZERO'",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-	
 	var err error
-	DB, err = sql.Open("mysql", dsn)
+	e.sql, e.args, err = bindParams(opts.Query, opts.ParamDefs, opts.Params)
 	if err != nil {
-		return fmt.Errorf("数据库连接失败: %w", err)
+		return e, err
 	}
 
-	// 测试连接
-	if err = DB.Ping(); err != nil {
-		log.Printf("数据库连接测试失败: %v", err)
-		log.Println("继续运行，但数据库功能可能不可用")
-		// 即使连接失败，也不返回错误，但确保DB对象已初始化
+	var dataSource string
+	var pool *sql.DB
+	var connID int64
+	if opts.DataSource == FileDataSource {
+		dataSource = FileDataSource
+		err = e.openFileSession(opts)
+	} else {
+		dataSource, pool, connID, err = e.openDataSource(opts)
+	}
+	if err != nil {
+		return e, err
 	}
 
-	// 设置连接池参数
-	DB.SetMaxOpenConns(25)
-	DB.SetMaxIdleConns(5)
-	DB.SetConnMaxLifetime(time.Hour)
-	DB.SetConnMaxIdleTime(30 * time.Minute)
+	queryID := opts.QueryID
+	if queryID == "" {
+		queryID = NewQueryID()
+	}
+	e.running = &RunningQuery{
+		ID:         queryID,
+		DataSource: dataSource,
+		ConnID:     connID,
+		Query:      opts.Query,
+		StartTime:  e.startTime,
+		pool:       pool,
+		cancel:     e.cancel,
+	}
+	if err := registerQuery(e.running); err != nil {
+		e.running = nil
+		return e, err
+	}
 
-	return nil
+	// 超时或客户端断开时驱动只关闭连接，MySQL 端仍会继续执行，需要另外执行 KILL QUERY
+	if pool != nil {
+		running := e.running
+		e.stopKill = context.AfterFunc(e.ctx, func() {
+			log.Printf("查询 %s 超时或客户端断开，终止 MySQL 端的执行 (连接ID %d)", running.ID, running.ConnID)
+			running.kill(context.Background())
+		})
+	}
+
+	return e, nil
 }
 
-// Close 关闭数据库连接
-func Close() {
-	if DB != nil {
-		DB.Close()
+// openDataSource 从 MySQL 数据源获取执行连接，返回数据源名称、连接池和连接ID
+func (e *execution) openDataSource(opts QueryOptions) (string, *sql.DB, int64, error) {
+	ds, err := GetDataSource(opts.DataSource)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	// 只读数据源在语句到达驱动之前拒绝非只读语句
+	if ds.Config.ReadOnly {
+		if err := sqlparse.CheckReadOnly(opts.Query); err != nil {
+			return "", nil, 0, &ReadOnlyError{Reason: err}
+		}
+	}
+
+	pool, err := GetDB(e.ctx, ds.Config.Name)
+	if err != nil {
+		return "", nil, 0, err
 	}
+
+	e.conn, err = pool.Conn(e.ctx)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	// 记录连接ID，取消查询时用于 KILL QUERY
+	var connID int64
+	if err := e.conn.QueryRowContext(e.ctx, "SELECT CONNECTION_ID()").Scan(&connID); err != nil {
+		return "", nil, 0, err
+	}
+
+	// 纵深防御：只读数据源的查询在只读事务中执行
+	if ds.Config.ReadOnly {
+		e.tx, err = e.conn.BeginTx(e.ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return "", nil, 0, err
+		}
+	}
+	return ds.Config.Name, pool, connID, nil
 }
 
-// QueryResult 查询结果结构
-type QueryResult struct {
-	Columns   []string        `json:"columns"`
-	Rows      [][]interface{} `json:"rows"`
-	Error     string          `json:"error,omitempty"`
-	Duration  string          `json:"duration,omitempty"`  // 执行耗时
-	RowCount  int             `json:"rowCount,omitempty"`  // 行数
+// openFileSession 从会话的 SQLite 数据库获取执行连接
+// 文件表只能通过上传创建和删除，查询只允许只读语句；SQLite 没有连接ID，取消时直接中断上下文
+func (e *execution) openFileSession(opts QueryOptions) error {
+	if err := sqlparse.CheckReadOnly(opts.Query); err != nil {
+		return &ReadOnlyError{Reason: err}
+	}
+	var err error
+	e.files, err = acquireFileSession(opts.Session, false)
+	if err != nil {
+		return err
+	}
+	e.conn, err = e.files.query.Conn(e.ctx)
+	return err
 }
 
-// ExecuteSQL 执行SQL查询
-func ExecuteSQL(query string) QueryResult {
-	// 记录开始时间
-	startTime := time.Now()
-	
-	if DB == nil {
-		log.Println("数据库连接为空，尝试重新连接")
-		// 尝试重新连接数据库
-		cfg := config.LoadConfig()
-		if err := Connect(cfg); err != nil {
-			return QueryResult{Error: "数据库连接失败: " + err.Error()}
-		}
-		
-		if DB == nil {
-			return QueryResult{Error: "数据库连接仍然为空"}
-		}
+// query 在执行连接（或只读事务）上执行绑定参数后的查询
+func (e *execution) query() (*sql.Rows, error) {
+	if e.tx != nil {
+		return e.tx.QueryContext(e.ctx, e.sql, e.args...)
 	}
+	return e.conn.QueryContext(e.ctx, e.sql, e.args...)
+}
 
-	// 测试连接是否有效
-	if err := DB.Ping(); err != nil {
-		log.Printf("数据库连接无效，尝试重新连接: %v", err)
-		// 尝试重新连接
-		cfg := config.LoadConfig()
-		if err := Connect(cfg); err != nil {
-			return QueryResult{Error: "数据库重连失败: " + err.Error()}
+// close 释放连接并移除查询登记
+func (e *execution) close() {
+	if e.stopKill != nil {
+		e.stopKill()
+	}
+	if e.running != nil {
+		unregisterQuery(e.running.ID)
+		e.running.finish()
+	}
+	if e.tx != nil {
+		e.tx.Rollback()
+	}
+	if e.conn != nil {
+		if e.discard {
+			// 返回 ErrBadConn 时连接池关闭底层连接，不再复用
+			e.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
 		}
+		e.conn.Close()
+	}
+	if e.files != nil {
+		e.files.release()
 	}
+	e.timer.Stop()
+	e.cancel()
+}
+
+// queryID 返回本次执行的查询ID
+func (e *execution) queryID() string {
+	if e.running == nil {
+		return ""
+	}
+	return e.running.ID
+}
 
-	rows, err := DB.Query(query)
+// fail 根据执行状态构造错误结果，取消和超时使用单独的错误码
+func (e *execution) fail(err error, prefix string) QueryResult {
+	duration := time.Since(e.startTime)
+	result := QueryResult{QueryID: e.queryID(), Duration: formatDuration(duration)}
+
+	var roErr *ReadOnlyError
+	var paramErr *ParamError
+	switch {
+	case errors.As(err, &roErr):
+		log.Printf("只读数据源拒绝执行: %v", err)
+		result.Error = err.Error()
+		result.ErrorCode = ErrCodeReadOnly
+	case errors.As(err, &paramErr):
+		log.Printf("查询参数错误: %v", err)
+		result.Error = err.Error()
+		result.ErrorCode = ErrCodeParams
+	case e.running != nil && e.running.Cancelled():
+		log.Printf("查询 %s 已被取消: %v", e.running.ID, err)
+		result.Error = "查询已被取消"
+		result.ErrorCode = ErrCodeCancelled
+	case errors.Is(context.Cause(e.ctx), context.DeadlineExceeded):
+		log.Printf("查询超时: 超过 %v", e.timeout)
+		result.Error = fmt.Sprintf("查询超时: 执行时间超过 %s", formatDuration(e.timeout))
+		result.ErrorCode = ErrCodeTimeout
+	case errors.Is(e.ctx.Err(), context.Canceled):
+		log.Printf("查询已取消: %v", err)
+		result.Error = "查询已取消"
+		result.ErrorCode = ErrCodeCancelled
+	default:
+		result.Error = prefix + err.Error()
+	}
+
+	return result
+}
+
+// RowWriter 逐行接收查询结果
+type RowWriter interface {
+	// WriteHeader 在读取第一行之前调用一次
+	WriteHeader(queryID string, columns []string, types []ColumnType) error
+	// WriteRow 每扫描一行调用一次，row 在调用返回后不会被复用
+	WriteRow(row []interface{}) error
+}
+
+// collectWriter 将结果收集到内存中，供 ExecuteSQL 使用
+type collectWriter struct {
+	columns []string
+	types   []ColumnType
+	rows    [][]interface{}
+}
+
+func (c *collectWriter) WriteHeader(queryID string, columns []string, types []ColumnType) error {
+	c.columns = columns
+	c.types = types
+	return nil
+}
+
+func (c *collectWriter) WriteRow(row []interface{}) error {
+	c.rows = append(c.rows, row)
+	return nil
+}
+
+// ExecuteSQL 执行SQL查询并将结果收集到内存中，行数受全局上限约束
+// ctx 取消（如客户端断开连接）、超时或通过 CancelQuery 取消时，查询会被中断
+func ExecuteSQL(ctx context.Context, opts QueryOptions) QueryResult {
+	opts.MaxRows = effectiveRowLimit(opts.MaxRows)
+	collector := &collectWriter{}
+	result := StreamSQL(ctx, opts, collector)
+	if result.Error != "" {
+		return result
+	}
+
+	result.Columns = collector.columns
+	result.ColumnTypes = collector.types
+	result.Rows = collector.rows
+	return result
+}
+
+// StreamSQL 执行SQL查询，每扫描一行就交给 w，不在内存中缓存结果
+// 只有 opts.MaxRows 限制行数，不受全局上限约束
+// 返回的结果只包含查询ID、行数、截断信息、耗时和错误信息
+func StreamSQL(ctx context.Context, opts QueryOptions, w RowWriter) QueryResult {
+	e, err := startExecution(ctx, opts)
+	defer e.close()
 	if err != nil {
-		duration := time.Since(startTime)
-		return QueryResult{Error: err.Error(), Duration: formatDuration(duration)}
+		return e.fail(err, "")
+	}
+
+	rows, err := e.query()
+	if err != nil {
+		return e.fail(err, "")
 	}
 	defer rows.Close()
+	if opts.ExecTimeoutOnly {
+		e.timer.Stop()
+	}
 
 	columns, err := rows.Columns()
 	if err != nil {
-		return QueryResult{Error: err.Error()}
+		return e.fail(err, "")
+	}
+	types, err := columnTypesOf(rows)
+	if err != nil {
+		return e.fail(err, "")
+	}
+	if err := w.WriteHeader(e.queryID(), columns, types); err != nil {
+		return e.fail(err, "写出结果失败: ")
 	}
 
-	var result [][]interface{}
+	rowCount := 0
+	truncated, killed := false, false
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
+		if opts.MaxRows > 0 && rowCount >= opts.MaxRows {
+			// 达到行数限制后停止读取，剩余的行由 rows.Close 读完，执行连接保持可用；
+			// MySQL 端先通过 KILL QUERY 中断执行，避免关闭结果集时读取全部剩余的行
+			truncated = true
+			if e.running.pool != nil {
+				killed = e.running.kill(e.ctx) == nil
+			}
+			break
 		}
-
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return QueryResult{Error: err.Error()}
+			return e.fail(err, "")
 		}
 
 		row := make([]interface{}, len(columns))
 		for i, val := range values {
-			if b, ok := val.([]byte); ok {
-				row[i] = string(b)
-			} else {
-				row[i] = val
-			}
+			row[i] = decodeValue(types[i], val, opts.NumberMode)
 		}
-		result = append(result, row)
+		if err := w.WriteRow(row); err != nil {
+			return e.fail(err, "写出结果失败: ")
+		}
+		rowCount++
 	}
-	
+
+	// KILL QUERY 到达时服务端可能已经发送完结果，线程空闲时被标记的中断可能作用到该连接的下一条语句，
+	// 读完剩余的行没有收到中断错误时断开执行连接，不再归还连接池
+	if killed && !interrupted(rows.Close()) {
+		e.discard = true
+	}
+
 	// 检查遍历行时是否有错误
-	if err := rows.Err(); err != nil {
-		duration := time.Since(startTime)
-		return QueryResult{Error: "遍历结果集错误: " + err.Error(), Duration: formatDuration(duration)}
+	if !truncated {
+		if err := rows.Err(); err != nil {
+			return e.fail(err, "遍历结果集错误: ")
+		}
 	}
 
 	// 计算执行耗时
-	duration := time.Since(startTime)
-	rowCount := len(result)
+	duration := time.Since(e.startTime)
 	
-	log.Printf("查询执行完成: 耗时 %v, 返回 %d 行数据", duration, rowCount)
+	if truncated {
+		log.Printf("查询执行完成: 耗时 %v, 达到行数限制，返回前 %d 行数据", duration, rowCount)
+	} else {
+		log.Printf("查询执行完成: 耗时 %v, 返回 %d 行数据", duration, rowCount)
+	}
 
 	return QueryResult{
-		Columns:  columns, 
-		Rows:     result, 
-		Duration: formatDuration(duration),
-		RowCount: rowCount,
+		QueryID:   e.queryID(),
+		Duration:  formatDuration(duration),
+		RowCount:  rowCount,
+		Truncated: truncated,
+		Limit:     opts.MaxRows,
 	}
 }
 
+// interrupted 是否为 KILL QUERY 中断执行的错误（ER_QUERY_INTERRUPTED）
+func interrupted(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1317
+}
+
 // formatDuration 格式化时间显示
 func formatDuration(d time.Duration) string {
 	if d < time.Millisecond {
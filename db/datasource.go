@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bi-web/config"
+)
+
+// DataSource 已注册的数据源
+type DataSource struct {
+	Config config.DataSourceConfig
+	DB     *sql.DB
+}
+
+// DataSourceInfo 对外暴露的数据源信息（不含密码）
+type DataSourceInfo struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Database string `json:"database"`
+	ReadOnly bool   `json:"readOnly"`
+	Default  bool   `json:"default"`
+	File     bool   `json:"file,omitempty"` // 文件数据源
+}
+
+// ErrUnknownDataSource 请求的数据源未配置
+var ErrUnknownDataSource = errors.New("未知的数据源")
+
+var (
+	registryMu  sync.RWMutex
+	dataSources = make(map[string]*DataSource)
+	sourceOrder []string
+	defaultName string
+)
+
+// Connect 根据配置连接所有数据源
+func Connect(cfg *config.Config) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, dsCfg := range cfg.DataSources {
+		conn, err := openDataSource(dsCfg)
+		if err != nil {
+			return err
+		}
+
+		if old, ok := dataSources[dsCfg.Name]; ok && old.DB != nil {
+			old.DB.Close()
+		} else if !ok {
+			sourceOrder = append(sourceOrder, dsCfg.Name)
+		}
+		dataSources[dsCfg.Name] = &DataSource{Config: dsCfg, DB: conn}
+	}
+
+	if len(sourceOrder) > 0 {
+		defaultName = sourceOrder[0]
+	}
+	SetQueryTimeouts(cfg.QueryTimeout, cfg.MaxQueryTimeout)
+	SetMaxRows(cfg.MaxRows)
+
+	return nil
+}
+
+// openDataSource 打开单个数据源的连接池
+func openDataSource(dsCfg config.DataSourceConfig) (*sql.DB, error) {
+	conn, err := sql.Open("mysql", dsCfg.GetDSN())
+	if err != nil {
+		return nil, fmt.Errorf("数据源 %s 连接失败: %w", dsCfg.Name, err)
+	}
+
+	// 测试连接
+	if err = conn.Ping(); err != nil {
+		log.Printf("数据源 %s 连接测试失败: %v", dsCfg.Name, err)
+		log.Println("继续运行，但该数据源的功能可能不可用")
+		// 即使连接失败，也不返回错误，但确保连接池已初始化
+	}
+
+	// 设置连接池参数
+	conn.SetMaxOpenConns(25)
+	conn.SetMaxIdleConns(5)
+	conn.SetConnMaxLifetime(time.Hour)
+	conn.SetConnMaxIdleTime(30 * time.Minute)
+
+	return conn, nil
+}
+
+// GetDataSource 按名称获取数据源，名称为空时返回默认数据源
+func GetDataSource(name string) (*DataSource, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if name == "" {
+		name = defaultName
+	}
+	ds, ok := dataSources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDataSource, name)
+	}
+	return ds, nil
+}
+
+// GetDB 获取数据源的连接池并测试连接是否可用
+// 连接池由 database/sql 自行重建失效的连接，这里不关闭或替换共享的连接池
+func GetDB(ctx context.Context, name string) (*sql.DB, error) {
+	registryMu.RLock()
+	if name == "" {
+		name = defaultName
+	}
+	ds, ok := dataSources[name]
+	var pool *sql.DB
+	if ok {
+		pool = ds.DB
+	}
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDataSource, name)
+	}
+
+	// 测试连接是否有效
+	if err := pool.PingContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("数据源 %s 连接无效: %v", name, err)
+		return nil, fmt.Errorf("数据源 %s 连接失败: %w", name, err)
+	}
+
+	return pool, nil
+}
+
+// ListDataSources 按配置顺序列出所有数据源
+func ListDataSources() []DataSourceInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	infos := make([]DataSourceInfo, 0, len(sourceOrder))
+	for _, name := range sourceOrder {
+		ds := dataSources[name]
+		infos = append(infos, DataSourceInfo{
+			Name:     name,
+			Host:     ds.Config.Host,
+			Port:     ds.Config.Port,
+			Database: ds.Config.Database,
+			ReadOnly: ds.Config.ReadOnly,
+			Default:  name == defaultName,
+		})
+	}
+	return infos
+}
+
+// Close 关闭所有数据源连接
+func Close() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, ds := range dataSources {
+		if ds.DB != nil {
+			ds.DB.Close()
+		}
+	}
+}
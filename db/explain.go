@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"bi-web/sqlparse"
+)
+
+// PlanNode 归一化后的执行计划节点
+type PlanNode struct {
+	Operation    string      `json:"operation"` // query_block、table、nested_loop、ordering_operation 等
+	SelectID     int64       `json:"selectId,omitempty"`
+	Table        string      `json:"table,omitempty"`
+	AccessType   string      `json:"accessType,omitempty"` // ALL、index、range、ref、eq_ref、const 等
+	Key          string      `json:"key,omitempty"`
+	PossibleKeys []string    `json:"possibleKeys,omitempty"`
+	UsedKeyParts []string    `json:"usedKeyParts,omitempty"`
+	RowsExamined int64       `json:"rowsExamined,omitempty"` // 每次扫描读取的行数
+	RowsProduced int64       `json:"rowsProduced,omitempty"` // 连接后产生的行数
+	Filtered     float64     `json:"filtered,omitempty"`     // 条件过滤后保留的百分比
+	Cost         float64     `json:"cost,omitempty"`         // 累计成本
+	Condition    string      `json:"condition,omitempty"`
+	FullScan     bool        `json:"fullScan,omitempty"` // 全表扫描
+	Extra        []string    `json:"extra,omitempty"`    // using_filesort、using_temporary_table、using_index 等
+	Message      string      `json:"message,omitempty"`
+	Children     []*PlanNode `json:"children,omitempty"`
+}
+
+// ExplainResult 执行计划分析结果
+type ExplainResult struct {
+	QueryID      string          `json:"queryId,omitempty"`
+	Plan         *PlanNode       `json:"plan,omitempty"`
+	QueryCost    float64         `json:"queryCost,omitempty"`
+	FullScans    []string        `json:"fullScans,omitempty"` // 全表扫描的表
+	Warnings     []string        `json:"warnings,omitempty"`
+	Raw          json.RawMessage `json:"raw,omitempty"`     // EXPLAIN FORMAT=JSON 原始输出
+	Analyze      string          `json:"analyze,omitempty"` // EXPLAIN ANALYZE 输出
+	AnalyzeError string          `json:"analyzeError,omitempty"`
+	Duration     string          `json:"duration,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	ErrorCode    string          `json:"errorCode,omitempty"`
+}
+
+// 计划中包含子计划的字段，按固定顺序遍历保证输出稳定
+var planBlockKeys = []string{
+	"union_result", "ordering_operation", "grouping_operation", "duplicates_removal",
+	"windowing", "buffer_result", "query_block", "materialized_from_subquery",
+	"table", "nested_loop", "query_specifications",
+	"attached_subqueries", "optimized_away_subqueries", "select_list_subqueries",
+	"having_subqueries", "order_by_subqueries", "group_by_subqueries", "update_value_subqueries",
+}
+
+// 计划中表示额外操作的布尔标记
+var planFlagKeys = []string{"using_filesort", "using_temporary_table", "using_index", "using_join_buffer", "using_index_condition"}
+
+// Explain 使用 EXPLAIN FORMAT=JSON 分析查询
+// analyze 为 true 时额外执行 EXPLAIN ANALYZE（MySQL 8.0.18+），只允许只读语句，因为它会真正执行查询
+func Explain(ctx context.Context, opts QueryOptions, analyze bool) ExplainResult {
+	if opts.DataSource == FileDataSource {
+		return ExplainResult{Error: "文件数据源不支持执行计划分析"}
+	}
+	query := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(opts.Query), ";"))
+
+	explainOpts := opts
+	explainOpts.Query = "EXPLAIN FORMAT=JSON " + query
+	explainOpts.MaxRows = 1
+	result := ExecuteSQL(ctx, explainOpts)
+	if result.Error != "" {
+		return ExplainResult{QueryID: result.QueryID, Duration: result.Duration, Error: result.Error, ErrorCode: result.ErrorCode}
+	}
+	if len(result.Rows) == 0 || len(result.Rows[0]) == 0 {
+		return ExplainResult{QueryID: result.QueryID, Error: "EXPLAIN 没有返回结果"}
+	}
+
+	raw, err := rawJSON(result.Rows[0][0])
+	if err != nil {
+		return ExplainResult{QueryID: result.QueryID, Error: "解析执行计划失败: " + err.Error()}
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return ExplainResult{QueryID: result.QueryID, Error: "解析执行计划失败: " + err.Error()}
+	}
+
+	explain := ExplainResult{QueryID: result.QueryID, Raw: raw, Duration: result.Duration}
+	explain.Plan = parsePlanBlock("query", doc)
+	if qb, ok := doc["query_block"].(map[string]interface{}); ok {
+		if cost, ok := qb["cost_info"].(map[string]interface{}); ok {
+			explain.QueryCost = toFloat(cost["query_cost"])
+		}
+	}
+	collectPlanWarnings(explain.Plan, &explain)
+
+	if analyze {
+		explain.Analyze, explain.AnalyzeError = explainAnalyze(ctx, opts, query)
+	}
+
+	return explain
+}
+
+// explainAnalyze 执行 EXPLAIN ANALYZE 并返回树形文本
+func explainAnalyze(ctx context.Context, opts QueryOptions, query string) (string, string) {
+	if err := sqlparse.CheckReadOnly(query); err != nil {
+		return "", "EXPLAIN ANALYZE 会执行查询，只支持只读语句: " + err.Error()
+	}
+
+	analyzeOpts := opts
+	analyzeOpts.Query = "EXPLAIN ANALYZE " + query
+	analyzeOpts.MaxRows = 1
+	result := ExecuteSQL(ctx, analyzeOpts)
+	if result.Error != "" {
+		log.Printf("EXPLAIN ANALYZE 失败: %s", result.Error)
+		return "", result.Error
+	}
+	if len(result.Rows) == 0 || len(result.Rows[0]) == 0 {
+		return "", "EXPLAIN ANALYZE 没有返回结果"
+	}
+	return fmt.Sprint(result.Rows[0][0]), ""
+}
+
+// rawJSON 取出 EXPLAIN 返回的JSON文本，列类型可能是 JSON 或文本
+func rawJSON(v interface{}) (json.RawMessage, error) {
+	switch s := v.(type) {
+	case json.RawMessage:
+		return s, nil
+	case string:
+		if !json.Valid([]byte(s)) {
+			return nil, fmt.Errorf("不是有效的JSON")
+		}
+		return json.RawMessage(s), nil
+	default:
+		return nil, fmt.Errorf("未知的结果类型 %T", v)
+	}
+}
+
+// parsePlanBlock 递归解析计划中的一个块
+func parsePlanBlock(operation string, m map[string]interface{}) *PlanNode {
+	node := &PlanNode{Operation: operation}
+	if operation == "table" {
+		parsePlanTable(node, m)
+	}
+	if id, ok := m["select_id"]; ok {
+		node.SelectID = int64(toFloat(id))
+	}
+	if msg, ok := m["message"].(string); ok {
+		node.Message = msg
+	}
+	if cost, ok := m["cost_info"].(map[string]interface{}); ok && node.Cost == 0 {
+		node.Cost = toFloat(cost["query_cost"])
+	}
+	for _, flag := range planFlagKeys {
+		if b, ok := m[flag].(bool); ok && b {
+			node.Extra = append(node.Extra, flag)
+		}
+	}
+
+	for _, key := range planBlockKeys {
+		switch v := m[key].(type) {
+		case map[string]interface{}:
+			node.Children = append(node.Children, parsePlanBlock(key, v))
+		case []interface{}:
+			// 列表的元素是包了一层的块，如 nested_loop 中的 {"table": {...}}
+			group := &PlanNode{Operation: key}
+			for _, item := range v {
+				if child, ok := item.(map[string]interface{}); ok {
+					group.Children = append(group.Children, parsePlanBlock("", child).unwrap())
+				}
+			}
+			node.Children = append(node.Children, group)
+		}
+	}
+
+	if operation == "query" {
+		return node.unwrap()
+	}
+	return node
+}
+
+// unwrap 只起包装作用的节点（没有自身信息、只有一个子节点）直接返回子节点
+func (n *PlanNode) unwrap() *PlanNode {
+	if (n.Operation == "" || n.Operation == "query") && len(n.Children) == 1 && n.Message == "" && len(n.Extra) == 0 {
+		return n.Children[0]
+	}
+	return n
+}
+
+// parsePlanTable 解析表访问节点
+func parsePlanTable(node *PlanNode, m map[string]interface{}) {
+	node.Table, _ = m["table_name"].(string)
+	node.AccessType, _ = m["access_type"].(string)
+	node.Key, _ = m["key"].(string)
+	node.Condition, _ = m["attached_condition"].(string)
+	node.PossibleKeys = toStrings(m["possible_keys"])
+	node.UsedKeyParts = toStrings(m["used_key_parts"])
+	node.RowsExamined = int64(toFloat(m["rows_examined_per_scan"]))
+	node.RowsProduced = int64(toFloat(m["rows_produced_per_join"]))
+	node.Filtered = toFloat(m["filtered"])
+	node.FullScan = node.AccessType == "ALL"
+	if cost, ok := m["cost_info"].(map[string]interface{}); ok {
+		node.Cost = toFloat(cost["prefix_cost"])
+	}
+}
+
+// collectPlanWarnings 收集全表扫描、临时表、文件排序等需要关注的操作
+func collectPlanWarnings(node *PlanNode, result *ExplainResult) {
+	if node == nil {
+		return
+	}
+	if node.FullScan {
+		result.FullScans = append(result.FullScans, node.Table)
+		result.Warnings = append(result.Warnings, fmt.Sprintf("表 %s 全表扫描，每次扫描约 %d 行", node.Table, node.RowsExamined))
+	}
+	if node.AccessType == "index" {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("表 %s 全索引扫描（索引 %s）", node.Table, node.Key))
+	}
+	for _, extra := range node.Extra {
+		switch extra {
+		case "using_temporary_table":
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s 使用临时表", node.Operation))
+		case "using_filesort":
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s 使用文件排序", node.Operation))
+		}
+	}
+	for _, child := range node.Children {
+		collectPlanWarnings(child, result)
+	}
+}
+
+// toFloat 计划中的数值可能是数字也可能是字符串，如 "1.25"
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+func toStrings(v interface{}) []string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
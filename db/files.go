@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bi-web/config"
+	_ "modernc.org/sqlite"
+)
+
+// FileDataSource 文件数据源：上传的 CSV/XLSX 保存为当前会话专属的 SQLite 数据库中的表，
+// 查询时把数据源指定为该名称，并在 QueryOptions.Session 中传入会话标识
+const FileDataSource = config.FileDataSourceName
+
+// MaxFileTables 每个会话最多保存的文件表数量
+const MaxFileTables = 50
+
+// ErrFilesDisabled 文件数据源未启用
+var ErrFilesDisabled = errors.New("文件数据源未启用")
+
+// ErrNoFileSession 当前会话还没有上传过文件
+var ErrNoFileSession = errors.New("当前会话没有上传的文件")
+
+// FileTable 会话中由上传文件生成的表
+type FileTable struct {
+	Name      string         `json:"name"`
+	FileName  string         `json:"fileName"`        // 上传的文件名
+	Sheet     string         `json:"sheet,omitempty"` // XLSX 的工作表
+	Columns   []ImportColumn `json:"columns"`
+	Rows      int64          `json:"rows"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// fileSession 一个会话的 SQLite 数据库。写入和查询使用不同的连接池，
+// 查询连接池设置了 query_only，即使语句绕过了只读检查也无法修改数据
+type fileSession struct {
+	mu       sync.Mutex // 串行化建表和删表
+	path     string
+	db       *sql.DB               // 建表、删表
+	query    *sql.DB               // 执行查询
+	tables   map[string]*FileTable // 键为小写表名，SQLite 表名不区分大小写
+	lastUsed time.Time
+	active   int // 正在进行的建表、删表和查询数，不为0时不会因空闲超时被删除；由 fileMu 保护
+}
+
+var (
+	fileMu       sync.Mutex
+	fileSessions = make(map[string]*fileSession)
+	fileDir      string
+	fileTTL      time.Duration
+	fileStop     chan struct{}
+)
+
+// OpenFileSessions 启用文件数据源，dir 保存各会话的数据库文件，会话空闲超过 ttl 后删除
+// 会话只保存在内存中，目录中上次运行遗留的文件会被清除
+func OpenFileSessions(dir string, ttl time.Duration) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("创建文件数据源目录失败: %w", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.db*"))
+	for _, name := range stale {
+		os.Remove(name)
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	fileDir, fileTTL = dir, ttl
+	fileStop = make(chan struct{})
+
+	interval := ttl / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go expireFileSessions(interval, fileStop)
+	return nil
+}
+
+// FilesEnabled 文件数据源是否已启用
+func FilesEnabled() bool {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	return fileDir != ""
+}
+
+// CloseFileSessions 关闭并删除所有会话的数据库
+func CloseFileSessions() {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	if fileStop != nil {
+		close(fileStop)
+		fileStop = nil
+	}
+	for key, s := range fileSessions {
+		s.close()
+		delete(fileSessions, key)
+	}
+}
+
+// expireFileSessions 定期删除空闲超时的会话
+func expireFileSessions(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		fileMu.Lock()
+		for key, s := range fileSessions {
+			if s.active == 0 && time.Since(s.lastUsed) > fileTTL {
+				log.Printf("文件会话空闲超时，删除 %d 张表", len(s.tables))
+				s.close()
+				delete(fileSessions, key)
+			}
+		}
+		fileMu.Unlock()
+	}
+}
+
+// getFileSession 返回会话的数据库，create 为 true 时不存在则新建；调用方需持有 fileMu
+func getFileSession(session string, create bool) (*fileSession, error) {
+	if fileDir == "" {
+		return nil, ErrFilesDisabled
+	}
+	if session == "" {
+		return nil, ErrNoFileSession
+	}
+	if s, ok := fileSessions[session]; ok {
+		s.lastUsed = time.Now()
+		return s, nil
+	}
+	if !create {
+		return nil, ErrNoFileSession
+	}
+
+	// 文件名使用会话标识的摘要，不暴露会话标识本身
+	sum := sha256.Sum256([]byte(session))
+	path := filepath.Join(fileDir, hex.EncodeToString(sum[:16])+".db")
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(5000)&_pragma=synchronous(OFF)"
+	writer, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("创建会话数据库失败: %w", err)
+	}
+	reader, err := sql.Open("sqlite", dsn+"&_pragma=query_only(1)")
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("创建会话数据库失败: %w", err)
+	}
+	// SQLite 同一时间只允许一个写入者
+	writer.SetMaxOpenConns(1)
+	reader.SetMaxOpenConns(4)
+	reader.SetConnMaxIdleTime(5 * time.Minute)
+
+	s := &fileSession{path: path, db: writer, query: reader, tables: make(map[string]*FileTable), lastUsed: time.Now()}
+	fileSessions[session] = s
+	return s, nil
+}
+
+// acquireFileSession 返回会话的数据库并登记为使用中，用完后需调用 release
+func acquireFileSession(session string, create bool) (*fileSession, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	s, err := getFileSession(session, create)
+	if err != nil {
+		return nil, err
+	}
+	s.active++
+	return s, nil
+}
+
+// release 结束对会话的使用，空闲时间从此时开始计算
+func (s *fileSession) release() {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	s.active--
+	s.lastUsed = time.Now()
+}
+
+func (s *fileSession) close() {
+	s.query.Close()
+	s.db.Close()
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(s.path + suffix)
+	}
+}
+
+// FileColumnType 文件表中列的声明类型。驱动只对声明为 DATE/DATETIME 的列返回时间值，
+// 列类型归类也依赖声明类型，因此按值的归类统一列类型，DECIMAL 保留精度
+func FileColumnType(c ImportColumn) string {
+	switch c.Kind {
+	case KindInteger:
+		return "BIGINT"
+	case KindFloat:
+		return "DOUBLE"
+	case KindDecimal:
+		if strings.HasPrefix(strings.ToUpper(c.SQLType), "DECIMAL(") {
+			return strings.ToUpper(c.SQLType)
+		}
+		return "DECIMAL"
+	case KindBoolean:
+		return "BOOLEAN"
+	case KindDate:
+		return "DATE"
+	case KindDateTime:
+		return "DATETIME"
+	case KindTime:
+		return "TIME"
+	default:
+		return "TEXT"
+	}
+}
+
+// CreateFileTable 在会话数据库中建表并写入数据，replace 为 true 时替换同名的表
+// 建表和写入在同一个事务中，失败时不会留下不完整的表
+func CreateFileTable(ctx context.Context, session string, table FileTable, rows [][]interface{}, replace bool) (*FileTable, error) {
+	if !ValidIdentifier(table.Name) {
+		return nil, fmt.Errorf("表名不合法: %s", table.Name)
+	}
+	if len(table.Columns) == 0 {
+		return nil, errors.New("没有要导入的列")
+	}
+
+	s, err := acquireFileSession(session, true)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	// 写入可能耗时较长，只锁定当前会话
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(table.Name)
+	fileMu.Lock()
+	_, exists := s.tables[key]
+	count := len(s.tables)
+	fileMu.Unlock()
+	if exists && !replace {
+		return nil, fmt.Errorf("%w: %s", ErrTableExists, table.Name)
+	} else if !exists && count >= MaxFileTables {
+		return nil, fmt.Errorf("每个会话最多保存 %d 张文件表，请先删除不需要的表", MaxFileTables)
+	}
+
+	columns := make([]ImportColumn, len(table.Columns))
+	for i, c := range table.Columns {
+		c.SQLType = FileColumnType(c)
+		c.Nullable = true
+		columns[i] = c
+	}
+	table.Columns = columns
+
+	ctx, cancel := context.WithTimeout(ctx, maxQueryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		defs[i] = QuoteIdentifier(c.Name) + " " + c.SQLType
+	}
+	if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+QuoteIdentifier(table.Name)); err != nil {
+		return nil, fmt.Errorf("删除旧表失败: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", QuoteIdentifier(table.Name), strings.Join(defs, ", "))); err != nil {
+		return nil, fmt.Errorf("建表失败: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, InsertSQL(table.Name, columns, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return nil, fmt.Errorf("写入数据失败: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	table.Rows = int64(len(rows))
+	table.CreatedAt = time.Now()
+	fileMu.Lock()
+	s.tables[key] = &table
+	fileMu.Unlock()
+	log.Printf("文件数据源新建表 %s (%s): %d 行", table.Name, table.FileName, table.Rows)
+	return &table, nil
+}
+
+// ListFileTables 按表名列出会话中的文件表
+func ListFileTables(session string) []FileTable {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	s, err := getFileSession(session, false)
+	if err != nil {
+		return []FileTable{}
+	}
+	tables := make([]FileTable, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, *t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return strings.ToLower(tables[i].Name) < strings.ToLower(tables[j].Name) })
+	return tables
+}
+
+// DropFileTable 删除会话中的文件表
+func DropFileTable(ctx context.Context, session, name string) error {
+	s, err := acquireFileSession(session, false)
+	if errors.Is(err, ErrNoFileSession) {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	} else if err != nil {
+		return err
+	}
+	defer s.release()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(name)
+	fileMu.Lock()
+	table, ok := s.tables[key]
+	fileMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	if _, err := s.db.ExecContext(ctx, "DROP TABLE "+QuoteIdentifier(table.Name)); err != nil {
+		return fmt.Errorf("删除表失败: %w", err)
+	}
+
+	fileMu.Lock()
+	delete(s.tables, key)
+	fileMu.Unlock()
+	return nil
+}
+
+// FileSchema 以表结构的形式返回会话中的文件表，供编辑器补全使用
+func FileSchema(session string) *Schema {
+	schema := &Schema{DataSource: FileDataSource, Database: "main", Databases: []string{"main"}, Tables: []TableInfo{}, LoadedAt: time.Now()}
+	for _, t := range ListFileTables(session) {
+		info := TableInfo{Name: t.Name, Type: "BASE TABLE", Rows: t.Rows, Comment: t.FileName, Columns: make([]ColumnInfo, len(t.Columns))}
+		for i, c := range t.Columns {
+			columnType := strings.ToLower(c.SQLType)
+			dataType := columnType
+			if i := strings.IndexByte(dataType, '('); i >= 0 {
+				dataType = dataType[:i]
+			}
+			info.Columns[i] = ColumnInfo{Name: c.Name, DataType: dataType, Type: columnType, Nullable: true}
+		}
+		schema.Tables = append(schema.Tables, info)
+	}
+	return schema
+}
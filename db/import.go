@@ -0,0 +1,351 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 导入方式
+const (
+	ImportCreate = "create" // 新建表后导入，表已存在时报错
+	ImportAppend = "append" // 追加到已有的表
+)
+
+// DefaultImportBatchSize 每条 INSERT 语句包含的行数
+const DefaultImportBatchSize = 500
+
+// MaxImportErrors 最多返回的行错误数，超过的只计数
+const MaxImportErrors = 1000
+
+// maxPlaceholders MySQL 单条预处理语句最多的占位符数量
+const maxPlaceholders = 65535
+
+// ErrTableExists 新建表时目标表已存在
+var ErrTableExists = errors.New("目标表已存在")
+
+// ErrTableNotFound 追加数据时目标表不存在
+var ErrTableNotFound = errors.New("目标表不存在")
+
+// ImportColumn 导入目标表的一列
+type ImportColumn struct {
+	Name     string `json:"name"`    // 目标列名
+	Kind     string `json:"kind"`    // 值的转换方式，取值同 ColumnType.Kind
+	SQLType  string `json:"sqlType"` // 列的数据库类型，如 DECIMAL(10,2)
+	Nullable bool   `json:"nullable"`
+}
+
+// ImportPlan 一次导入的目标表和已转换的数据
+type ImportPlan struct {
+	DataSource  string
+	Table       string
+	Mode        string // create 或 append
+	Columns     []ImportColumn
+	Rows        [][]interface{}
+	Lines       []int // 每行在文件中的行号，用于报告错误
+	BatchSize   int
+	StopOnError bool // 有行写入失败时回滚全部数据；否则跳过失败的行
+}
+
+// ImportRowError 一行数据的错误
+type ImportRowError struct {
+	Line   int    `json:"line"`             // 文件中的行号
+	Column string `json:"column,omitempty"` // 值转换失败时的列名
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportStats 导入的执行结果
+type ImportStats struct {
+	Inserted  int64            `json:"inserted"`
+	Failed    int64            `json:"failed"` // 写入失败的行数
+	Errors    []ImportRowError `json:"errors,omitempty"`
+	Created   bool             `json:"created"`
+	Committed bool             `json:"committed"`
+}
+
+// 建表时允许的列类型，如 VARCHAR(255)、DECIMAL(10,2)、INT UNSIGNED
+var sqlTypePattern = regexp.MustCompile(`^[A-Za-z]+( ?\(\d+( ?, ?\d+)?\))?( UNSIGNED)?$`)
+
+// AddError 记录一行错误，超过 MaxImportErrors 后只计数
+func (s *ImportStats) AddError(e ImportRowError) {
+	s.Failed++
+	if len(s.Errors) < MaxImportErrors {
+		s.Errors = append(s.Errors, e)
+	}
+}
+
+// ValidIdentifier 检查表名或列名：1~64个字符，不含控制字符和反引号，不以空格结尾
+func ValidIdentifier(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > 64 || strings.TrimRight(name, " ") != name {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r == '`' || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// QuoteIdentifier 用反引号引用标识符
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// ValidSQLType 检查建表使用的列类型
+func ValidSQLType(sqlType string) bool {
+	return sqlTypePattern.MatchString(sqlType)
+}
+
+// SQLTypeKind 根据列类型（如 decimal(10,2)、int unsigned）返回值的归类
+func SQLTypeKind(sqlType string) string {
+	t := strings.ToUpper(strings.TrimSpace(sqlType))
+	unsigned := strings.Contains(t, "UNSIGNED")
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		t = t[:i]
+	}
+	switch t {
+	case "INTEGER":
+		t = "INT"
+	case "BOOL", "BOOLEAN":
+		return KindBoolean
+	case "NUMERIC", "DEC", "FIXED":
+		t = "DECIMAL"
+	case "REAL":
+		t = "DOUBLE"
+	}
+	if unsigned {
+		t = "UNSIGNED " + t
+	}
+	return columnKind(t)
+}
+
+// CreateTableSQL 生成导入使用的建表语句
+func CreateTableSQL(table string, columns []ImportColumn) string {
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		def := QuoteIdentifier(c.Name) + " " + c.SQLType
+		if !c.Nullable {
+			def += " NOT NULL"
+		}
+		defs[i] = "  " + def
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n) DEFAULT CHARSET=utf8mb4", QuoteIdentifier(table), strings.Join(defs, ",\n"))
+}
+
+// InsertSQL 生成插入 rows 行数据的 INSERT 语句
+func InsertSQL(table string, columns []ImportColumn, rows int) string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = QuoteIdentifier(c.Name)
+	}
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	values := strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", QuoteIdentifier(table), strings.Join(names, ", "), values)
+}
+
+// TableColumns 读取数据源默认数据库中一张表的列定义，表不存在时返回 ErrTableNotFound
+func TableColumns(ctx context.Context, dataSource, table string) ([]ColumnInfo, error) {
+	ds, err := GetDataSource(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := GetDB(ctx, ds.Config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, fmt.Errorf("读取表 %s 的列失败: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var c ColumnInfo
+		var nullable string
+		if err := rows.Scan(&c.Name, &c.DataType, &c.Type, &nullable, &c.Key, &c.Default, &c.Extra, &c.Comment); err != nil {
+			return nil, fmt.Errorf("读取表 %s 的列失败: %w", table, err)
+		}
+		c.Nullable = nullable == "YES"
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("读取表 %s 的列失败: %w", table, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	return columns, nil
+}
+
+// CheckWritable 只读数据源拒绝导入
+func CheckWritable(dataSource string) error {
+	ds, err := GetDataSource(dataSource)
+	if err != nil {
+		return err
+	}
+	if ds.Config.ReadOnly {
+		return &ReadOnlyError{Reason: fmt.Errorf("数据源 %s 为只读，不能导入数据", ds.Config.Name)}
+	}
+	return nil
+}
+
+// ImportRows 把数据写入目标表。create 方式先建表；所有 INSERT 在同一个事务中分批执行，
+// 批次失败时逐行重试以定位出错的行。建表后导入失败或回滚时删除新建的表
+func ImportRows(ctx context.Context, plan ImportPlan) (*ImportStats, error) {
+	if err := CheckWritable(plan.DataSource); err != nil {
+		return nil, err
+	}
+	ds, err := GetDataSource(plan.DataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, maxQueryTimeout)
+	defer cancel()
+
+	pool, err := GetDB(ctx, ds.Config.Name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stats := &ImportStats{}
+	start := time.Now()
+	if plan.Mode == ImportCreate {
+		// MySQL 的 DDL 会隐式提交，需在事务之外执行
+		if _, err := conn.ExecContext(ctx, CreateTableSQL(plan.Table, plan.Columns)); err != nil {
+			return nil, fmt.Errorf("建表失败: %w", err)
+		}
+		stats.Created = true
+		InvalidateSchema(ds.Config.Name)
+	}
+
+	err = insertRows(ctx, conn, plan, stats)
+	if err != nil || !stats.Committed {
+		stats.Inserted = 0
+		if stats.Created {
+			// 建表时使用的 ctx 可能已超时或被取消，删除表不受影响
+			if _, dropErr := conn.ExecContext(context.WithoutCancel(ctx), "DROP TABLE "+QuoteIdentifier(plan.Table)); dropErr != nil {
+				log.Printf("删除导入失败的表 %s 失败: %v", plan.Table, dropErr)
+			} else {
+				stats.Created = false
+			}
+		}
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	log.Printf("导入数据源 %s 表 %s: %d 行, %d 行失败, 耗时 %v", ds.Config.Name, plan.Table, stats.Inserted, stats.Failed, time.Since(start))
+	return stats, nil
+}
+
+// insertRows 在事务中分批插入，成功提交时设置 stats.Committed
+func insertRows(ctx context.Context, conn *sql.Conn, plan ImportPlan, stats *ImportStats) error {
+	batchSize := plan.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	if limit := maxPlaceholders / len(plan.Columns); batchSize > limit {
+		batchSize = limit
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stmt *sql.Stmt
+	defer func() {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}()
+
+	for start := 0; start < len(plan.Rows); start += batchSize {
+		end := start + batchSize
+		if end > len(plan.Rows) {
+			end = len(plan.Rows)
+		}
+		batch := plan.Rows[start:end]
+
+		// 完整批次复用同一条预处理语句
+		query := InsertSQL(plan.Table, plan.Columns, len(batch))
+		var args []interface{}
+		for _, row := range batch {
+			args = append(args, row...)
+		}
+
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT import_batch"); err != nil {
+			return err
+		}
+		var execErr error
+		if len(batch) == batchSize {
+			if stmt == nil {
+				if stmt, err = tx.PrepareContext(ctx, query); err != nil {
+					return err
+				}
+			}
+			_, execErr = stmt.ExecContext(ctx, args...)
+		} else {
+			_, execErr = tx.ExecContext(ctx, query, args...)
+		}
+		if execErr == nil {
+			stats.Inserted += int64(len(batch))
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// 批次失败，回到保存点后逐行插入，记录出错的行
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_batch"); err != nil {
+			return err
+		}
+		rowSQL := InsertSQL(plan.Table, plan.Columns, 1)
+		for i, row := range batch {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, rowSQL, row...); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+					return err
+				}
+				stats.AddError(ImportRowError{Line: plan.Lines[start+i], Error: err.Error()})
+				continue
+			}
+			stats.Inserted++
+		}
+		if plan.StopOnError && stats.Failed > 0 {
+			return nil
+		}
+	}
+
+	if plan.StopOnError && stats.Failed > 0 {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	stats.Committed = true
+	return nil
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-web/sqlparse"
+)
+
+// 参数类型
+const (
+	ParamString   = "string"
+	ParamInteger  = "integer"
+	ParamNumber   = "number"
+	ParamDecimal  = "decimal"
+	ParamBoolean  = "boolean"
+	ParamDate     = "date"
+	ParamDateTime = "datetime"
+)
+
+// ParamDef 命名参数声明
+type ParamDef struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type,omitempty"`     // 参数类型，默认为 string
+	Default  interface{} `json:"default,omitempty"`  // 未传值时使用的默认值
+	Multiple bool        `json:"multiple,omitempty"` // 是否为列表，用于 IN (:ids)，展开为多个占位符
+}
+
+// ParamError 参数校验失败
+type ParamError struct {
+	Name   string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	if e.Name == "" {
+		return "参数错误: " + e.Reason
+	}
+	return fmt.Sprintf("参数 %s 错误: %s", e.Name, e.Reason)
+}
+
+// bindParams 将SQL中的命名参数替换为驱动占位符，并按声明的类型转换参数值
+// 没有命名参数时原样返回SQL，不使用占位符
+func bindParams(query string, defs []ParamDef, values map[string]interface{}) (string, []interface{}, error) {
+	refs, err := sqlparse.FindParams(query)
+	if err != nil {
+		return "", nil, &ParamError{Reason: "SQL解析失败: " + err.Error()}
+	}
+	if len(refs) == 0 {
+		return query, nil, nil
+	}
+
+	declared := make(map[string]ParamDef, len(defs))
+	for _, def := range defs {
+		if !sqlparse.ValidParamName(def.Name) {
+			return "", nil, &ParamError{Name: def.Name, Reason: "参数名只能包含字母、数字和下划线"}
+		}
+		if def.Type == "" {
+			def.Type = ParamString
+		}
+		declared[def.Name] = def
+	}
+
+	// 每个参数只转换一次，同名参数多次出现时复用
+	bound := make(map[string][]interface{})
+	for _, ref := range refs {
+		if _, ok := bound[ref.Name]; ok {
+			continue
+		}
+		def, ok := declared[ref.Name]
+		if !ok {
+			def = ParamDef{Name: ref.Name, Type: ParamString}
+		}
+		args, err := resolveParam(def, values)
+		if err != nil {
+			return "", nil, err
+		}
+		bound[ref.Name] = args
+	}
+
+	var args []interface{}
+	rewritten := sqlparse.ReplaceParams(query, refs, func(ref sqlparse.ParamRef) string {
+		vals := bound[ref.Name]
+		args = append(args, vals...)
+		return strings.TrimSuffix(strings.Repeat("?, ", len(vals)), ", ")
+	})
+	return rewritten, args, nil
+}
+
+// resolveParam 取参数值（或默认值）并转换为驱动参数
+func resolveParam(def ParamDef, values map[string]interface{}) ([]interface{}, error) {
+	value, ok := values[def.Name]
+	if !ok || value == nil {
+		value = def.Default
+	}
+	if value == nil {
+		return nil, &ParamError{Name: def.Name, Reason: "缺少参数值"}
+	}
+
+	if !def.Multiple {
+		v, err := convertParam(def.Type, value)
+		if err != nil {
+			return nil, &ParamError{Name: def.Name, Reason: err.Error()}
+		}
+		return []interface{}{v}, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, &ParamError{Name: def.Name, Reason: "列表参数的值必须是数组"}
+	}
+	if len(list) == 0 {
+		return nil, &ParamError{Name: def.Name, Reason: "列表参数不能为空"}
+	}
+	args := make([]interface{}, len(list))
+	for i, item := range list {
+		v, err := convertParam(def.Type, item)
+		if err != nil {
+			return nil, &ParamError{Name: def.Name, Reason: fmt.Sprintf("第 %d 个值%s", i+1, err.Error())}
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// 日期时间参数接受的格式
+var dateTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// convertParam 按类型校验并转换单个参数值
+// 请求体以 UseNumber 解码，数字为 json.Number
+func convertParam(typ string, value interface{}) (interface{}, error) {
+	text := fmt.Sprint(value)
+
+	switch typ {
+	case ParamString:
+		switch value.(type) {
+		case string, json.Number, bool:
+			return text, nil
+		}
+		return nil, fmt.Errorf("不是字符串")
+
+	case ParamInteger:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || !isScalar(value) {
+			return nil, fmt.Errorf("不是整数: %v", value)
+		}
+		return n, nil
+
+	case ParamNumber:
+		f, ok := parseFinite(text)
+		if !ok || !isScalar(value) {
+			return nil, fmt.Errorf("不是数字: %v", value)
+		}
+		return f, nil
+
+	case ParamDecimal:
+		// 以字符串传给MySQL，保持精度
+		if _, ok := parseFinite(text); !ok || !isScalar(value) {
+			return nil, fmt.Errorf("不是数字: %v", value)
+		}
+		return text, nil
+
+	case ParamBoolean:
+		b, err := strconv.ParseBool(text)
+		if err != nil || !isScalar(value) {
+			return nil, fmt.Errorf("不是布尔值: %v", value)
+		}
+		return b, nil
+
+	case ParamDate:
+		t, err := time.ParseInLocation("2006-01-02", text, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("不是日期（格式 2006-01-02）: %v", value)
+		}
+		return t.Format("2006-01-02"), nil
+
+	case ParamDateTime:
+		for _, layout := range dateTimeLayouts {
+			if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+				return t.In(time.Local).Format("2006-01-02 15:04:05.999999"), nil
+			}
+		}
+		return nil, fmt.Errorf("不是日期时间（格式 2006-01-02 15:04:05）: %v", value)
+
+	default:
+		return nil, fmt.Errorf("不支持的参数类型 %s", typ)
+	}
+}
+
+// parseFinite 解析数字参数，拒绝 ParseFloat 接受的 NaN 和 Inf
+func parseFinite(text string) (float64, bool) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// isScalar 参数值是否为字符串、数字或布尔值
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, json.Number, bool, float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunningQuery 正在执行的查询
+type RunningQuery struct {
+	ID         string
+	DataSource string
+	ConnID     int64 // MySQL 连接ID，用于 KILL QUERY；文件数据源为0
+	Query      string
+	StartTime  time.Time
+
+	pool      *sql.DB // 执行 KILL QUERY 的连接池，为空时只取消上下文
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+
+	mu   sync.Mutex // 使 KILL QUERY 与释放执行连接互斥
+	done bool       // 执行连接已经或正在归还连接池，连接ID不再属于该查询
+}
+
+// Cancelled 查询是否已被主动取消
+func (q *RunningQuery) Cancelled() bool {
+	return q.cancelled.Load()
+}
+
+// ErrQueryNotFound 查询不存在或已经结束
+var ErrQueryNotFound = errors.New("查询不存在或已结束")
+
+var (
+	runningMu      sync.Mutex
+	runningQueries = make(map[string]*RunningQuery)
+)
+
+// 查询ID只允许字母、数字、下划线和短横线
+var queryIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NewQueryID 生成新的查询ID
+func NewQueryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("q%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidQueryID 检查查询ID格式是否合法
+func ValidQueryID(id string) bool {
+	return queryIDPattern.MatchString(id)
+}
+
+// registerQuery 登记正在执行的查询
+func registerQuery(q *RunningQuery) error {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	if _, exists := runningQueries[q.ID]; exists {
+		return fmt.Errorf("查询ID %s 已在执行中", q.ID)
+	}
+	runningQueries[q.ID] = q
+	return nil
+}
+
+// unregisterQuery 移除已结束的查询
+func unregisterQuery(id string) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	delete(runningQueries, id)
+}
+
+// CancelQuery 取消正在执行的查询
+// MySQL 数据源通过另一条连接执行 KILL QUERY 中断服务端的执行，执行连接保持可用；
+// 文件数据源的 SQLite 驱动在上下文取消时自行中断执行
+func CancelQuery(ctx context.Context, id string) error {
+	runningMu.Lock()
+	q, ok := runningQueries[id]
+	runningMu.Unlock()
+
+	if !ok {
+		return ErrQueryNotFound
+	}
+	if !q.cancelled.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	log.Printf("取消查询 %s (数据源 %s, 连接ID %d)", q.ID, q.DataSource, q.ConnID)
+	if err := q.kill(ctx); err != nil {
+		return fmt.Errorf("终止查询失败: %w", err)
+	}
+	return nil
+}
+
+// kill 中断查询的执行：有连接池时执行 KILL QUERY，失败或没有连接池时取消查询上下文。
+// 持有 q.mu 执行，查询结束后不再执行，避免连接归还连接池后中断其他请求的语句
+func (q *RunningQuery) kill(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.done {
+		return nil
+	}
+	if q.pool == nil {
+		q.cancel()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// KILL 语句不支持占位符，连接ID为整数，直接拼接
+	if _, err := q.pool.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", q.ConnID)); err != nil {
+		log.Printf("KILL QUERY %d 失败: %v", q.ConnID, err)
+		q.cancel()
+		return err
+	}
+	return nil
+}
+
+// finish 标记查询已结束，等待进行中的 KILL QUERY 完成后返回，之后才能释放执行连接
+func (q *RunningQuery) finish() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.done = true
+}
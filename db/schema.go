@@ -0,0 +1,302 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 表结构缓存有效期，过期后下次访问时重新读取
+const schemaCacheTTL = 10 * time.Minute
+
+// Schema 数据源中一个数据库的结构信息
+type Schema struct {
+	DataSource string      `json:"datasource"`
+	Database   string      `json:"database"`
+	Databases  []string    `json:"databases"` // 当前账号可见的所有数据库
+	Tables     []TableInfo `json:"tables"`
+	LoadedAt   time.Time   `json:"loadedAt"`
+}
+
+// TableInfo 表或视图的结构
+type TableInfo struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"` // BASE TABLE 或 VIEW
+	Engine      string           `json:"engine,omitempty"`
+	Rows        int64            `json:"rows"` // 估算行数
+	Comment     string           `json:"comment,omitempty"`
+	Columns     []ColumnInfo     `json:"columns"`
+	Indexes     []IndexInfo      `json:"indexes,omitempty"`
+	ForeignKeys []ForeignKeyInfo `json:"foreignKeys,omitempty"`
+}
+
+// ColumnInfo 列定义
+type ColumnInfo struct {
+	Name     string  `json:"name"`
+	DataType string  `json:"dataType"`   // 基础类型，如 varchar
+	Type     string  `json:"columnType"` // 完整类型，如 varchar(255)
+	Nullable bool    `json:"nullable"`
+	Key      string  `json:"key,omitempty"` // PRI、UNI、MUL
+	Default  *string `json:"default,omitempty"`
+	Extra    string  `json:"extra,omitempty"`
+	Comment  string  `json:"comment,omitempty"`
+}
+
+// IndexInfo 索引定义
+type IndexInfo struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+	Type    string   `json:"type"` // BTREE、FULLTEXT 等
+	Columns []string `json:"columns"`
+}
+
+// ForeignKeyInfo 外键定义
+type ForeignKeyInfo struct {
+	Name              string   `json:"name"`
+	Columns           []string `json:"columns"`
+	ReferencedSchema  string   `json:"referencedSchema"`
+	ReferencedTable   string   `json:"referencedTable"`
+	ReferencedColumns []string `json:"referencedColumns"`
+}
+
+var (
+	schemaMu    sync.Mutex
+	schemaCache = make(map[string]*Schema)
+)
+
+// GetSchema 获取数据库结构，优先使用缓存
+// database 为空时使用数据源配置的数据库
+func GetSchema(ctx context.Context, dataSource, database string, refresh bool) (*Schema, error) {
+	ds, err := GetDataSource(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if database == "" {
+		database = ds.Config.Database
+	}
+
+	key := ds.Config.Name + "/" + database
+	schemaMu.Lock()
+	cached, ok := schemaCache[key]
+	schemaMu.Unlock()
+	if ok && !refresh && time.Since(cached.LoadedAt) < schemaCacheTTL {
+		return cached, nil
+	}
+
+	schema, err := loadSchema(ctx, ds.Config.Name, database)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaMu.Lock()
+	schemaCache[key] = schema
+	schemaMu.Unlock()
+
+	return schema, nil
+}
+
+// InvalidateSchema 清除数据源所有数据库的结构缓存，建表后调用
+func InvalidateSchema(dataSource string) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	for key := range schemaCache {
+		if strings.HasPrefix(key, dataSource+"/") {
+			delete(schemaCache, key)
+		}
+	}
+}
+
+// loadSchema 从 information_schema 读取数据库结构
+func loadSchema(ctx context.Context, dataSource, database string) (*Schema, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, effectiveTimeout(0))
+	defer cancel()
+
+	conn, err := GetDB(ctx, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{DataSource: dataSource, Database: database}
+
+	if schema.Databases, err = loadDatabases(ctx, conn); err != nil {
+		return nil, fmt.Errorf("读取数据库列表失败: %w", err)
+	}
+
+	tables, order, err := loadTables(ctx, conn, database)
+	if err != nil {
+		return nil, fmt.Errorf("读取表信息失败: %w", err)
+	}
+	if err := loadColumns(ctx, conn, database, tables); err != nil {
+		return nil, fmt.Errorf("读取列信息失败: %w", err)
+	}
+	if err := loadIndexes(ctx, conn, database, tables); err != nil {
+		return nil, fmt.Errorf("读取索引信息失败: %w", err)
+	}
+	if err := loadForeignKeys(ctx, conn, database, tables); err != nil {
+		return nil, fmt.Errorf("读取外键信息失败: %w", err)
+	}
+
+	schema.Tables = make([]TableInfo, 0, len(order))
+	for _, name := range order {
+		schema.Tables = append(schema.Tables, *tables[name])
+	}
+	schema.LoadedAt = time.Now()
+
+	log.Printf("读取数据源 %s 数据库 %s 的结构: %d 张表, 耗时 %v", dataSource, database, len(schema.Tables), time.Since(start))
+	return schema, nil
+}
+
+func loadDatabases(ctx context.Context, conn *sql.DB) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA ORDER BY SCHEMA_NAME")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		databases = append(databases, name)
+	}
+	return databases, rows.Err()
+}
+
+func loadTables(ctx context.Context, conn *sql.DB, database string) (map[string]*TableInfo, []string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT TABLE_NAME, TABLE_TYPE, ENGINE, TABLE_ROWS, TABLE_COMMENT
+		FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME`, database)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	tables := make(map[string]*TableInfo)
+	var order []string
+	for rows.Next() {
+		var t TableInfo
+		var engine, comment sql.NullString
+		var tableRows sql.NullInt64
+		if err := rows.Scan(&t.Name, &t.Type, &engine, &tableRows, &comment); err != nil {
+			return nil, nil, err
+		}
+		t.Engine = engine.String
+		t.Rows = tableRows.Int64
+		t.Comment = comment.String
+		t.Columns = []ColumnInfo{}
+		tables[t.Name] = &t
+		order = append(order, t.Name)
+	}
+	return tables, order, rows.Err()
+}
+
+func loadColumns(ctx context.Context, conn *sql.DB, database string, tables map[string]*TableInfo) error {
+	rows, err := conn.QueryContext(ctx, `SELECT TABLE_NAME, COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE,
+		COLUMN_KEY, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME, ORDINAL_POSITION`, database)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, nullable string
+		var c ColumnInfo
+		var key, def, extra, comment sql.NullString
+		if err := rows.Scan(&table, &c.Name, &c.DataType, &c.Type, &nullable, &key, &def, &extra, &comment); err != nil {
+			return err
+		}
+		c.Nullable = nullable == "YES"
+		c.Key = key.String
+		c.Extra = extra.String
+		c.Comment = comment.String
+		if def.Valid {
+			c.Default = &def.String
+		}
+		if t, ok := tables[table]; ok {
+			t.Columns = append(t.Columns, c)
+		}
+	}
+	return rows.Err()
+}
+
+func loadIndexes(ctx context.Context, conn *sql.DB, database string, tables map[string]*TableInfo) error {
+	rows, err := conn.QueryContext(ctx, `SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, INDEX_TYPE, COLUMN_NAME
+		FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`, database)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, name, indexType string
+		var nonUnique int
+		var column sql.NullString // 函数索引没有列名
+		if err := rows.Scan(&table, &name, &nonUnique, &indexType, &column); err != nil {
+			return err
+		}
+		t, ok := tables[table]
+		if !ok {
+			continue
+		}
+		if n := len(t.Indexes); n == 0 || t.Indexes[n-1].Name != name {
+			t.Indexes = append(t.Indexes, IndexInfo{Name: name, Unique: nonUnique == 0, Type: indexType})
+		}
+		if column.Valid {
+			idx := &t.Indexes[len(t.Indexes)-1]
+			idx.Columns = append(idx.Columns, column.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// 主键排在最前面
+	for _, t := range tables {
+		sort.SliceStable(t.Indexes, func(i, j int) bool {
+			return t.Indexes[i].Name == "PRIMARY" && t.Indexes[j].Name != "PRIMARY"
+		})
+	}
+	return nil
+}
+
+func loadForeignKeys(ctx context.Context, conn *sql.DB, database string, tables map[string]*TableInfo) error {
+	rows, err := conn.QueryContext(ctx, `SELECT TABLE_NAME, CONSTRAINT_NAME, COLUMN_NAME,
+		REFERENCED_TABLE_SCHEMA, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = ? AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY TABLE_NAME, CONSTRAINT_NAME, ORDINAL_POSITION`, database)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, name, column, refSchema, refTable, refColumn string
+		if err := rows.Scan(&table, &name, &column, &refSchema, &refTable, &refColumn); err != nil {
+			return err
+		}
+		t, ok := tables[table]
+		if !ok {
+			continue
+		}
+		if n := len(t.ForeignKeys); n == 0 || t.ForeignKeys[n-1].Name != name {
+			t.ForeignKeys = append(t.ForeignKeys, ForeignKeyInfo{
+				Name:             name,
+				ReferencedSchema: refSchema,
+				ReferencedTable:  refTable,
+			})
+		}
+		fk := &t.ForeignKeys[len(t.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, column)
+		fk.ReferencedColumns = append(fk.ReferencedColumns, refColumn)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType 结果列的类型信息
+type ColumnType struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"databaseType"`        // 数据库类型名，如 VARCHAR、DECIMAL
+	ScanType     string `json:"scanType,omitempty"`  // 驱动对应的Go类型
+	Kind         string `json:"kind"`                // 归类后的类型：integer/float/decimal/boolean/date/datetime/time/json/binary/string
+	Nullable     *bool  `json:"nullable,omitempty"`  // 是否可为空，驱动不支持时省略
+	Precision    *int64 `json:"precision,omitempty"` // DECIMAL 精度
+	Scale        *int64 `json:"scale,omitempty"`     // DECIMAL 小数位数
+	Length       *int64 `json:"length,omitempty"`    // 变长类型的长度
+}
+
+// 列类型归类
+const (
+	KindInteger  = "integer"
+	KindFloat    = "float"
+	KindDecimal  = "decimal"
+	KindBoolean  = "boolean"
+	KindDate     = "date"
+	KindDateTime = "datetime"
+	KindTime     = "time"
+	KindJSON     = "json"
+	KindBinary   = "binary"
+	KindString   = "string"
+)
+
+// NumberMode 精确数值（DECIMAL、BIGINT）的JSON编码方式
+type NumberMode string
+
+const (
+	// NumberAuto 默认：DECIMAL 输出为字符串，超出 JavaScript 安全整数范围的整数输出为字符串
+	NumberAuto NumberMode = "auto"
+	// NumberFloat 全部输出为JSON数字，前端解析时可能丢失精度
+	NumberFloat NumberMode = "float"
+	// NumberString DECIMAL 和所有整数都输出为字符串
+	NumberString NumberMode = "string"
+	// NumberTagged DECIMAL 和超出安全范围的整数输出为带类型标记的对象
+	NumberTagged NumberMode = "tagged"
+)
+
+// ParseNumberMode 解析数值编码方式，空字符串表示默认方式
+func ParseNumberMode(s string) (NumberMode, error) {
+	switch mode := NumberMode(strings.ToLower(s)); mode {
+	case "":
+		return NumberAuto, nil
+	case NumberAuto, NumberFloat, NumberString, NumberTagged:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("不支持的数值编码方式: %s", s)
+	}
+}
+
+// TaggedNumber tagged 模式下的精确数值，如 {"$type":"decimal","value":"12.30"}
+type TaggedNumber struct {
+	Type  string `json:"$type"` // decimal 或 bigint
+	Value string `json:"value"`
+}
+
+// JavaScript 能精确表示的最大整数 2^53-1
+const maxSafeInteger = 1<<53 - 1
+
+// columnKind 根据数据库类型名归类
+func columnKind(dbType string) string {
+	switch strings.TrimPrefix(dbType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return KindInteger
+	case "FLOAT", "DOUBLE":
+		return KindFloat
+	case "DECIMAL":
+		return KindDecimal
+	case "BIT", "BOOLEAN":
+		return KindBoolean
+	case "DATE":
+		return KindDate
+	case "DATETIME", "TIMESTAMP":
+		return KindDateTime
+	case "TIME":
+		return KindTime
+	case "JSON":
+		return KindJSON
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "GEOMETRY":
+		return KindBinary
+	default:
+		return KindString
+	}
+}
+
+// columnTypesOf 读取结果集的列类型信息
+func columnTypesOf(rows *sql.Rows) ([]ColumnType, error) {
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]ColumnType, len(cts))
+	for i, ct := range cts {
+		dbType, precision, scale, sized := splitTypeName(ct.DatabaseTypeName())
+		t := ColumnType{
+			Name:         ct.Name(),
+			DatabaseType: dbType,
+			Kind:         columnKind(dbType),
+		}
+		if st := ct.ScanType(); st != nil {
+			t.ScanType = st.String()
+			// SQLite 的表达式列没有声明类型，按驱动返回的Go类型归类
+			if dbType == "" {
+				t.Kind = scanTypeKind(t.ScanType)
+			}
+		}
+		if nullable, ok := ct.Nullable(); ok {
+			t.Nullable = &nullable
+		}
+		if p, s, ok := ct.DecimalSize(); ok && t.Kind == KindDecimal {
+			t.Precision = &p
+			t.Scale = &s
+		} else if sized && t.Kind == KindDecimal {
+			t.Precision = &precision
+			t.Scale = &scale
+		}
+		// SQLite 的 TEXT/BLOB 没有长度上限，驱动返回 math.MaxInt64
+		if length, ok := ct.Length(); ok && length != math.MaxInt64 {
+			t.Length = &length
+		}
+		types[i] = t
+	}
+	return types, nil
+}
+
+// splitTypeName 规范化驱动返回的类型名。MySQL 驱动返回 DECIMAL、UNSIGNED INT 之类的名称；
+// SQLite 返回建表时声明的类型，如 DECIMAL(10,2)，括号中的精度单独返回
+func splitTypeName(name string) (string, int64, int64, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	i := strings.IndexByte(name, '(')
+	if i < 0 {
+		return name, 0, 0, false
+	}
+	var precision, scale int64
+	_, err := fmt.Sscanf(name[i:], "(%d,%d)", &precision, &scale)
+	if err != nil {
+		_, err = fmt.Sscanf(name[i:], "(%d)", &precision)
+	}
+	rest := ""
+	if j := strings.IndexByte(name[i:], ')'); j >= 0 {
+		rest = name[i+j+1:]
+	}
+	name = strings.TrimSpace(name[:i] + rest)
+	if strings.HasSuffix(name, " UNSIGNED") {
+		name = "UNSIGNED " + strings.TrimSuffix(name, " UNSIGNED")
+	}
+	return name, precision, scale, err == nil
+}
+
+// scanTypeKind 根据驱动返回的Go类型归类
+func scanTypeKind(scanType string) string {
+	switch scanType {
+	case "int64":
+		return KindInteger
+	case "float64":
+		return KindFloat
+	case "bool":
+		return KindBoolean
+	case "time.Time":
+		return KindDateTime
+	case "[]uint8":
+		return KindBinary
+	default:
+		return KindString
+	}
+}
+
+// decodeValue 根据列类型将驱动返回的值转换为适合JSON输出的值
+// 文本协议下所有值都是 []byte，预处理语句下整数和浮点数已是原生类型
+func decodeValue(t ColumnType, val interface{}, mode NumberMode) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return formatTime(t.Kind, v)
+	case []byte:
+		return decodeText(t, v, mode)
+	case int64:
+		// SQLite 的 BOOLEAN 存储为整数，DECIMAL 中的整数值存储为整数
+		switch t.Kind {
+		case KindBoolean:
+			return v != 0
+		case KindDecimal:
+			return decodeValue(t, float64(v), mode)
+		}
+		return encodeInt(v, mode)
+	case float64:
+		// SQLite 的 DECIMAL 按浮点数存储，按声明的小数位数输出，与 MySQL 一致
+		if t.Kind == KindDecimal {
+			prec := -1
+			if t.Scale != nil {
+				prec = int(*t.Scale)
+			}
+			return encodeDecimal(strconv.FormatFloat(v, 'f', prec, 64), mode)
+		}
+		return v
+	case uint64:
+		if v > maxSafeInteger {
+			return encodeBigInt(strconv.FormatUint(v, 10), mode)
+		}
+		return encodeInt(int64(v), mode)
+	default:
+		return v
+	}
+}
+
+// decodeText 转换文本形式的值，无法解析时原样返回字符串
+func decodeText(t ColumnType, b []byte, mode NumberMode) interface{} {
+	s := string(b)
+
+	switch t.Kind {
+	case KindInteger:
+		if strings.HasPrefix(t.DatabaseType, "UNSIGNED ") {
+			if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return decodeValue(t, n, mode)
+			}
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return encodeInt(n, mode)
+		}
+	case KindFloat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case KindDecimal:
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return encodeDecimal(s, mode)
+		}
+	case KindBoolean:
+		// 驱动不提供 BIT 的位宽，单字节的 0/1 按 BIT(1) 布尔值处理，其余按大端无符号整数处理
+		if len(b) == 1 && (b[0] == 0 || b[0] == 1) {
+			return b[0] == 1
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	case KindJSON:
+		if json.Valid(b) {
+			return json.RawMessage(s)
+		}
+	}
+
+	return s
+}
+
+// encodeInt 按编码方式输出整数
+func encodeInt(n int64, mode NumberMode) interface{} {
+	if mode == NumberString {
+		return strconv.FormatInt(n, 10)
+	}
+	if n > maxSafeInteger || n < -maxSafeInteger {
+		return encodeBigInt(strconv.FormatInt(n, 10), mode)
+	}
+	return n
+}
+
+// encodeBigInt 输出超出安全范围的整数
+func encodeBigInt(s string, mode NumberMode) interface{} {
+	switch mode {
+	case NumberFloat:
+		return json.Number(s)
+	case NumberTagged:
+		return TaggedNumber{Type: "bigint", Value: s}
+	default:
+		return s
+	}
+}
+
+// encodeDecimal 按编码方式输出 DECIMAL，除 float 模式外都保留原始精度
+func encodeDecimal(s string, mode NumberMode) interface{} {
+	switch mode {
+	case NumberFloat:
+		return json.Number(s)
+	case NumberTagged:
+		return TaggedNumber{Type: "decimal", Value: s}
+	default:
+		return s
+	}
+}
+
+// formatTime 按列类型格式化时间：DATE 输出日期，其余输出 RFC3339
+func formatTime(kind string, t time.Time) string {
+	if kind == KindDate {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339Nano)
+}
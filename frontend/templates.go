@@ -60,12 +60,42 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
         <!-- 标签页导航 -->
         <div class="tabs" id="query-tabs">
             <div class="tab active" data-tab="1" onclick="switchTab(1)">
-                <i class="fas fa-database"></i> 查询 1
+                <i class="fas fa-database"></i> <span class="tab-title">查询 1</span>
                 <span class="close-tab" onclick="removeQuery(1, event)"><i class="fas fa-times"></i></span>
             </div>
             <button class="new-tab" onclick="addQuery()">
                 <i class="fas fa-plus"></i> 新查询
             </button>
+            <button class="new-tab saved-queries-btn" onclick="toggleSavedQueries()">
+                <i class="fas fa-folder-open"></i> 已保存查询
+            </button>
+            <button class="new-tab saved-queries-btn" onclick="toggleHistory()">
+                <i class="fas fa-history"></i> 执行历史
+            </button>
+        </div>
+        
+        <!-- 已保存查询 -->
+        <div class="saved-queries-panel" id="saved-queries-panel" style="display:none;">
+            <div class="saved-queries-toolbar">
+                <input type="text" id="saved-queries-search" placeholder="搜索标题、描述或SQL" onkeydown="if (event.key === 'Enter') loadSavedQueries()">
+                <button onclick="loadSavedQueries()"><i class="fas fa-search"></i> 搜索</button>
+            </div>
+            <div id="saved-queries-list"></div>
+        </div>
+        
+        <!-- 执行历史 -->
+        <div class="saved-queries-panel" id="history-panel" style="display:none;">
+            <div class="saved-queries-toolbar">
+                <input type="text" id="history-search" placeholder="搜索SQL" onkeydown="if (event.key === 'Enter') loadHistory(1)">
+                <input type="text" id="history-user" placeholder="用户" onkeydown="if (event.key === 'Enter') loadHistory(1)">
+                <select id="history-status" onchange="loadHistory(1)">
+                    <option value="">全部</option>
+                    <option value="success">成功</option>
+                    <option value="error">失败</option>
+                </select>
+                <button onclick="loadHistory(1)"><i class="fas fa-search"></i> 搜索</button>
+            </div>
+            <div id="history-list"></div>
         </div>
         
         <!-- 查询容器 -->
@@ -73,15 +103,30 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
             <div class="query-container active" id="query-1">
                 <div class="sql-editor-container" id="sql-editor-1"></div>
                 <div class="query-actions">
+                    <select class="datasource-select" id="datasource-1" title="数据源"></select>
                     <button class="execute-btn" onclick="executeQuery(1)">
                         <i class="fas fa-play"></i> 执行查询
                     </button>
                     <button class="save-btn" onclick="saveQuery(1)">
                         <i class="fas fa-save"></i> 保存
                     </button>
+                    <button class="explain-btn" onclick="explainQuery(1)">
+                        <i class="fas fa-sitemap"></i> 执行计划
+                    </button>
+                    <select class="export-select" onchange="exportQuery(1, this)" title="导出全部结果">
+                        <option value="">导出...</option>
+                        <option value="xlsx">Excel (XLSX)</option>
+                        <option value="csv-bom">CSV (Excel)</option>
+                        <option value="csv">CSV</option>
+                        <option value="tsv">TSV</option>
+                        <option value="jsonl">JSON Lines</option>
+                        <option value="parquet">Parquet</option>
+                        <option value="arrow">Arrow IPC</option>
+                    </select>
                 </div>
                 <div class="error"></div>
                 <div class="visual-controls" style="display:none;"></div>
+                <div class="explain-result" style="display:none;"></div>
                 <div class="result"></div>
             </div>
         </div>
@@ -206,6 +251,47 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
                 </div>
                 <table id="preview-table" class="preview-table"></table>
             </div>
+            
+            <!-- 导入到数据表 -->
+            <div class="table-import">
+                <div class="preview-title">
+                    <i class="fas fa-database"></i> 导入到数据表
+                </div>
+                <div class="table-import-form">
+                    <select class="datasource-select" id="table-import-datasource" title="数据源"></select>
+                    <input type="text" id="table-import-table" placeholder="目标表名">
+                    <select id="table-import-mode" title="导入方式">
+                        <option value="create">新建表</option>
+                        <option value="append">追加到已有表</option>
+                    </select>
+                    <label><input type="checkbox" id="table-import-stop"> 有错误时不写入</label>
+                    <button id="table-import-check-btn" class="excel-btn secondary" disabled>
+                        <i class="fas fa-check"></i>
+                        <span>校验</span>
+                    </button>
+                    <button id="table-import-btn" class="excel-btn" disabled>
+                        <i class="fas fa-file-import"></i>
+                        <span>导入</span>
+                    </button>
+                </div>
+                <div id="table-import-result" class="table-import-result"></div>
+            </div>
+
+            <!-- 文件数据源 -->
+            <div class="table-import file-tables">
+                <div class="preview-title">
+                    <i class="fas fa-file-csv"></i> 文件数据源（当前会话）
+                </div>
+                <div class="table-import-form">
+                    <input type="text" id="file-table-name" placeholder="表名（默认取文件名）">
+                    <label><input type="checkbox" id="file-table-replace"> 替换同名的表</label>
+                    <button id="file-table-upload-btn" class="excel-btn" disabled>
+                        <i class="fas fa-upload"></i>
+                        <span>上传为文件表</span>
+                    </button>
+                </div>
+                <div id="file-table-list" class="file-table-list"></div>
+            </div>
         </div>
         
         <footer style="text-align: center; margin-top: 50px; color: #7f8c8d; font-size: 14px;">
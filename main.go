@@ -14,6 +14,7 @@ import (
 	"bi-web/db"
 	"bi-web/frontend"
 	"bi-web/middleware"
+	"bi-web/store"
 	"bi-web/utils"
 )
 
@@ -31,13 +32,44 @@ func main() {
 	}
 	defer db.Close()
 
+	// 打开元数据库
+	if err := store.Open(cfg.StorePath); err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	// 设置可信代理
+	if err := api.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+
+	// 启用文件数据源
+	if err := db.OpenFileSessions(cfg.FileDir, cfg.FileSessionTTL); err != nil {
+		log.Fatal(err)
+	}
+	defer db.CloseFileSessions()
+
 	// 创建路由
 	mux := http.NewServeMux()
 	
 	// 注册路由
 	mux.HandleFunc("/", frontend.IndexHandler)
 	mux.HandleFunc("/api/query", api.QueryHandler)
+	mux.HandleFunc("/api/query/", api.CancelQueryHandler)
 	mux.HandleFunc("/api/merge", api.MergeHandler)
+	mux.HandleFunc("/api/transform", api.TransformHandler)
+	mux.HandleFunc("/api/explain", api.ExplainHandler)
+	mux.HandleFunc("/api/export", api.ExportHandler)
+	mux.HandleFunc("/api/import", api.ImportHandler)
+	mux.HandleFunc("/api/files", api.FilesHandler)
+	mux.HandleFunc("/api/files/", api.FileHandler)
+	mux.HandleFunc("/api/datasources", api.DataSourcesHandler)
+	mux.HandleFunc("/api/schema", api.SchemaHandler)
+	mux.HandleFunc("/api/schema/refresh", api.SchemaRefreshHandler)
+	mux.HandleFunc("/api/saved-queries", api.SavedQueriesHandler)
+	mux.HandleFunc("/api/saved-queries/", api.SavedQueryHandler)
+	mux.HandleFunc("/api/history", api.HistoryHandler)
+	mux.HandleFunc("/api/history/", api.HistoryItemHandler)
 	
 	// 静态文件服务
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -3,6 +3,7 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -30,7 +31,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 				log.Printf("发生panic: %v", err)
 				
 				// 检查是否是API请求
-				if r.URL.Path == "/api/query" || r.URL.Path == "/api/merge" {
+				if strings.HasPrefix(r.URL.Path, "/api/") {
 					// 对于API请求，返回JSON格式的错误
 					w.Header().Set("Content-Type", "application/json")
 					w.WriteHeader(http.StatusInternalServerError)
@@ -57,4 +58,11 @@ type statusCapturingResponseWriter struct {
 func (crw *statusCapturingResponseWriter) WriteHeader(code int) {
 	crw.statusCode = code
 	crw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush 实现http.Flusher接口，保证流式响应可以及时刷新
+func (crw *statusCapturingResponseWriter) Flush() {
+	if f, ok := crw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
\ No newline at end of file
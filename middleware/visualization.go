@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -10,8 +11,14 @@ import (
 // VisualizationMiddleware 数据可视化中间件
 func VisualizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 只处理API查询和合并请求
-		if (!strings.HasPrefix(r.URL.Path, "/api/query") && !strings.HasPrefix(r.URL.Path, "/api/merge")) || r.Method != "POST" {
+		// 只处理API查询、合并和变换请求
+		if (r.URL.Path != "/api/query" && r.URL.Path != "/api/merge" && r.URL.Path != "/api/transform") || r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 流式结果无法整体缓冲后再处理，直接透传
+		if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -34,25 +41,23 @@ func VisualizationMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// 解析查询结果
+		// 解析查询结果，数字保持原样，避免大整数和DECIMAL在重新编码时丢失精度
 		var result map[string]interface{}
-		if err := json.Unmarshal(crw.body, &result); err != nil {
-			log.Printf("解析查询结果失败: %v, 原始数据: %s", err, string(crw.body))
+		decoder := json.NewDecoder(bytes.NewReader(crw.body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			log.Printf("解析查询结果失败: %v, 响应长度 %d 字节", err, len(crw.body))
 			w.WriteHeader(crw.statusCode)
 			w.Write(crw.body)
 			return
 		}
-		
-		log.Printf("可视化中间件处理数据: %+v", result)
 
 		// 添加可视化元数据
 		if result["error"] == nil || result["error"] == "" {
 			// 检测数据类型，添加适合的可视化类型
 			columnsRaw, hasColumns := result["columns"]
 			rowsRaw, hasRows := result["rows"]
-			
-			log.Printf("列: %v, 行: %v", columnsRaw, rowsRaw)
-			
+
 			if hasColumns && hasRows {
 				// 强制设置可视化类型以进行测试
 				visualTypes := []string{"table", "bar", "line"}
@@ -96,6 +101,11 @@ func VisualizationMiddleware(next http.Handler) http.Handler {
 				if ok1 && ok2 && len(columns) == 2 && len(rows) > 0 {
 					visualTypes = append(visualTypes, "pie")
 				}
+
+				// 对比模式的结果可以按当前期、基准期和变化展示
+				if result["comparison"] != nil {
+					visualTypes = append(visualTypes, "comparison")
+				}
 				
 				result["visualizationTypes"] = visualTypes
 				log.Printf("设置可视化类型: %v", visualTypes)
@@ -0,0 +1,197 @@
+package sqlparse
+
+import (
+	"fmt"
+)
+
+// StatementType 语句类别
+type StatementType string
+
+const (
+	StatementRead  StatementType = "read"  // SELECT、SHOW、DESCRIBE、EXPLAIN 等只读语句
+	StatementDML   StatementType = "dml"   // INSERT、UPDATE、DELETE 等数据修改语句
+	StatementDDL   StatementType = "ddl"   // CREATE、ALTER、DROP 等结构定义语句
+	StatementOther StatementType = "other" // 事务控制、会话设置、权限管理等其他语句
+)
+
+// Statement 单条语句的分类结果
+type Statement struct {
+	Type    StatementType
+	Keyword string // 决定分类的关键字，如 SELECT、DROP
+	Tokens  []Token
+}
+
+var statementKeywords = map[string]StatementType{
+	"SELECT":   StatementRead,
+	"SHOW":     StatementRead,
+	"DESCRIBE": StatementRead,
+	"DESC":     StatementRead,
+	"TABLE":    StatementRead,
+	"VALUES":   StatementRead,
+
+	"INSERT":  StatementDML,
+	"UPDATE":  StatementDML,
+	"DELETE":  StatementDML,
+	"REPLACE": StatementDML,
+	"LOAD":    StatementDML,
+	"CALL":    StatementDML,
+	"IMPORT":  StatementDML,
+
+	"CREATE":   StatementDDL,
+	"ALTER":    StatementDDL,
+	"DROP":     StatementDDL,
+	"TRUNCATE": StatementDDL,
+	"RENAME":   StatementDDL,
+}
+
+// Split 按分号将词法单元切分为多条语句，忽略空语句
+func Split(tokens []Token) [][]Token {
+	var stmts [][]Token
+	start := 0
+	for i, t := range tokens {
+		if t.IsPunct(";") {
+			if i > start {
+				stmts = append(stmts, tokens[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(tokens) {
+		stmts = append(stmts, tokens[start:])
+	}
+	return stmts
+}
+
+// Classify 解析SQL并对其中每条语句分类
+func Classify(sql string) ([]Statement, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []Statement
+	for _, stmtTokens := range Split(tokens) {
+		stmts = append(stmts, classifyStatement(stmtTokens))
+	}
+	return stmts, nil
+}
+
+// classifyStatement 对单条语句分类
+func classifyStatement(tokens []Token) Statement {
+	stmt := Statement{Type: StatementOther, Tokens: tokens}
+
+	// 跳过括号包裹的查询，如 (SELECT ...) UNION (SELECT ...)
+	i := 0
+	for i < len(tokens) && tokens[i].IsPunct("(") {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].Type != TokenWord {
+		return stmt
+	}
+
+	keyword := tokens[i].Upper()
+	stmt.Keyword = keyword
+
+	switch keyword {
+	case "WITH":
+		// WITH 之后第一个顶层的 SELECT/UPDATE/DELETE/INSERT 决定语句类别
+		if kw, ok := mainKeywordAfterWith(tokens[i+1:]); ok {
+			stmt.Keyword = kw
+			stmt.Type = statementKeywords[kw]
+		}
+		return stmt
+
+	case "EXPLAIN", "DESCRIBE", "DESC":
+		return classifyExplain(tokens[i:], stmt)
+	}
+
+	if t, ok := statementKeywords[keyword]; ok {
+		stmt.Type = t
+	}
+	return stmt
+}
+
+// classifyExplain EXPLAIN 不执行语句，只读；EXPLAIN ANALYZE 会真正执行语句，按被分析的语句分类
+func classifyExplain(tokens []Token, stmt Statement) Statement {
+	stmt.Type = StatementRead
+	if len(tokens) < 2 || !tokens[1].IsKeyword("ANALYZE") {
+		return stmt
+	}
+
+	rest := tokens[2:]
+	// 跳过 FORMAT=TREE 之类的选项
+	for len(rest) >= 3 && rest[0].IsKeyword("FORMAT") && rest[1].IsPunct("=") {
+		rest = rest[3:]
+	}
+	inner := classifyStatement(rest)
+	if inner.Type != StatementRead {
+		stmt.Type = inner.Type
+		stmt.Keyword = inner.Keyword
+	}
+	return stmt
+}
+
+// mainKeywordAfterWith 找出公用表表达式之后的主语句关键字
+func mainKeywordAfterWith(tokens []Token) (string, bool) {
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.IsPunct("("):
+			depth++
+		case t.IsPunct(")"):
+			depth--
+		case depth == 0 && t.Type == TokenWord:
+			switch kw := t.Upper(); kw {
+			case "SELECT", "UPDATE", "DELETE", "INSERT", "REPLACE", "TABLE", "VALUES":
+				return kw, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CheckReadOnly 检查SQL是否为单条只读语句，不满足时返回原因
+func CheckReadOnly(sql string) error {
+	stmts, err := Classify(sql)
+	if err != nil {
+		return fmt.Errorf("SQL解析失败: %w", err)
+	}
+
+	switch {
+	case len(stmts) == 0:
+		return fmt.Errorf("SQL为空")
+	case len(stmts) > 1:
+		return fmt.Errorf("只读模式不允许一次执行多条语句（共 %d 条）", len(stmts))
+	}
+
+	stmt := stmts[0]
+	switch stmt.Type {
+	case StatementRead:
+	case StatementDML:
+		return fmt.Errorf("只读模式不允许执行数据修改语句 %s", stmt.Keyword)
+	case StatementDDL:
+		return fmt.Errorf("只读模式不允许执行结构定义语句 %s", stmt.Keyword)
+	default:
+		if stmt.Keyword == "" {
+			return fmt.Errorf("无法识别的语句")
+		}
+		return fmt.Errorf("只读模式不允许执行 %s 语句", stmt.Keyword)
+	}
+
+	// SELECT ... INTO OUTFILE/DUMPFILE 会写文件，INTO @变量 会写会话变量并随连接留在连接池中，FOR UPDATE 会加锁
+	for i, t := range stmt.Tokens {
+		if t.IsKeyword("INTO") {
+			if i+1 < len(stmt.Tokens) {
+				if next := stmt.Tokens[i+1].Upper(); next == "OUTFILE" || next == "DUMPFILE" {
+					return fmt.Errorf("只读模式不允许 INTO %s", next)
+				}
+			}
+			return fmt.Errorf("只读模式不允许 SELECT ... INTO 赋值")
+		}
+		if t.IsKeyword("FOR") && i+1 < len(stmt.Tokens) && stmt.Tokens[i+1].IsKeyword("UPDATE") {
+			return fmt.Errorf("只读模式不允许 FOR UPDATE 加锁读")
+		}
+	}
+
+	return nil
+}
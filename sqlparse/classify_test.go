@@ -0,0 +1,114 @@
+package sqlparse
+
+import "testing"
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		ok   bool
+	}{
+		{"简单查询", "SELECT * FROM orders", true},
+		{"小写和结尾分号", "select 1;", true},
+		{"括号包裹的 UNION", "(SELECT 1) UNION (SELECT 2)", true},
+		{"SHOW", "SHOW TABLES", true},
+		{"DESC", "DESC orders", true},
+		{"公用表表达式查询", "WITH t AS (SELECT 1 AS a) SELECT a FROM t", true},
+		{"EXPLAIN 不执行语句", "EXPLAIN UPDATE orders SET amount = 0", true},
+		{"EXPLAIN ANALYZE 查询", "EXPLAIN ANALYZE SELECT * FROM orders", true},
+
+		// 注释和引号中的内容不参与分类
+		{"块注释中的分号", "SELECT 1 /* ; DELETE FROM orders */", true},
+		{"行注释中的分号", "SELECT 1 -- ; DELETE FROM orders", true},
+		{"井号注释中的分号", "SELECT 1 # ; DELETE FROM orders", true},
+		{"字符串中的语句", "SELECT 'x'';DROP TABLE orders; --' AS s", true},
+		{"反斜杠转义的引号", `SELECT 'a\'; DELETE FROM orders; --' AS s`, true},
+		{"双引号字符串中的关键字", `SELECT "INTO OUTFILE '/tmp/x'" AS s`, true},
+		{"反引号标识符中的关键字", "SELECT `into`, `for update`, `delete` FROM orders", true},
+		{"字符串中的 FOR UPDATE", "SELECT * FROM orders WHERE note = 'for update'", true},
+
+		// 绕过尝试
+		{"多条语句", "SELECT 1; DELETE FROM orders", false},
+		{"多条查询语句", "SELECT 1; SELECT 2", false},
+		{"分号后的注释不算语句之外", "SELECT 1; /* x */ DROP TABLE orders", false},
+		{"可执行注释中的修改语句", "/*!50000 DELETE FROM orders */", false},
+		{"可执行注释中的分号", "SELECT 1 /*!; DELETE FROM orders */", false},
+		{"可执行注释中的 INTO OUTFILE", "SELECT * FROM orders /*!50100 INTO OUTFILE '/tmp/x' */", false},
+		{"不带版本号的可执行注释", "/*! UPDATE orders SET amount = 0 */", false},
+		{"可执行注释未结束", "SELECT 1 /*! DELETE FROM orders", false},
+		{"嵌套的可执行注释", "SELECT /*! 1 /*! 2 */ */", false},
+		{"INTO OUTFILE", "SELECT * FROM orders INTO OUTFILE '/tmp/x'", false},
+		{"INTO DUMPFILE", "SELECT * FROM orders INTO DUMPFILE '/tmp/x'", false},
+		{"INTO 变量", "SELECT COUNT(*) INTO @n FROM orders", false},
+		{"INTO 多个变量", "SELECT id, amount FROM orders LIMIT 1 INTO @id, @amount", false},
+		{"FOR UPDATE", "SELECT * FROM orders FOR UPDATE", false},
+		{"小写 for update", "select * from orders for update", false},
+		{"WITH ... DELETE", "WITH t AS (SELECT 1 AS id) DELETE FROM orders WHERE id IN (SELECT id FROM t)", false},
+		{"WITH ... UPDATE", "WITH t AS (SELECT 1) UPDATE orders SET amount = 0", false},
+		{"WITH 嵌套括号后的 INSERT", "WITH t AS (SELECT (1)) INSERT INTO orders SELECT * FROM t", false},
+		{"EXPLAIN ANALYZE UPDATE", "EXPLAIN ANALYZE UPDATE orders SET amount = 0", false},
+		{"EXPLAIN ANALYZE DELETE 带格式", "EXPLAIN ANALYZE FORMAT=TREE DELETE FROM orders", false},
+		{"反引号包裹的关键字", "`DELETE` FROM orders", false},
+		{"引号包裹的关键字", "'SELECT' ; DELETE FROM orders", false},
+		{"括号包裹的修改语句", "(DELETE FROM orders)", false},
+		{"引号未闭合", "SELECT 'abc", false},
+		{"注释未结束", "SELECT 1 /* x", false},
+		{"空语句", " ; ", false},
+		{"只有注释", "-- SELECT 1", false},
+		{"SET", "SET @a = 1", false},
+		{"事务控制", "COMMIT", false},
+		{"DDL", "DROP TABLE orders", false},
+		{"LOAD DATA", "LOAD DATA INFILE '/tmp/x' INTO TABLE orders", false},
+		{"CALL", "CALL purge()", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckReadOnly(tt.sql)
+			if tt.ok && err != nil {
+				t.Errorf("CheckReadOnly(%q) = %v，应当允许", tt.sql, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("CheckReadOnly(%q) 应当拒绝", tt.sql)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		sql     string
+		types   []StatementType
+		keyword string // 第一条语句的关键字
+	}{
+		{"SELECT 1", []StatementType{StatementRead}, "SELECT"},
+		{"select 1; update t set a = 1", []StatementType{StatementRead, StatementDML}, "SELECT"},
+		{"WITH t AS (SELECT 1) DELETE FROM x", []StatementType{StatementDML}, "DELETE"},
+		{"EXPLAIN ANALYZE UPDATE t SET a = 1", []StatementType{StatementDML}, "UPDATE"},
+		{"EXPLAIN SELECT 1", []StatementType{StatementRead}, "EXPLAIN"},
+		{"/*!40101 TRUNCATE t */", []StatementType{StatementDDL}, "TRUNCATE"},
+		{"`SELECT` 1", []StatementType{StatementOther}, ""},
+		{"GRANT ALL ON *.* TO u", []StatementType{StatementOther}, "GRANT"},
+		{";;SELECT 1;;", []StatementType{StatementRead}, "SELECT"},
+	}
+
+	for _, tt := range tests {
+		stmts, err := Classify(tt.sql)
+		if err != nil {
+			t.Errorf("Classify(%q) 出错: %v", tt.sql, err)
+			continue
+		}
+		if len(stmts) != len(tt.types) {
+			t.Errorf("Classify(%q) 得到 %d 条语句，应为 %d 条", tt.sql, len(stmts), len(tt.types))
+			continue
+		}
+		for i, stmt := range stmts {
+			if stmt.Type != tt.types[i] {
+				t.Errorf("Classify(%q) 第 %d 条语句类别为 %s，应为 %s", tt.sql, i+1, stmt.Type, tt.types[i])
+			}
+		}
+		if stmts[0].Keyword != tt.keyword {
+			t.Errorf("Classify(%q) 关键字为 %q，应为 %q", tt.sql, stmts[0].Keyword, tt.keyword)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sqlparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ParamRef SQL中的一处命名参数引用，Start/End 为在原始SQL中的字节偏移
+type ParamRef struct {
+	Name  string
+	Start int
+	End   int
+}
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// FindParams 找出SQL中的命名参数，支持 :name 和 {{name}} 两种写法
+// 字符串、注释和反引号标识符中的内容不会被当作参数
+func FindParams(sql string) ([]ParamRef, error) {
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []ParamRef
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		// :name，冒号与名称之间不能有空白
+		if t.IsPunct(":") && i+1 < len(tokens) && adjacent(t, tokens[i+1]) && isParamName(tokens[i+1]) {
+			refs = append(refs, ParamRef{Name: tokens[i+1].Text, Start: t.Start, End: tokens[i+1].End})
+			i++
+			continue
+		}
+
+		// {{name}}，括号内允许空白
+		if t.IsPunct("{") && i+4 < len(tokens) && tokens[i+1].IsPunct("{") && adjacent(t, tokens[i+1]) &&
+			isParamName(tokens[i+2]) &&
+			tokens[i+3].IsPunct("}") && tokens[i+4].IsPunct("}") && adjacent(tokens[i+3], tokens[i+4]) {
+			refs = append(refs, ParamRef{Name: tokens[i+2].Text, Start: t.Start, End: tokens[i+4].End})
+			i += 4
+		}
+	}
+	return refs, nil
+}
+
+// ReplaceParams 将命名参数替换为 replace 返回的文本
+func ReplaceParams(sql string, refs []ParamRef, replace func(ref ParamRef) string) string {
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		b.WriteString(sql[last:ref.Start])
+		b.WriteString(replace(ref))
+		last = ref.End
+	}
+	b.WriteString(sql[last:])
+	return b.String()
+}
+
+// ValidParamName 检查参数名是否合法
+func ValidParamName(name string) bool {
+	return paramNamePattern.MatchString(name)
+}
+
+func isParamName(t Token) bool {
+	return t.Type == TokenWord && ValidParamName(t.Text)
+}
+
+func adjacent(a, b Token) bool {
+	return a.End == b.Start
+}
@@ -0,0 +1,247 @@
+package sqlparse
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenType 词法单元类型
+type TokenType int
+
+const (
+	TokenWord        TokenType = iota // 关键字或未加引号的标识符
+	TokenQuotedIdent                  // 反引号标识符
+	TokenString                       // 字符串常量
+	TokenNumber                       // 数字常量
+	TokenVariable                     // @变量 或 @@系统变量
+	TokenPunct                        // 运算符和标点
+)
+
+// Token 词法单元，Start/End 为在原始SQL中的字节偏移
+type Token struct {
+	Type  TokenType
+	Text  string
+	Start int
+	End   int
+}
+
+// Upper 返回大写形式，便于关键字比较
+func (t Token) Upper() string {
+	return strings.ToUpper(t.Text)
+}
+
+// IsKeyword 判断是否为指定关键字（不区分大小写）
+func (t Token) IsKeyword(kw string) bool {
+	return t.Type == TokenWord && strings.EqualFold(t.Text, kw)
+}
+
+// IsPunct 判断是否为指定标点
+func (t Token) IsPunct(p string) bool {
+	return t.Type == TokenPunct && t.Text == p
+}
+
+// Tokenize 将SQL切分为词法单元，注释和空白被忽略
+// MySQL 可执行注释 /*! ... */ 中的内容会被当作普通SQL处理
+func Tokenize(sql string) ([]Token, error) {
+	var tokens []Token
+	inExecComment := false
+
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "--") && (i+2 == len(sql) || isSpace(sql[i+2]))):
+			// 单行注释
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*!"):
+			if inExecComment {
+				return nil, fmt.Errorf("位置 %d: 不支持嵌套的可执行注释", i)
+			}
+			// 可执行注释，跳过版本号后继续解析其中的SQL
+			i += 3
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+			inExecComment = true
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("位置 %d: 注释未结束", i)
+			}
+			i += end + 4
+
+		case c == '*' && inExecComment && strings.HasPrefix(sql[i:], "*/"):
+			inExecComment = false
+			i += 2
+
+		case c == '\'' || c == '"':
+			end, err := scanQuoted(sql, i, c, true)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Type: TokenString, Text: sql[i:end], Start: i, End: end})
+			i = end
+
+		case c == '`':
+			end, err := scanQuoted(sql, i, c, false)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, Token{Type: TokenQuotedIdent, Text: sql[i:end], Start: i, End: end})
+			i = end
+
+		case c == '@':
+			end := i + 1
+			if end < len(sql) && sql[end] == '@' {
+				end++
+			}
+			if end < len(sql) && (sql[end] == '\'' || sql[end] == '"' || sql[end] == '`') {
+				var err error
+				end, err = scanQuoted(sql, end, sql[end], sql[end] != '`')
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				for end < len(sql) && (isWordByte(sql, end) || sql[end] == '.') {
+					_, size := utf8.DecodeRuneInString(sql[end:])
+					end += size
+				}
+			}
+			tokens = append(tokens, Token{Type: TokenVariable, Text: sql[i:end], Start: i, End: end})
+			i = end
+
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9'):
+			end := scanNumber(sql, i)
+			// 以数字开头的标识符，如 1abc
+			if end < len(sql) && isWordByte(sql, end) {
+				end = scanWord(sql, i)
+				tokens = append(tokens, Token{Type: TokenWord, Text: sql[i:end], Start: i, End: end})
+			} else {
+				tokens = append(tokens, Token{Type: TokenNumber, Text: sql[i:end], Start: i, End: end})
+			}
+			i = end
+
+		case isWordByte(sql, i):
+			end := scanWord(sql, i)
+			tokens = append(tokens, Token{Type: TokenWord, Text: sql[i:end], Start: i, End: end})
+			i = end
+
+		default:
+			end := i + 1
+			for _, op := range multiCharOps {
+				if strings.HasPrefix(sql[i:], op) {
+					end = i + len(op)
+					break
+				}
+			}
+			tokens = append(tokens, Token{Type: TokenPunct, Text: sql[i:end], Start: i, End: end})
+			i = end
+		}
+	}
+
+	if inExecComment {
+		return nil, fmt.Errorf("可执行注释未结束")
+	}
+
+	return tokens, nil
+}
+
+// 多字符运算符，较长的排在前面
+var multiCharOps = []string{"<=>", "<<", ">>", "<=", ">=", "<>", "!=", ":=", "&&", "||", "->>", "->"}
+
+// scanQuoted 扫描引号包裹的内容，返回结束位置（不含）
+// 引号可以通过重复两次转义，字符串中还支持反斜杠转义
+func scanQuoted(sql string, start int, quote byte, backslash bool) (int, error) {
+	i := start + 1
+	for i < len(sql) {
+		switch {
+		case backslash && sql[i] == '\\':
+			i += 2
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1, nil
+		default:
+			i++
+		}
+	}
+	return 0, fmt.Errorf("位置 %d: 引号 %c 未闭合", start, quote)
+}
+
+// scanNumber 扫描数字常量，包括小数、科学计数法和十六进制
+func scanNumber(sql string, start int) int {
+	i := start
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+		for i < len(sql) && isHexDigit(sql[i]) {
+			i++
+		}
+		return i
+	}
+
+	for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+		i++
+	}
+	if i < len(sql) && sql[i] == '.' {
+		i++
+		for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+			i++
+		}
+	}
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+			i = j
+			for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// scanWord 扫描关键字或标识符
+func scanWord(sql string, start int) int {
+	i := start
+	for i < len(sql) && isWordByte(sql, i) {
+		_, size := utf8.DecodeRuneInString(sql[i:])
+		i += size
+	}
+	return i
+}
+
+// isWordByte 判断位置 i 处的字符能否构成标识符
+func isWordByte(sql string, i int) bool {
+	c := sql[i]
+	if c < utf8.RuneSelf {
+		return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	r, _ := utf8.DecodeRuneInString(sql[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -36,6 +36,23 @@ class ExcelImporter {
             removeBtn.addEventListener('click', () => this.removeSelectedFile());
         }
 
+        // 导入到数据表
+        const tableCheckBtn = document.getElementById('table-import-check-btn');
+        if (tableCheckBtn) {
+            tableCheckBtn.addEventListener('click', () => this.importToTable(true));
+        }
+        const tableImportBtn = document.getElementById('table-import-btn');
+        if (tableImportBtn) {
+            tableImportBtn.addEventListener('click', () => this.importToTable(false));
+        }
+
+        // 上传为文件数据源的表
+        const fileTableBtn = document.getElementById('file-table-upload-btn');
+        if (fileTableBtn) {
+            fileTableBtn.addEventListener('click', () => this.uploadFileTable());
+            this.loadFileTables();
+        }
+
         // 预览按钮事件
         const previewBtn = document.getElementById('preview-btn');
         if (previewBtn) {
@@ -153,6 +170,18 @@ class ExcelImporter {
             if (importBtn) {
                 importBtn.disabled = false;
             }
+            this.setTableImportEnabled(true);
+        }
+    }
+
+    setTableImportEnabled(enabled) {
+        ['table-import-check-btn', 'table-import-btn', 'file-table-upload-btn'].forEach(id => {
+            const btn = document.getElementById(id);
+            if (btn) btn.disabled = !enabled;
+        });
+        if (!enabled) {
+            const resultDiv = document.getElementById('table-import-result');
+            if (resultDiv) resultDiv.innerHTML = '';
         }
     }
 
@@ -169,6 +198,7 @@ class ExcelImporter {
         if (fileInput) fileInput.value = '';
         if (importBtn) importBtn.disabled = true;
         if (previewDiv) previewDiv.style.display = 'none';
+        this.setTableImportEnabled(false);
 
         this.showStatus('', '');
     }
@@ -397,7 +427,7 @@ class ExcelImporter {
         tabElement.className = 'tab';
         tabElement.setAttribute('data-tab', queryId);
         tabElement.innerHTML = `
-            <i class="fas fa-database"></i> 查询 ${queryId}
+            <i class="fas fa-database"></i> <span class="tab-title">查询 ${queryId}</span>
             <span class="close-tab" onclick="removeQuery(${queryId}, event)"><i class="fas fa-times"></i></span>
         `;
         tabElement.onclick = () => switchTab(queryId);
@@ -412,19 +442,37 @@ class ExcelImporter {
         queryContainer.innerHTML = `
             <div class="sql-editor-container" id="sql-editor-${queryId}"></div>
             <div class="query-actions">
+                <select class="datasource-select" id="datasource-${queryId}" title="数据源"></select>
                 <button class="execute-btn" onclick="executeQuery(${queryId})">
                     <i class="fas fa-play"></i> 执行查询
                 </button>
                 <button class="save-btn" onclick="saveQuery(${queryId})">
                     <i class="fas fa-save"></i> 保存
                 </button>
+                <button class="explain-btn" onclick="explainQuery(${queryId})">
+                    <i class="fas fa-sitemap"></i> 执行计划
+                </button>
+                <select class="export-select" onchange="exportQuery(${queryId}, this)" title="导出全部结果">
+                    <option value="">导出...</option>
+                    <option value="xlsx">Excel (XLSX)</option>
+                    <option value="csv-bom">CSV (Excel)</option>
+                    <option value="csv">CSV</option>
+                    <option value="tsv">TSV</option>
+                    <option value="jsonl">JSON Lines</option>
+                    <option value="parquet">Parquet</option>
+                    <option value="arrow">Arrow IPC</option>
+                </select>
             </div>
             <div class="error"></div>
             <div class="visual-controls" style="display:none;"></div>
+            <div class="explain-result" style="display:none;"></div>
             <div class="result"></div>
         `;
 
         document.getElementById('queries-container').appendChild(queryContainer);
+        if (typeof fillDataSourceSelect === 'function') {
+            fillDataSourceSelect(queryContainer.querySelector('.datasource-select'));
+        }
 
         // 等待DOM更新后初始化SQL编辑器
         await new Promise(resolve => setTimeout(resolve, 100));
@@ -503,6 +551,161 @@ class ExcelImporter {
         }
     }
 
+    // 上传文件到服务端，校验（dryRun）或导入到数据源的表
+    async importToTable(dryRun) {
+        if (!this.selectedFile) {
+            this.showStatus('请先选择文件', 'error');
+            return;
+        }
+        const table = document.getElementById('table-import-table').value.trim();
+        if (!dryRun && !table) {
+            this.showStatus('请输入目标表名', 'error');
+            return;
+        }
+        const mode = document.getElementById('table-import-mode').value;
+        if (!dryRun && !confirm(`确定将 ${this.selectedFile.name} 导入到表 ${table}（${mode === 'create' ? '新建表' : '追加'}）吗？`)) {
+            return;
+        }
+
+        const form = new FormData();
+        form.append('file', this.selectedFile);
+        form.append('options', JSON.stringify({
+            datasource: document.getElementById('table-import-datasource').value,
+            table: table,
+            mode: mode,
+            dryRun: dryRun,
+            stopOnError: document.getElementById('table-import-stop').checked
+        }));
+
+        this.showStatus(dryRun ? '正在校验...' : '正在导入...', 'processing');
+        try {
+            const response = await fetch('/api/import', { method: 'POST', body: form });
+            if (!response.ok) {
+                this.showStatus(`${dryRun ? '校验' : '导入'}失败: ${this.escapeHtml(await response.text())}`, 'error');
+                return;
+            }
+            const result = await response.json();
+            this.renderTableImportResult(result);
+            if (result.dryRun) {
+                this.showStatus(`校验完成：共 ${result.totalRows} 行，${result.failed} 行有错误`, result.failed > 0 ? 'error' : 'success');
+            } else if (result.committed) {
+                this.showStatus(`导入完成：写入 ${result.inserted} 行，${result.failed} 行失败，耗时 ${result.duration}`, result.failed > 0 ? 'info' : 'success');
+            } else {
+                this.showStatus(`有 ${result.failed} 行错误，未写入任何数据`, 'error');
+            }
+        } catch (error) {
+            this.showStatus(`请求失败: ${this.escapeHtml(error.message)}`, 'error');
+        }
+    }
+
+    renderTableImportResult(result) {
+        const resultDiv = document.getElementById('table-import-result');
+        if (!resultDiv) return;
+
+        let html = '<table class="preview-table"><thead><tr><th>列</th><th>文件表头</th><th>类型</th><th>可空</th></tr></thead><tbody>';
+        result.columns.forEach(col => {
+            html += `<tr class="${col.skip ? 'skipped' : ''}"><td>${this.escapeHtml(col.name)}</td><td>${this.escapeHtml(col.source || '')}</td>` +
+                `<td>${this.escapeHtml(col.sqlType)}</td><td>${col.nullable ? '是' : '否'}</td></tr>`;
+        });
+        html += '</tbody></table>';
+
+        if (result.ddl) {
+            html += `<pre class="table-import-ddl">${this.escapeHtml(result.ddl)}</pre>`;
+        }
+
+        if (result.preview && result.preview.length > 0) {
+            html += '<table class="preview-table"><thead><tr>';
+            result.previewColumns.forEach(name => { html += `<th>${this.escapeHtml(name)}</th>`; });
+            html += '</tr></thead><tbody>';
+            result.preview.forEach(row => {
+                html += '<tr>' + row.map(v => `<td>${v === null ? '<i>NULL</i>' : this.escapeHtml(String(v))}</td>`).join('') + '</tr>';
+            });
+            html += '</tbody></table>';
+        }
+
+        if (result.errors && result.errors.length > 0) {
+            html += '<ul class="table-import-errors">';
+            result.errors.forEach(e => {
+                const column = e.column ? ` 列 ${this.escapeHtml(e.column)}` : '';
+                const value = e.value !== undefined ? ` 值 "${this.escapeHtml(e.value)}"` : '';
+                html += `<li>第 ${e.line} 行${column}${value}: ${this.escapeHtml(e.error)}</li>`;
+            });
+            if (result.failed > result.errors.length) {
+                html += `<li>…还有 ${result.failed - result.errors.length} 行错误</li>`;
+            }
+            html += '</ul>';
+        }
+        resultDiv.innerHTML = html;
+    }
+
+    // 上传文件为当前会话的文件表，之后可以选择 files 数据源用SQL查询
+    async uploadFileTable() {
+        if (!this.selectedFile) {
+            this.showStatus('请先选择文件', 'error');
+            return;
+        }
+
+        const form = new FormData();
+        form.append('file', this.selectedFile);
+        form.append('options', JSON.stringify({
+            table: document.getElementById('file-table-name').value.trim(),
+            replace: document.getElementById('file-table-replace').checked
+        }));
+
+        this.showStatus('正在上传...', 'processing');
+        try {
+            const response = await fetch('/api/files', { method: 'POST', body: form });
+            if (!response.ok) {
+                this.showStatus(`上传失败: ${this.escapeHtml(await response.text())}`, 'error');
+                return;
+            }
+            const result = await response.json();
+            this.renderTableImportResult(result);
+            this.showStatus(`已创建文件表 ${this.escapeHtml(result.table.name)}：${result.inserted} 行，${result.failed} 行无法转换已跳过。` +
+                '查询时选择 files 数据源', result.failed > 0 ? 'info' : 'success');
+            this.loadFileTables();
+        } catch (error) {
+            this.showStatus(`请求失败: ${this.escapeHtml(error.message)}`, 'error');
+        }
+    }
+
+    async loadFileTables() {
+        const listDiv = document.getElementById('file-table-list');
+        if (!listDiv) return;
+        try {
+            const response = await fetch('/api/files');
+            const data = await response.json();
+            if (!data.tables || data.tables.length === 0) {
+                listDiv.innerHTML = '<p class="file-table-empty">还没有上传的文件表</p>';
+                return;
+            }
+            let html = '<table class="preview-table"><thead><tr><th>表名</th><th>文件</th><th>行数</th><th>列</th><th></th></tr></thead><tbody>';
+            data.tables.forEach(t => {
+                const columns = t.columns.map(c => `${this.escapeHtml(c.name)} <small>${this.escapeHtml(c.sqlType)}</small>`).join(', ');
+                html += `<tr><td>${this.escapeHtml(t.name)}</td><td>${this.escapeHtml(t.fileName)}</td><td>${t.rows}</td><td>${columns}</td>` +
+                    `<td><button class="excel-btn secondary file-table-delete" data-table="${this.escapeHtml(t.name)}"><i class="fas fa-trash"></i></button></td></tr>`;
+            });
+            html += '</tbody></table>';
+            listDiv.innerHTML = html;
+            listDiv.querySelectorAll('.file-table-delete').forEach(btn => {
+                btn.addEventListener('click', () => this.deleteFileTable(btn.dataset.table));
+            });
+        } catch (error) {
+            console.error('加载文件表失败:', error);
+        }
+    }
+
+    async deleteFileTable(name) {
+        if (!confirm(`确定删除文件表 ${name} 吗？`)) {
+            return;
+        }
+        const response = await fetch(`/api/files/${encodeURIComponent(name)}`, { method: 'DELETE' });
+        if (!response.ok) {
+            this.showStatus(`删除失败: ${this.escapeHtml(await response.text())}`, 'error');
+        }
+        this.loadFileTables();
+    }
+
     showStatus(message, type) {
         const statusDiv = document.getElementById('import-status');
         if (!statusDiv) return;
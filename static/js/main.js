@@ -2,6 +2,67 @@
 let queryResults = {}; // 存储所有查询结果
 let queryCount = 1; // 当前查询数量
 let activeTab = 1; // 当前激活的标签页
+let dataSources = []; // 可用数据源列表
+let runningQueryIds = {}; // 正在执行的查询ID，按标签页索引
+let savedQueryMeta = {}; // 标签页对应的已保存查询，按标签页索引
+let historyEntries = {}; // 当前显示的执行历史，按记录ID索引
+
+// 加载数据源列表
+async function loadDataSources() {
+    try {
+        const response = await fetch('/api/datasources');
+        const data = await response.json();
+        dataSources = data.datasources || [];
+    } catch (err) {
+        console.error('加载数据源失败:', err);
+        dataSources = [];
+    }
+    document.querySelectorAll('.datasource-select').forEach(select => fillDataSourceSelect(select));
+    loadSchemaForCompletion(getQueryDataSource(activeTab));
+}
+
+// 填充数据源下拉框
+function fillDataSourceSelect(select) {
+    const current = select.value;
+    // 导入到数据表时不能选择文件数据源
+    const sources = select.id === 'table-import-datasource' ? dataSources.filter(ds => !ds.file) : dataSources;
+    select.innerHTML = sources.map(ds => ds.file
+        ? `<option value="${ds.name}">${ds.name} (上传的文件) [只读]</option>`
+        : `<option value="${ds.name}" ${ds.default ? 'selected' : ''}>${ds.name} (${ds.database})${ds.readOnly ? ' [只读]' : ''}</option>`
+    ).join('');
+    if (current && sources.some(ds => ds.name === current)) {
+        select.value = current;
+    }
+    select.style.display = sources.length > 1 ? '' : 'none';
+    select.onchange = () => loadSchemaForCompletion(select.value);
+}
+
+// 为SQL智能提示加载当前数据源的表结构
+function loadSchemaForCompletion(datasource) {
+    if (window.sqlIntelliSense && window.sqlIntelliSense.loadSchema) {
+        window.sqlIntelliSense.loadSchema(datasource);
+    }
+}
+
+// 格式化查询错误信息，超时等错误码单独提示
+function formatQueryError(data) {
+    switch (data.errorCode) {
+        case 'timeout':
+            return `<i class="fas fa-hourglass-end"></i> 查询超时: ${data.error}`;
+        case 'cancelled':
+            return `<i class="fas fa-ban"></i> ${data.error}`;
+        case 'readonly':
+            return `<i class="fas fa-lock"></i> 只读数据源: ${data.error}`;
+        default:
+            return `<i class="fas fa-exclamation-circle"></i> ${data.error}`;
+    }
+}
+
+// 获取查询使用的数据源
+function getQueryDataSource(queryId) {
+    const select = document.getElementById(`datasource-${queryId}`);
+    return select ? select.value : '';
+}
 
 // 获取SQL查询内容
 function getSQLQuery(queryId) {
@@ -24,27 +85,334 @@ function setSQLQuery(queryId, sql) {
     }
 }
 
-// 保存查询
-function saveQuery(queryId) {
+// 保存查询到服务端，已保存过的标签页更新原查询
+async function saveQuery(queryId) {
     const sql = getSQLQuery(queryId);
     if (!sql.trim()) {
         alert('查询内容为空，无法保存');
         return;
     }
     
-    // 保存到本地存储
-    let savedQueries = JSON.parse(localStorage.getItem('savedQueries') || '[]');
-    const queryName = prompt('请输入查询名称:', `查询_${new Date().toLocaleString()}`);
+    const saved = savedQueryMeta[queryId];
+    let payload;
+    if (saved) {
+        payload = {
+            title: saved.title,
+            description: saved.description,
+            tags: saved.tags,
+            paramDefs: saved.paramDefs,
+            query: sql,
+            datasource: getQueryDataSource(queryId),
+            baseRevision: saved.revision
+        };
+    } else {
+        const title = prompt('请输入查询名称:', `查询_${new Date().toLocaleString()}`);
+        if (!title) {
+            return;
+        }
+        const tags = prompt('请输入标签（多个用逗号分隔，可留空）:', '') || '';
+        payload = {
+            title: title,
+            tags: tags.split(/[,，]/).map(t => t.trim()).filter(t => t),
+            query: sql,
+            datasource: getQueryDataSource(queryId)
+        };
+    }
+    
+    try {
+        const response = await fetch(saved ? `/api/saved-queries/${saved.id}` : '/api/saved-queries', {
+            method: saved ? 'PUT' : 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(payload)
+        });
+        if (response.status === 409) {
+            const conflict = await response.json();
+            alert(`${conflict.error}\n请在“已保存查询”中查看修订历史后重新打开。`);
+            return;
+        }
+        if (response.status === 403) {
+            alert((await response.json()).error);
+            return;
+        }
+        if (!response.ok) {
+            throw new Error((await response.text()) || response.statusText);
+        }
+        const data = await response.json();
+        bindSavedQuery(queryId, data);
+        alert(saved ? '查询已更新！' : '查询已保存！');
+        if (document.getElementById('saved-queries-panel').style.display !== 'none') {
+            loadSavedQueries();
+        }
+    } catch (err) {
+        alert(`保存失败: ${err.message}`);
+    }
+}
+
+// 记录标签页对应的已保存查询，并用标题作为标签名
+function bindSavedQuery(queryId, data) {
+    savedQueryMeta[queryId] = {
+        id: data.id,
+        title: data.title,
+        description: data.description || '',
+        tags: data.tags || [],
+        paramDefs: data.paramDefs,
+        revision: data.revision
+    };
+    const tab = document.querySelector(`.tab[data-tab="${queryId}"] .tab-title`);
+    if (tab) {
+        tab.textContent = data.title;
+    }
+}
+
+// 显示或隐藏已保存查询面板
+function toggleSavedQueries() {
+    const panel = document.getElementById('saved-queries-panel');
+    if (panel.style.display === 'none') {
+        panel.style.display = 'block';
+        loadSavedQueries();
+    } else {
+        panel.style.display = 'none';
+    }
+}
+
+// 加载已保存查询列表
+async function loadSavedQueries() {
+    const list = document.getElementById('saved-queries-list');
+    const search = document.getElementById('saved-queries-search').value.trim();
+    list.innerHTML = '<div class="loading"><i class="fas fa-spinner fa-spin"></i> 加载中...</div>';
+    
+    try {
+        const response = await fetch(`/api/saved-queries?q=${encodeURIComponent(search)}`);
+        const data = await response.json();
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        const queries = data.savedQueries || [];
+        if (queries.length === 0) {
+            list.innerHTML = '<p><i class="fas fa-info-circle"></i> 暂无已保存的查询</p>';
+            return;
+        }
+        list.innerHTML = queries.map(q => {
+            const tags = (q.tags || []).map(t => `<span class="saved-query-tag">${escapePlanText(t)}</span>`).join('');
+            let stats = `${escapePlanText(q.owner)} · 更新于 ${new Date(q.updatedAt).toLocaleString()}`;
+            if (q.lastRun) {
+                stats += ` · 最近执行 ${new Date(q.lastRun.at).toLocaleString()}`;
+                stats += q.lastRun.error ? ' (失败)' : ` (${q.lastRun.rowCount} 行, ${q.lastRun.duration})`;
+            }
+            return `<div class="saved-query-item">
+                <div class="saved-query-info">
+                    <div class="saved-query-title">${escapePlanText(q.title)} ${tags}</div>
+                    <div class="saved-query-stats">${q.datasource ? escapePlanText(q.datasource) + ' · ' : ''}${stats}</div>
+                </div>
+                <button onclick="openSavedQuery(${q.id})"><i class="fas fa-folder-open"></i> 打开</button>
+                <button onclick="showRevisions(${q.id})"><i class="fas fa-history"></i> 历史 (${q.revision})</button>
+                <button class="danger" onclick="deleteSavedQuery(${q.id})"><i class="fas fa-trash"></i> 删除</button>
+            </div>`;
+        }).join('');
+    } catch (err) {
+        list.innerHTML = `<div class="error"><i class="fas fa-exclamation-triangle"></i> 加载失败: ${err.message}</div>`;
+    }
+}
+
+// 显示已保存查询的修订历史
+async function showRevisions(id) {
+    const list = document.getElementById('saved-queries-list');
+    list.innerHTML = '<div class="loading"><i class="fas fa-spinner fa-spin"></i> 加载中...</div>';
     
-    if (queryName) {
-        savedQueries.push({
-            name: queryName,
-            sql: sql,
-            timestamp: new Date().toISOString()
+    try {
+        const response = await fetch(`/api/saved-queries/${id}/revisions`);
+        const data = await response.json();
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        let html = `<button onclick="loadSavedQueries()"><i class="fas fa-arrow-left"></i> 返回列表</button>`;
+        (data.revisions || []).forEach((rev, index) => {
+            let info = `${escapePlanText(rev.author)} · ${new Date(rev.createdAt).toLocaleString()}`;
+            if (rev.restoredFrom) {
+                info += ` · 从修订 ${rev.restoredFrom} 恢复`;
+            }
+            html += `<div class="saved-query-item">
+                <div class="saved-query-info">
+                    <div class="saved-query-title">修订 ${rev.revision}: ${escapePlanText(rev.title)}${index === 0 ? ' <span class="saved-query-tag">当前</span>' : ''}</div>
+                    <div class="saved-query-stats">${info}</div>
+                </div>
+                ${rev.revision > 1 ? `<button onclick="showRevisionDiff(${id}, ${rev.revision - 1}, ${rev.revision})"><i class="fas fa-exchange-alt"></i> 对比上一版</button>` : ''}
+                ${index > 0 ? `<button onclick="restoreRevision(${id}, ${rev.revision})"><i class="fas fa-undo"></i> 恢复</button>` : ''}
+            </div>`;
+        });
+        html += '<div id="revision-diff"></div>';
+        list.innerHTML = html;
+    } catch (err) {
+        list.innerHTML = `<div class="error"><i class="fas fa-exclamation-triangle"></i> 加载失败: ${err.message}</div>`;
+    }
+}
+
+// 显示两个修订之间的差异
+async function showRevisionDiff(id, from, to) {
+    const target = document.getElementById('revision-diff');
+    try {
+        const response = await fetch(`/api/saved-queries/${id}/diff?from=${from}&to=${to}`);
+        const data = await response.json();
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        let html = `<h4>修订 ${data.from} → ${data.to}（+${data.added} / -${data.removed}）</h4>`;
+        data.changes.forEach(change => {
+            html += `<div class="saved-query-stats">${change.field}: ${escapePlanText(JSON.stringify(change.old))} → ${escapePlanText(JSON.stringify(change.new))}</div>`;
+        });
+        html += '<pre class="revision-diff">' + data.lines.map(line => {
+            const mark = line.op === 'insert' ? '+' : line.op === 'delete' ? '-' : ' ';
+            return `<div class="diff-${line.op}">${mark} ${escapePlanText(line.text)}</div>`;
+        }).join('') + '</pre>';
+        target.innerHTML = html;
+    } catch (err) {
+        target.innerHTML = `<div class="error"><i class="fas fa-exclamation-triangle"></i> 对比失败: ${err.message}</div>`;
+    }
+}
+
+// 恢复到指定修订
+async function restoreRevision(id, revision) {
+    if (!confirm(`确定恢复到修订 ${revision} 吗？恢复会生成一个新修订。`)) {
+        return;
+    }
+    try {
+        const response = await fetch(`/api/saved-queries/${id}/revisions/${revision}/restore`, { method: 'POST' });
+        const data = await response.json();
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        // 已打开该查询的标签页同步为恢复后的内容
+        Object.keys(savedQueryMeta).forEach(queryId => {
+            if (savedQueryMeta[queryId].id === id) {
+                setSQLQuery(queryId, data.query);
+                bindSavedQuery(queryId, data);
+            }
         });
+        showRevisions(id);
+    } catch (err) {
+        alert(`恢复失败: ${err.message}`);
+    }
+}
+
+// 显示或隐藏执行历史面板
+function toggleHistory() {
+    const panel = document.getElementById('history-panel');
+    if (panel.style.display === 'none') {
+        panel.style.display = 'block';
+        loadHistory(1);
+    } else {
+        panel.style.display = 'none';
+    }
+}
+
+// 加载一页执行历史
+async function loadHistory(page) {
+    const list = document.getElementById('history-list');
+    const params = new URLSearchParams({ page: page, pageSize: 20 });
+    const search = document.getElementById('history-search').value.trim();
+    const user = document.getElementById('history-user').value.trim();
+    const status = document.getElementById('history-status').value;
+    if (search) params.set('q', search);
+    if (user) params.set('user', user);
+    if (status) params.set('status', status);
+    list.innerHTML = '<div class="loading"><i class="fas fa-spinner fa-spin"></i> 加载中...</div>';
+    
+    try {
+        const response = await fetch(`/api/history?${params}`);
+        if (!response.ok) {
+            throw new Error((await response.text()) || response.statusText);
+        }
+        const data = await response.json();
+        historyEntries = {};
+        if (data.history.length === 0) {
+            list.innerHTML = '<p><i class="fas fa-info-circle"></i> 暂无执行记录</p>';
+            return;
+        }
+        let html = data.history.map(entry => {
+            historyEntries[entry.id] = entry;
+            let stats = `${escapePlanText(entry.user)} · ${escapePlanText(entry.clientIp)} · ${escapePlanText(entry.datasource)} · ${new Date(entry.startedAt).toLocaleString()} · ${entry.durationMs}ms`;
+            stats += entry.error ? ` · <span class="history-error">${escapePlanText(entry.error)}</span>` : ` · ${entry.rowCount} 行`;
+            return `<div class="saved-query-item">
+                <div class="saved-query-info">
+                    <div class="saved-query-title history-sql" title="${escapePlanText(entry.query)}">${escapePlanText(entry.query)}</div>
+                    <div class="saved-query-stats">${stats}</div>
+                </div>
+                <button onclick="rerunHistory(${entry.id})"><i class="fas fa-redo"></i> 重新执行</button>
+            </div>`;
+        }).join('');
         
-        localStorage.setItem('savedQueries', JSON.stringify(savedQueries));
-        alert('查询已保存！');
+        const pages = Math.ceil(data.total / data.pageSize);
+        html += `<div class="history-pager">
+            <button ${data.page <= 1 ? 'disabled' : ''} onclick="loadHistory(${data.page - 1})"><i class="fas fa-chevron-left"></i></button>
+            第 ${data.page} / ${pages} 页，共 ${data.total} 条
+            <button ${data.page >= pages ? 'disabled' : ''} onclick="loadHistory(${data.page + 1})"><i class="fas fa-chevron-right"></i></button>
+        </div>`;
+        list.innerHTML = html;
+    } catch (err) {
+        list.innerHTML = `<div class="error"><i class="fas fa-exclamation-triangle"></i> 加载失败: ${err.message}</div>`;
+    }
+}
+
+// 在新标签页中载入历史SQL并执行
+function rerunHistory(id) {
+    const entry = historyEntries[id];
+    if (!entry) {
+        return;
+    }
+    addQuery();
+    const queryId = queryCount;
+    setSQLQuery(queryId, entry.query);
+    const select = document.getElementById(`datasource-${queryId}`);
+    if (select && dataSources.some(ds => ds.name === entry.datasource)) {
+        select.value = entry.datasource;
+        loadSchemaForCompletion(entry.datasource);
+    }
+    switchTab(queryId);
+    executeQuery(queryId);
+}
+
+// 在新标签页中打开已保存查询
+async function openSavedQuery(id) {
+    try {
+        const response = await fetch(`/api/saved-queries/${id}`);
+        const data = await response.json();
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        addQuery();
+        const queryId = queryCount;
+        setSQLQuery(queryId, data.query);
+        const select = document.getElementById(`datasource-${queryId}`);
+        if (select && data.datasource && dataSources.some(ds => ds.name === data.datasource)) {
+            select.value = data.datasource;
+            loadSchemaForCompletion(data.datasource);
+        }
+        bindSavedQuery(queryId, data);
+        switchTab(queryId);
+    } catch (err) {
+        alert(`打开查询失败: ${err.message}`);
+    }
+}
+
+// 删除已保存查询
+async function deleteSavedQuery(id) {
+    if (!confirm('确定删除该查询吗？')) {
+        return;
+    }
+    try {
+        const response = await fetch(`/api/saved-queries/${id}`, { method: 'DELETE' });
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || response.statusText);
+        }
+        Object.keys(savedQueryMeta).forEach(queryId => {
+            if (savedQueryMeta[queryId].id === id) {
+                delete savedQueryMeta[queryId];
+            }
+        });
+        loadSavedQueries();
+    } catch (err) {
+        alert(`删除失败: ${err.message}`);
     }
 }
 
@@ -75,22 +443,32 @@ async function executeQuery(queryId) {
     
     const query = getSQLQuery(queryId);
     
+    const runId = newQueryId();
+    runningQueryIds[queryId] = runId;
+    
     errorDiv.innerHTML = '';
-    resultDiv.innerHTML = '<div class="loading"><i class="fas fa-spinner fa-spin"></i> 查询中...</div>';
+    resultDiv.innerHTML = `<div class="loading"><i class="fas fa-spinner fa-spin"></i> 查询中...
+        <button class="cancel-query-btn" onclick="cancelQuery(${queryId})"><i class="fas fa-stop"></i> 取消</button></div>`;
     visualControls.style.display = 'none';
     
     try {
         const response = await fetch('/api/query', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ query: query })
+            body: JSON.stringify({
+                query: query,
+                datasource: getQueryDataSource(queryId),
+                queryId: runId,
+                savedQueryId: savedQueryMeta[queryId] ? savedQueryMeta[queryId].id : undefined
+            })
         });
         
         const data = await response.json();
+        delete runningQueryIds[queryId];
         queryResults[queryId] = data; // 保存查询结果
         
         if (data.error) {
-            errorDiv.innerHTML = `<i class="fas fa-exclamation-circle"></i> ${data.error}`;
+            errorDiv.innerHTML = formatQueryError(data);
             resultDiv.innerHTML = '';
             return;
         }
@@ -102,6 +480,9 @@ async function executeQuery(queryId) {
             if (data.rowCount !== undefined) {
                 statsHtml += ` | <i class="fas fa-list"></i> 返回行数: <strong>${data.rowCount}</strong>`;
             }
+            if (data.truncated) {
+                statsHtml += ` | <i class="fas fa-cut"></i> 仅显示前 <strong>${data.limit}</strong> 行`;
+            }
             statsHtml += '</div>';
         }
         
@@ -133,11 +514,174 @@ async function executeQuery(queryId) {
         renderVisualization(queryId, 'table');
         
     } catch (err) {
+        delete runningQueryIds[queryId];
         errorDiv.innerHTML = `<i class="fas fa-exclamation-triangle"></i> 请求失败: ${err.message}`;
         resultDiv.innerHTML = '';
     }
 }
 
+// 生成查询ID
+function newQueryId() {
+    if (window.crypto && crypto.randomUUID) {
+        return crypto.randomUUID();
+    }
+    return `q-${Date.now()}-${Math.random().toString(36).slice(2, 10)}`;
+}
+
+// 取消正在执行的查询
+async function cancelQuery(queryId) {
+    const runId = runningQueryIds[queryId];
+    if (!runId) {
+        return;
+    }
+    
+    try {
+        const response = await fetch(`/api/query/${encodeURIComponent(runId)}/cancel`, { method: 'POST' });
+        const data = await response.json();
+        if (data.error) {
+            console.warn('取消查询失败:', data.error);
+        }
+    } catch (err) {
+        console.error('取消查询请求失败:', err);
+    }
+}
+
+// 查看执行计划
+async function explainQuery(queryId) {
+    const container = document.getElementById(`query-${queryId}`);
+    const errorDiv = container.querySelector('.error');
+    const explainDiv = container.querySelector('.explain-result');
+    
+    errorDiv.innerHTML = '';
+    explainDiv.style.display = 'block';
+    explainDiv.innerHTML = '<div class="loading"><i class="fas fa-spinner fa-spin"></i> 分析执行计划...</div>';
+    
+    try {
+        const response = await fetch('/api/explain', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ query: getSQLQuery(queryId), datasource: getQueryDataSource(queryId) })
+        });
+        
+        const data = await response.json();
+        if (data.error) {
+            errorDiv.innerHTML = formatQueryError(data);
+            explainDiv.style.display = 'none';
+            explainDiv.innerHTML = '';
+            return;
+        }
+        
+        let html = '';
+        if (data.queryCost !== undefined) {
+            html += `<div class="query-stats"><i class="fas fa-calculator"></i> 查询成本: <strong>${data.queryCost}</strong></div>`;
+        }
+        if (data.warnings && data.warnings.length > 0) {
+            html += '<div class="explain-warnings">';
+            data.warnings.forEach(w => {
+                html += `<div><i class="fas fa-exclamation-triangle"></i> ${escapePlanText(w)}</div>`;
+            });
+            html += '</div>';
+        }
+        if (data.plan) {
+            html += `<ul>${renderPlanNode(data.plan)}</ul>`;
+        }
+        if (data.analyze) {
+            html += `<div class="explain-analyze">${escapePlanText(data.analyze)}</div>`;
+        }
+        explainDiv.innerHTML = html;
+    } catch (err) {
+        errorDiv.innerHTML = `<i class="fas fa-exclamation-triangle"></i> 请求失败: ${err.message}`;
+        explainDiv.style.display = 'none';
+        explainDiv.innerHTML = '';
+    }
+}
+
+// 渲染执行计划节点
+function renderPlanNode(node) {
+    let label = escapePlanText(node.operation || '');
+    if (node.table) {
+        label += ` <strong>${escapePlanText(node.table)}</strong>`;
+    }
+    const details = [];
+    if (node.accessType) details.push(`type=${node.accessType}`);
+    if (node.key) details.push(`key=${node.key}`);
+    if (node.rowsExamined !== undefined) details.push(`rows=${node.rowsExamined}`);
+    if (node.filtered !== undefined) details.push(`filtered=${node.filtered}%`);
+    if (node.cost !== undefined) details.push(`cost=${node.cost}`);
+    if (details.length > 0) {
+        label += ` <span class="explain-detail">(${escapePlanText(details.join(', '))})</span>`;
+    }
+    if (node.condition) {
+        label += `<div class="explain-detail">条件: ${escapePlanText(node.condition)}</div>`;
+    }
+    if (node.message) {
+        label += `<div class="explain-detail">${escapePlanText(node.message)}</div>`;
+    }
+    
+    const cls = node.fullScan ? 'explain-node full-scan' : 'explain-node';
+    let html = `<li class="${cls}"><span class="explain-label">${label}</span>`;
+    if (node.children && node.children.length > 0) {
+        html += '<ul>' + node.children.map(renderPlanNode).join('') + '</ul>';
+    }
+    return html + '</li>';
+}
+
+function escapePlanText(text) {
+    const div = document.createElement('div');
+    div.textContent = String(text);
+    return div.innerHTML;
+}
+
+// 在服务端执行查询并下载全部结果
+async function exportQuery(queryId, select) {
+    const format = select.value;
+    select.value = '';
+    if (!format) {
+        return;
+    }
+    
+    const container = document.getElementById(`query-${queryId}`);
+    const errorDiv = container.querySelector('.error');
+    errorDiv.innerHTML = '';
+    select.disabled = true;
+    
+    try {
+        const response = await fetch('/api/export', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                query: getSQLQuery(queryId),
+                datasource: getQueryDataSource(queryId),
+                savedQueryId: savedQueryMeta[queryId] ? savedQueryMeta[queryId].id : undefined,
+                format: format === 'csv-bom' ? 'csv' : format,
+                bom: format === 'csv-bom',
+                filename: savedQueryMeta[queryId] ? savedQueryMeta[queryId].title : undefined
+            })
+        });
+        if (!response.ok) {
+            const contentType = response.headers.get('Content-Type') || '';
+            const data = contentType.includes('application/json') ? await response.json() : { error: await response.text() };
+            errorDiv.innerHTML = formatQueryError(data);
+            return;
+        }
+        
+        const blob = await response.blob();
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename\*=UTF-8''([^;]+)/);
+        const link = document.createElement('a');
+        link.href = URL.createObjectURL(blob);
+        link.download = match ? decodeURIComponent(match[1]) : `export.${format}`;
+        document.body.appendChild(link);
+        link.click();
+        link.remove();
+        URL.revokeObjectURL(link.href);
+    } catch (err) {
+        errorDiv.innerHTML = `<i class="fas fa-exclamation-triangle"></i> 导出失败: ${err.message}`;
+    } finally {
+        select.disabled = false;
+    }
+}
+
 // 切换可视化类型
 function changeVisualization(queryId, type) {
     const container = document.getElementById(`query-${queryId}`);
@@ -334,7 +878,7 @@ function addQuery() {
     tabElement.className = 'tab';
     tabElement.setAttribute('data-tab', queryCount);
     tabElement.innerHTML = `
-        <i class="fas fa-database"></i> 查询 ${queryCount}
+        <i class="fas fa-database"></i> <span class="tab-title">查询 ${queryCount}</span>
         <span class="close-tab" onclick="removeQuery(${queryCount}, event)"><i class="fas fa-times"></i></span>
     `;
     tabElement.onclick = () => switchTab(queryCount);
@@ -350,19 +894,35 @@ function addQuery() {
     queryContainer.innerHTML = `
         <div class="sql-editor-container" id="sql-editor-${queryCount}"></div>
         <div class="query-actions">
+            <select class="datasource-select" id="datasource-${queryCount}" title="数据源"></select>
             <button class="execute-btn" onclick="executeQuery(${queryCount})">
                 <i class="fas fa-play"></i> 执行查询
             </button>
             <button class="save-btn" onclick="saveQuery(${queryCount})">
                 <i class="fas fa-save"></i> 保存
             </button>
+            <button class="explain-btn" onclick="explainQuery(${queryCount})">
+                <i class="fas fa-sitemap"></i> 执行计划
+            </button>
+            <select class="export-select" onchange="exportQuery(${queryCount}, this)" title="导出全部结果">
+                <option value="">导出...</option>
+                <option value="xlsx">Excel (XLSX)</option>
+                <option value="csv-bom">CSV (Excel)</option>
+                <option value="csv">CSV</option>
+                <option value="tsv">TSV</option>
+                <option value="jsonl">JSON Lines</option>
+                <option value="parquet">Parquet</option>
+                <option value="arrow">Arrow IPC</option>
+            </select>
         </div>
         <div class="error"></div>
         <div class="visual-controls" style="display:none;"></div>
+        <div class="explain-result" style="display:none;"></div>
         <div class="result"></div>
     `;
     
     document.getElementById('queries-container').appendChild(queryContainer);
+    fillDataSourceSelect(queryContainer.querySelector('.datasource-select'));
     
     // 初始化新的SQL编辑器
     setTimeout(() => {
@@ -401,6 +961,7 @@ function removeQuery(queryId, event) {
     
     // 删除查询结果和编辑器实例
     delete queryResults[queryId];
+    delete savedQueryMeta[queryId];
     
     // 清理编辑器实例
     if (window.sqlEditors && window.sqlEditors[queryId]) {
@@ -426,10 +987,17 @@ function removeQuery(queryId, event) {
 function compareQueries() {
     // 检查是否有足够的查询结果
     const validResults = [];
+    const sources = [];
     for (const queryId in queryResults) {
         const result = queryResults[queryId];
         if (result && !result.error && result.rows && result.rows.length > 0) {
             validResults.push(result);
+            // 由服务端重新执行查询并合并，不回传已获取的结果
+            sources.push({
+                label: `q${queryId}`,
+                query: getSQLQuery(queryId),
+                datasource: getQueryDataSource(queryId)
+            });
         }
     }
     
@@ -442,7 +1010,7 @@ function compareQueries() {
     fetch('/api/merge', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ queries: validResults })
+        body: JSON.stringify({ sources: sources })
     })
     .then(response => response.json())
     .then(mergedData => {
@@ -499,6 +1067,9 @@ function displayMergedResults(mergedData, originalResults) {
             if (stat.rowCount !== undefined) {
                 html += `<p><i class="fas fa-list"></i> 返回行数: <strong>${stat.rowCount}</strong></p>`;
             }
+            if (stat.error) {
+                html += `<p class="error"><i class="fas fa-exclamation-circle"></i> ${escapePlanText(stat.error)}</p>`;
+            }
             html += `</div>`;
         });
     } else {
@@ -527,12 +1098,12 @@ function displayMergedResults(mergedData, originalResults) {
     html += '</div></div>';
     
     // 创建数据对比表格
-    html += '<div class="comparison-table"><h3>数据对比</h3><table><thead><tr><th>标签</th>';
+    html += '<div class="comparison-table"><h3>数据对比</h3><table><thead><tr>';
     
-    // 使用合并后的列名
-    for (let i = 1; i < mergedData.columns.length; i++) {
-        html += `<th>${mergedData.columns[i]}</th>`;
-    }
+    // 使用合并后的列名，前几列为连接键
+    mergedData.columns.forEach(column => {
+        html += `<th>${column}</th>`;
+    });
     
     html += '</tr></thead><tbody>';
     
@@ -563,10 +1134,23 @@ function displayMergedResults(mergedData, originalResults) {
     comparisonResult.appendChild(vizControls);
     
     // 保存合并结果用于图表显示
-    const labels = mergedData.rows.map(row => String(row[0]));
+    // 多列连接键拼接为一个标签
+    const keyCount = mergedData.keys ? mergedData.keys.length : 1;
+    const labels = mergedData.rows.map(row => row.slice(0, keyCount).map(String).join(' / '));
     const datasets = [];
     
-    for (let colIndex = 1; colIndex < mergedData.columns.length; colIndex++) {
+    // 对比模式只绘制当前期和基准期，差值、百分比和方向在表格中查看
+    let chartColumns = null;
+    if (mergedData.comparison) {
+        chartColumns = new Set();
+        mergedData.comparison.series.forEach(s => {
+            chartColumns.add(s.current);
+            chartColumns.add(s.baseline);
+        });
+    }
+    
+    for (let colIndex = keyCount; colIndex < mergedData.columns.length; colIndex++) {
+        if (chartColumns && !chartColumns.has(mergedData.columns[colIndex])) continue;
         const values = mergedData.rows.map(row => {
             const value = row[colIndex];
             if (value === null || value === undefined) return null;
@@ -750,14 +1334,18 @@ async function executeAllQueries() {
                 fetch('/api/query', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ query: query })
+                    body: JSON.stringify({
+                        query: query,
+                        datasource: getQueryDataSource(queryId),
+                        savedQueryId: savedQueryMeta[queryId] ? savedQueryMeta[queryId].id : undefined
+                    })
                 })
                 .then(response => response.json())
                 .then(data => {
                     queryResults[queryId] = data; // 保存查询结果
                     
                     if (data.error) {
-                        errorDiv.innerHTML = `<i class="fas fa-exclamation-circle"></i> ${data.error}`;
+                        errorDiv.innerHTML = formatQueryError(data);
                         resultDiv.innerHTML = '';
                         failCount++;
                     } else {
@@ -768,6 +1356,9 @@ async function executeAllQueries() {
                             if (data.rowCount !== undefined) {
                                 statsHtml += ` | <i class="fas fa-list"></i> 返回行数: <strong>${data.rowCount}</strong>`;
                             }
+                            if (data.truncated) {
+                                statsHtml += ` | <i class="fas fa-cut"></i> 仅显示前 <strong>${data.limit}</strong> 行`;
+                            }
                             statsHtml += '</div>';
                         }
                         
@@ -1353,7 +1944,7 @@ function confirmImport() {
         tabElement.className = 'tab';
         tabElement.setAttribute('data-tab', queryCount);
         tabElement.innerHTML = `
-            <i class="fas fa-database"></i> 查询 ${queryCount}
+            <i class="fas fa-database"></i> <span class="tab-title">查询 ${queryCount}</span>
             <span class="close-tab" onclick="removeQuery(${queryCount}, event)"><i class="fas fa-times"></i></span>
         `;
         tabElement.onclick = () => switchTab(queryCount);
@@ -1367,19 +1958,35 @@ function confirmImport() {
         queryContainer.innerHTML = `
             <div class="sql-editor-container" id="sql-editor-${queryCount}"></div>
             <div class="query-actions">
+                <select class="datasource-select" id="datasource-${queryCount}" title="数据源"></select>
                 <button class="execute-btn" onclick="executeQuery(${queryCount})">
                     <i class="fas fa-play"></i> 执行查询
                 </button>
                 <button class="save-btn" onclick="saveQuery(${queryCount})">
                     <i class="fas fa-save"></i> 保存
                 </button>
+                <button class="explain-btn" onclick="explainQuery(${queryCount})">
+                    <i class="fas fa-sitemap"></i> 执行计划
+                </button>
+                <select class="export-select" onchange="exportQuery(${queryCount}, this)" title="导出全部结果">
+                    <option value="">导出...</option>
+                    <option value="xlsx">Excel (XLSX)</option>
+                    <option value="csv-bom">CSV (Excel)</option>
+                    <option value="csv">CSV</option>
+                    <option value="tsv">TSV</option>
+                    <option value="jsonl">JSON Lines</option>
+                    <option value="parquet">Parquet</option>
+                    <option value="arrow">Arrow IPC</option>
+                </select>
             </div>
             <div class="error"></div>
             <div class="visual-controls" style="display:none;"></div>
+            <div class="explain-result" style="display:none;"></div>
             <div class="result"></div>
         `;
         
         document.getElementById('queries-container').appendChild(queryContainer);
+        fillDataSourceSelect(queryContainer.querySelector('.datasource-select'));
         
         setTimeout(() => {
             createSQLEditor(queryCount);
@@ -1436,6 +2043,8 @@ function downloadExcelTemplate() {
 
 // 初始化文件选择事件监听器
 document.addEventListener('DOMContentLoaded', function() {
+    loadDataSources();
+    
     const fileInput = document.getElementById('excel-file');
     if (fileInput) {
         fileInput.addEventListener('change', function(e) {
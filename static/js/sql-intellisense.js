@@ -67,6 +67,39 @@ class SQLIntelliSense {
         ];
         
         this.snippets = this.initializeSnippets();
+        
+        // 从服务端读取的表结构，按数据源缓存
+        this.schemas = {};
+        this.currentDataSource = '';
+    }
+    
+    // 从 /api/schema 加载数据源的表结构
+    async loadSchema(datasource = '', refresh = false) {
+        const params = new URLSearchParams();
+        if (datasource) {
+            params.set('datasource', datasource);
+        }
+        const url = refresh ? `/api/schema/refresh?${params}` : `/api/schema?${params}`;
+        
+        try {
+            const response = await fetch(url, { method: refresh ? 'POST' : 'GET' });
+            const data = await response.json();
+            if (data.error) {
+                console.warn('加载表结构失败:', data.error);
+                return null;
+            }
+            this.schemas[datasource] = data;
+            this.currentDataSource = datasource;
+            return data;
+        } catch (err) {
+            console.error('加载表结构请求失败:', err);
+            return null;
+        }
+    }
+    
+    // 当前数据源的表结构，未加载时返回 null
+    getSchema() {
+        return this.schemas[this.currentDataSource] || null;
     }
     
     initializeSnippets() {
@@ -132,7 +165,7 @@ class SQLIntelliSense {
         if (context.expectingTableName) {
             suggestions.push(...this.getTableSuggestions(currentWord));
         } else if (context.expectingColumnName) {
-            suggestions.push(...this.getColumnSuggestions(currentWord));
+            suggestions.push(...this.getColumnSuggestions(currentWord, text));
         } else if (context.expectingFunction) {
             suggestions.push(...this.getFunctionSuggestions(currentWord));
         } else {
@@ -205,6 +238,18 @@ class SQLIntelliSense {
     }
     
     getTableSuggestions(currentWord) {
+        const schema = this.getSchema();
+        if (schema) {
+            return schema.tables.map(table => ({
+                label: table.name,
+                kind: 'table',
+                insertText: table.name,
+                detail: table.type === 'VIEW' ? '视图' : '表名',
+                description: table.comment,
+                sortText: '3' + table.name
+            }));
+        }
+        
         return this.commonTables.map(table => ({
             label: table,
             kind: 'table',
@@ -214,7 +259,28 @@ class SQLIntelliSense {
         }));
     }
     
-    getColumnSuggestions(currentWord) {
+    getColumnSuggestions(currentWord, text = '') {
+        const schema = this.getSchema();
+        if (schema) {
+            // 优先提示SQL中已引用表的列，没有引用表时提示所有列
+            const referenced = this.extractTables(text).map(t => t.toLowerCase());
+            const tables = schema.tables.filter(t => referenced.includes(t.name.toLowerCase()));
+            const suggestions = [];
+            (tables.length > 0 ? tables : schema.tables).forEach(table => {
+                table.columns.forEach(column => {
+                    suggestions.push({
+                        label: column.name,
+                        kind: 'column',
+                        insertText: column.name,
+                        detail: `${table.name}.${column.name} ${column.columnType}`,
+                        description: column.comment,
+                        sortText: '4' + column.name
+                    });
+                });
+            });
+            return suggestions;
+        }
+        
         return this.commonColumns.map(column => ({
             label: column,
             kind: 'column',
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 执行历史的类型
+const (
+	HistoryQuery     = "query"     // 普通查询
+	HistoryStream    = "stream"    // 流式查询
+	HistoryExplain   = "explain"   // 执行计划
+	HistoryExport    = "export"    // 导出
+	HistoryImport    = "import"    // 导入文件
+	HistoryMerge     = "merge"     // 服务端合并中执行的查询
+	HistoryTransform = "transform" // 变换接口执行的查询
+)
+
+// 执行历史列表的默认和最大分页大小
+const (
+	DefaultHistoryPageSize = 50
+	MaxHistoryPageSize     = 500
+)
+
+// HistoryEntry 一次查询执行的记录
+type HistoryEntry struct {
+	ID           int64     `json:"id"`
+	QueryID      string    `json:"queryId,omitempty"`
+	Kind         string    `json:"kind"`
+	User         string    `json:"user"`
+	ClientIP     string    `json:"clientIp"`
+	DataSource   string    `json:"datasource"`
+	SQLHash      string    `json:"sqlHash"` // SQL文本的 SHA-256，用于查找同一条SQL的执行记录
+	Query        string    `json:"query"`
+	Params       string    `json:"params,omitempty"` // 参数值的JSON文本
+	SavedQueryID *int64    `json:"savedQueryId,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	RowCount     int64     `json:"rowCount"`
+	Truncated    bool      `json:"truncated,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorCode    string    `json:"errorCode,omitempty"`
+}
+
+// HistoryFilter 执行历史的过滤和分页条件，空字段不过滤
+type HistoryFilter struct {
+	User         string
+	DataSource   string
+	Kind         string
+	SQLHash      string
+	SavedQueryID int64
+	Search       string    // 在SQL中模糊匹配
+	Status       string    // success 或 error
+	From         time.Time // 开始时间下限（含）
+	To           time.Time // 开始时间上限（不含）
+	Page         int       // 从1开始
+	PageSize     int
+}
+
+// HistoryPage 一页执行历史
+type HistoryPage struct {
+	History  []HistoryEntry `json:"history"`
+	Total    int64          `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pageSize"`
+}
+
+const historyColumns = `id, query_id, kind, user, client_ip, datasource, sql_hash, query, params, saved_query_id,
+	started_at, duration_ms, row_count, truncated, error, error_code`
+
+// HashSQL 计算SQL文本的哈希
+func HashSQL(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordHistory 写入一条执行历史，SQLHash 为空时自动计算
+func RecordHistory(ctx context.Context, e *HistoryEntry) error {
+	conn, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	if e.SQLHash == "" {
+		e.SQLHash = HashSQL(e.Query)
+	}
+	if e.Kind == "" {
+		e.Kind = HistoryQuery
+	}
+
+	res, err := conn.ExecContext(ctx,
+		`INSERT INTO query_history (query_id, kind, user, client_ip, datasource, sql_hash, query, params, saved_query_id,
+		 started_at, duration_ms, row_count, truncated, error, error_code) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.QueryID, e.Kind, e.User, e.ClientIP, e.DataSource, e.SQLHash, e.Query, e.Params, e.SavedQueryID,
+		e.StartedAt.UTC(), e.DurationMs, e.RowCount, e.Truncated, e.Error, e.ErrorCode)
+	if err != nil {
+		return fmt.Errorf("记录执行历史失败: %v", err)
+	}
+	e.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// ListHistory 按开始时间倒序分页列出执行历史
+func ListHistory(ctx context.Context, filter HistoryFilter) (*HistoryPage, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize <= 0 {
+		filter.PageSize = DefaultHistoryPageSize
+	} else if filter.PageSize > MaxHistoryPageSize {
+		filter.PageSize = MaxHistoryPageSize
+	}
+
+	var conds []string
+	var args []interface{}
+	addCond := func(cond string, values ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+	if filter.User != "" {
+		addCond("user = ?", filter.User)
+	}
+	if filter.DataSource != "" {
+		addCond("datasource = ?", filter.DataSource)
+	}
+	if filter.Kind != "" {
+		addCond("kind = ?", filter.Kind)
+	}
+	if filter.SQLHash != "" {
+		addCond("sql_hash = ?", filter.SQLHash)
+	}
+	if filter.SavedQueryID > 0 {
+		addCond("saved_query_id = ?", filter.SavedQueryID)
+	}
+	if filter.Search != "" {
+		addCond("query LIKE ? ESCAPE '\\'", "%"+escapeLike(filter.Search)+"%")
+	}
+	switch filter.Status {
+	case "success":
+		addCond("error = ''")
+	case "error":
+		addCond("error <> ''")
+	}
+	// 时间统一以UTC保存，按文本比较即可
+	if !filter.From.IsZero() {
+		addCond("started_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		addCond("started_at < ?", filter.To.UTC())
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	page := &HistoryPage{History: []HistoryEntry{}, Page: filter.Page, PageSize: filter.PageSize}
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM query_history"+where, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("查询执行历史失败: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT "+historyColumns+" FROM query_history"+where+" ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)...)
+	if err != nil {
+		return nil, fmt.Errorf("查询执行历史失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.History = append(page.History, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询执行历史失败: %v", err)
+	}
+	return page, nil
+}
+
+// GetHistory 按ID读取一条执行历史
+func GetHistory(ctx context.Context, id int64) (*HistoryEntry, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := scanHistory(conn.QueryRowContext(ctx, "SELECT "+historyColumns+" FROM query_history WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// scanHistory 读取一行执行历史，列顺序与 historyColumns 一致
+func scanHistory(row rowScanner) (*HistoryEntry, error) {
+	var (
+		e            HistoryEntry
+		savedQueryID sql.NullInt64
+	)
+	err := row.Scan(&e.ID, &e.QueryID, &e.Kind, &e.User, &e.ClientIP, &e.DataSource, &e.SQLHash, &e.Query, &e.Params,
+		&savedQueryID, &e.StartedAt, &e.DurationMs, &e.RowCount, &e.Truncated, &e.Error, &e.ErrorCode)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取执行历史失败: %v", err)
+	}
+	if savedQueryID.Valid {
+		e.SavedQueryID = &savedQueryID.Int64
+	}
+	return &e, nil
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"bi-web/db"
+)
+
+// SavedQueryRevision 已保存查询的一个修订，每次保存或恢复都会产生新修订
+type SavedQueryRevision struct {
+	SavedQueryID int64         `json:"savedQueryId"`
+	Revision     int64         `json:"revision"`
+	Author       string        `json:"author"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description,omitempty"`
+	Tags         []string      `json:"tags"`
+	DataSource   string        `json:"datasource,omitempty"`
+	Query        string        `json:"query"`
+	ParamDefs    []db.ParamDef `json:"paramDefs,omitempty"`
+	RestoredFrom *int64        `json:"restoredFrom,omitempty"` // 由哪个修订恢复而来
+	CreatedAt    time.Time     `json:"createdAt"`
+}
+
+const revisionColumns = `saved_query_id, revision, author, title, description, tags, datasource, query, param_defs,
+	restored_from, created_at`
+
+// ListRevisions 按修订号倒序列出已保存查询的所有修订
+func ListRevisions(ctx context.Context, savedQueryID int64) ([]SavedQueryRevision, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists int
+	err = conn.QueryRowContext(ctx, "SELECT 1 FROM saved_queries WHERE id = ?", savedQueryID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询修订历史失败: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT "+revisionColumns+" FROM saved_query_revisions WHERE saved_query_id = ? ORDER BY revision DESC",
+		savedQueryID)
+	if err != nil {
+		return nil, fmt.Errorf("查询修订历史失败: %v", err)
+	}
+	defer rows.Close()
+
+	revisions := []SavedQueryRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询修订历史失败: %v", err)
+	}
+	return revisions, nil
+}
+
+// GetRevision 读取已保存查询的指定修订
+func GetRevision(ctx context.Context, savedQueryID, revision int64) (*SavedQueryRevision, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+	return getRevision(ctx, conn, savedQueryID, revision)
+}
+
+// RestoreRevision 把已保存查询恢复到指定修订的内容，恢复本身记录为一个新修订
+func RestoreRevision(ctx context.Context, savedQueryID, revision int64, author string) (*SavedQuery, error) {
+	now := time.Now().UTC()
+
+	err := withTx(ctx, func(tx *sql.Tx) error {
+		old, err := getRevision(ctx, tx, savedQueryID, revision)
+		if err != nil {
+			return err
+		}
+		q := &SavedQuery{
+			ID:          savedQueryID,
+			Title:       old.Title,
+			Description: old.Description,
+			Tags:        old.Tags,
+			DataSource:  old.DataSource,
+			Query:       old.Query,
+			ParamDefs:   old.ParamDefs,
+		}
+		tags, paramDefs, err := encodeSavedQueryFields(q)
+		if err != nil {
+			return err
+		}
+
+		next, err := nextRevision(ctx, tx, savedQueryID, 0)
+		if err != nil {
+			return err
+		}
+		if err := updateSavedQueryRow(ctx, tx, q, tags, paramDefs, next, now); err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, savedQueryID, next, author, tags, paramDefs, q, &revision, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetSavedQuery(ctx, savedQueryID)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getRevision(ctx context.Context, conn queryRower, savedQueryID, revision int64) (*SavedQueryRevision, error) {
+	row := conn.QueryRowContext(ctx,
+		"SELECT "+revisionColumns+" FROM saved_query_revisions WHERE saved_query_id = ? AND revision = ?",
+		savedQueryID, revision)
+	rev, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return rev, err
+}
+
+// nextRevision 返回下一个修订号，baseRevision 大于0时检查是否仍是当前修订
+func nextRevision(ctx context.Context, tx *sql.Tx, savedQueryID, baseRevision int64) (int64, error) {
+	var current int64
+	err := tx.QueryRowContext(ctx, "SELECT revision FROM saved_queries WHERE id = ?", savedQueryID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取修订号失败: %v", err)
+	}
+	if baseRevision > 0 && baseRevision != current {
+		return 0, fmt.Errorf("%w: 当前修订为 %d，提交基于修订 %d", ErrConflict, current, baseRevision)
+	}
+	return current + 1, nil
+}
+
+// updateSavedQueryRow 把可编辑字段和修订号写回已保存查询
+func updateSavedQueryRow(ctx context.Context, tx *sql.Tx, q *SavedQuery, tags, paramDefs string, revision int64, now time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE saved_queries SET title = ?, description = ?, tags = ?, datasource = ?, query = ?, param_defs = ?,
+		 revision = ?, updated_at = ? WHERE id = ?`,
+		q.Title, q.Description, tags, q.DataSource, q.Query, paramDefs, revision, now, q.ID)
+	if err != nil {
+		return fmt.Errorf("更新查询失败: %v", err)
+	}
+	return nil
+}
+
+// insertRevision 记录一个修订，tags 和 paramDefs 为已编码的JSON文本
+func insertRevision(ctx context.Context, tx *sql.Tx, savedQueryID, revision int64, author, tags, paramDefs string,
+	q *SavedQuery, restoredFrom *int64, now time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO saved_query_revisions (saved_query_id, revision, author, title, description, tags, datasource, query,
+		 param_defs, restored_from, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		savedQueryID, revision, author, q.Title, q.Description, tags, q.DataSource, q.Query, paramDefs, restoredFrom, now)
+	if err != nil {
+		return fmt.Errorf("记录修订失败: %v", err)
+	}
+	return nil
+}
+
+// scanRevision 读取一行修订，列顺序与 revisionColumns 一致
+func scanRevision(row rowScanner) (*SavedQueryRevision, error) {
+	var (
+		rev          SavedQueryRevision
+		tags         string
+		paramDefs    string
+		restoredFrom sql.NullInt64
+	)
+	err := row.Scan(&rev.SavedQueryID, &rev.Revision, &rev.Author, &rev.Title, &rev.Description, &tags,
+		&rev.DataSource, &rev.Query, &paramDefs, &restoredFrom, &rev.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取修订失败: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(tags), &rev.Tags); err != nil || rev.Tags == nil {
+		rev.Tags = []string{}
+	}
+	if paramDefs != "" {
+		decoder := json.NewDecoder(strings.NewReader(paramDefs))
+		decoder.UseNumber()
+		decoder.Decode(&rev.ParamDefs)
+	}
+	if restoredFrom.Valid {
+		rev.RestoredFrom = &restoredFrom.Int64
+	}
+	return &rev, nil
+}
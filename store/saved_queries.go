@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"bi-web/db"
+)
+
+// SavedQuery 已保存的查询
+type SavedQuery struct {
+	ID          int64         `json:"id"`
+	Owner       string        `json:"owner"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Tags        []string      `json:"tags"`
+	DataSource  string        `json:"datasource,omitempty"`
+	Query       string        `json:"query"`
+	ParamDefs   []db.ParamDef `json:"paramDefs,omitempty"`
+	Revision    int64         `json:"revision"` // 当前修订号，每次保存加1
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+
+	// LastRun 最近一次执行的统计，从未执行时为空
+	LastRun  *SavedQueryRun `json:"lastRun,omitempty"`
+	RunCount int64          `json:"runCount"`
+}
+
+// SavedQueryRun 已保存查询的一次执行统计
+type SavedQueryRun struct {
+	At       time.Time `json:"at"`
+	Duration string    `json:"duration,omitempty"`
+	RowCount int64     `json:"rowCount"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SavedQueryFilter 已保存查询的列表过滤条件，空字段不过滤
+type SavedQueryFilter struct {
+	Owner      string
+	Tag        string
+	DataSource string
+	Search     string // 在标题、描述和SQL中模糊匹配
+}
+
+const savedQueryColumns = `id, owner, title, description, tags, datasource, query, param_defs, revision,
+	created_at, updated_at, last_run_at, last_duration, last_row_count, last_error, run_count`
+
+// ListSavedQueries 按更新时间倒序列出已保存查询
+func ListSavedQueries(ctx context.Context, filter SavedQueryFilter) ([]SavedQuery, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var conds []string
+	var args []interface{}
+	if filter.Owner != "" {
+		conds = append(conds, "owner = ?")
+		args = append(args, filter.Owner)
+	}
+	if filter.DataSource != "" {
+		conds = append(conds, "datasource = ?")
+		args = append(args, filter.DataSource)
+	}
+	if filter.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM json_each(saved_queries.tags) WHERE value = ?)")
+		args = append(args, filter.Tag)
+	}
+	if filter.Search != "" {
+		conds = append(conds, "(title LIKE ? ESCAPE '\\' OR description LIKE ? ESCAPE '\\' OR query LIKE ? ESCAPE '\\')")
+		pattern := "%" + escapeLike(filter.Search) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	query := "SELECT " + savedQueryColumns + " FROM saved_queries"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY updated_at DESC, id DESC"
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询已保存查询失败: %v", err)
+	}
+	defer rows.Close()
+
+	queries := []SavedQuery{}
+	for rows.Next() {
+		q, err := scanSavedQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, *q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询已保存查询失败: %v", err)
+	}
+	return queries, nil
+}
+
+// GetSavedQuery 按ID读取已保存查询
+func GetSavedQuery(ctx context.Context, id int64) (*SavedQuery, error) {
+	conn, err := getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	row := conn.QueryRowContext(ctx, "SELECT "+savedQueryColumns+" FROM saved_queries WHERE id = ?", id)
+	q, err := scanSavedQuery(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return q, err
+}
+
+// CreateSavedQuery 新建已保存查询并记录第一个修订，成功后回填ID、修订号和时间
+func CreateSavedQuery(ctx context.Context, q *SavedQuery) error {
+	tags, paramDefs, err := encodeSavedQueryFields(q)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO saved_queries (owner, title, description, tags, datasource, query, param_defs, revision, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+			q.Owner, q.Title, q.Description, tags, q.DataSource, q.Query, paramDefs, now, now)
+		if err != nil {
+			return fmt.Errorf("保存查询失败: %v", err)
+		}
+		if q.ID, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("保存查询失败: %v", err)
+		}
+		return insertRevision(ctx, tx, q.ID, 1, q.Owner, tags, paramDefs, q, nil, now)
+	})
+	if err != nil {
+		return err
+	}
+	q.Revision = 1
+	q.CreatedAt = now
+	q.UpdatedAt = now
+	return nil
+}
+
+// UpdateSavedQuery 更新已保存查询的可编辑字段（标题、描述、标签、数据源、SQL、参数）并记录新修订。
+// baseRevision 大于0时要求与当前修订号一致，否则返回 ErrConflict，避免覆盖他人的修改
+func UpdateSavedQuery(ctx context.Context, q *SavedQuery, author string, baseRevision int64) error {
+	tags, paramDefs, err := encodeSavedQueryFields(q)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	var revision int64
+	err = withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		revision, err = nextRevision(ctx, tx, q.ID, baseRevision)
+		if err != nil {
+			return err
+		}
+		if err := updateSavedQueryRow(ctx, tx, q, tags, paramDefs, revision, now); err != nil {
+			return err
+		}
+		return insertRevision(ctx, tx, q.ID, revision, author, tags, paramDefs, q, nil, now)
+	})
+	if err != nil {
+		return err
+	}
+	q.Revision = revision
+	q.UpdatedAt = now
+	return nil
+}
+
+// DeleteSavedQuery 删除已保存查询
+func DeleteSavedQuery(ctx context.Context, id int64) error {
+	conn, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	res, err := conn.ExecContext(ctx, "DELETE FROM saved_queries WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("删除查询失败: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordSavedQueryRun 记录已保存查询的最近一次执行统计
+func RecordSavedQueryRun(ctx context.Context, id int64, run SavedQueryRun) error {
+	conn, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	res, err := conn.ExecContext(ctx,
+		`UPDATE saved_queries SET last_run_at = ?, last_duration = ?, last_row_count = ?, last_error = ?, run_count = run_count + 1
+		 WHERE id = ?`,
+		run.At.UTC(), run.Duration, run.RowCount, run.Error, id)
+	if err != nil {
+		return fmt.Errorf("记录查询执行统计失败: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// NormalizeTags 去掉空白和重复的标签，保持原有顺序
+func NormalizeTags(tags []string) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSavedQuery 读取一行已保存查询，列顺序与 savedQueryColumns 一致
+func scanSavedQuery(row rowScanner) (*SavedQuery, error) {
+	var (
+		q            SavedQuery
+		tags         string
+		paramDefs    string
+		lastRunAt    sql.NullTime
+		lastDuration string
+		lastRowCount sql.NullInt64
+		lastError    string
+	)
+	err := row.Scan(&q.ID, &q.Owner, &q.Title, &q.Description, &tags, &q.DataSource, &q.Query, &paramDefs, &q.Revision,
+		&q.CreatedAt, &q.UpdatedAt, &lastRunAt, &lastDuration, &lastRowCount, &lastError, &q.RunCount)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取已保存查询失败: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(tags), &q.Tags); err != nil || q.Tags == nil {
+		q.Tags = []string{}
+	}
+	if paramDefs != "" {
+		// 保持参数默认值中数字的原始精度
+		decoder := json.NewDecoder(strings.NewReader(paramDefs))
+		decoder.UseNumber()
+		decoder.Decode(&q.ParamDefs)
+	}
+	if lastRunAt.Valid {
+		q.LastRun = &SavedQueryRun{
+			At:       lastRunAt.Time,
+			Duration: lastDuration,
+			RowCount: lastRowCount.Int64,
+			Error:    lastError,
+		}
+	}
+	return &q, nil
+}
+
+// encodeSavedQueryFields 把标签和参数声明编码为JSON文本
+func encodeSavedQueryFields(q *SavedQuery) (string, string, error) {
+	q.Tags = NormalizeTags(q.Tags)
+	tags, err := json.Marshal(q.Tags)
+	if err != nil {
+		return "", "", fmt.Errorf("标签格式错误: %v", err)
+	}
+
+	paramDefs := []byte("[]")
+	if len(q.ParamDefs) > 0 {
+		if paramDefs, err = json.Marshal(q.ParamDefs); err != nil {
+			return "", "", fmt.Errorf("参数声明格式错误: %v", err)
+		}
+	}
+	return string(tags), string(paramDefs), nil
+}
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
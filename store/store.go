@@ -0,0 +1,195 @@
+// Package store 应用元数据存储（嵌入式 SQLite），保存已保存查询等与业务数据库无关的数据
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("记录不存在")
+
+// ErrConflict 记录已被其他人修改
+var ErrConflict = errors.New("记录已被修改，请刷新后重试")
+
+// ErrNotOpen 元数据库未初始化
+var ErrNotOpen = errors.New("元数据库未初始化")
+
+var (
+	storeMu sync.RWMutex
+	storeDB *sql.DB
+)
+
+// migrations 按顺序执行的建表语句，已执行的版本记录在 PRAGMA user_version 中，
+// 新增表或字段时只能在末尾追加
+var migrations = []string{
+	`CREATE TABLE saved_queries (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner         TEXT NOT NULL DEFAULT '',
+		title         TEXT NOT NULL,
+		description   TEXT NOT NULL DEFAULT '',
+		tags          TEXT NOT NULL DEFAULT '[]',
+		datasource    TEXT NOT NULL DEFAULT '',
+		query         TEXT NOT NULL,
+		param_defs    TEXT NOT NULL DEFAULT '[]',
+		created_at    DATETIME NOT NULL,
+		updated_at    DATETIME NOT NULL,
+		last_run_at   DATETIME,
+		last_duration TEXT NOT NULL DEFAULT '',
+		last_row_count INTEGER,
+		last_error    TEXT NOT NULL DEFAULT '',
+		run_count     INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX idx_saved_queries_owner ON saved_queries(owner);`,
+
+	// 已保存查询的修订历史，已有查询补一条初始修订
+	`ALTER TABLE saved_queries ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+	CREATE TABLE saved_query_revisions (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		saved_query_id INTEGER NOT NULL REFERENCES saved_queries(id) ON DELETE CASCADE,
+		revision       INTEGER NOT NULL,
+		author         TEXT NOT NULL DEFAULT '',
+		title          TEXT NOT NULL,
+		description    TEXT NOT NULL DEFAULT '',
+		tags           TEXT NOT NULL DEFAULT '[]',
+		datasource     TEXT NOT NULL DEFAULT '',
+		query          TEXT NOT NULL,
+		param_defs     TEXT NOT NULL DEFAULT '[]',
+		restored_from  INTEGER,
+		created_at     DATETIME NOT NULL,
+		UNIQUE (saved_query_id, revision)
+	);
+	INSERT INTO saved_query_revisions (saved_query_id, revision, author, title, description, tags, datasource, query, param_defs, created_at)
+		SELECT id, 1, owner, title, description, tags, datasource, query, param_defs, updated_at FROM saved_queries;`,
+
+	// 查询执行历史（审计记录）
+	`CREATE TABLE query_history (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		query_id       TEXT NOT NULL DEFAULT '',
+		kind           TEXT NOT NULL DEFAULT 'query',
+		user           TEXT NOT NULL DEFAULT '',
+		client_ip      TEXT NOT NULL DEFAULT '',
+		datasource     TEXT NOT NULL DEFAULT '',
+		sql_hash       TEXT NOT NULL,
+		query          TEXT NOT NULL,
+		params         TEXT NOT NULL DEFAULT '',
+		saved_query_id INTEGER,
+		started_at     DATETIME NOT NULL,
+		duration_ms    INTEGER NOT NULL DEFAULT 0,
+		row_count      INTEGER NOT NULL DEFAULT 0,
+		truncated      INTEGER NOT NULL DEFAULT 0,
+		error          TEXT NOT NULL DEFAULT '',
+		error_code     TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX idx_query_history_started_at ON query_history(started_at);
+	CREATE INDEX idx_query_history_user ON query_history(user, started_at);
+	CREATE INDEX idx_query_history_datasource ON query_history(datasource, started_at);
+	CREATE INDEX idx_query_history_sql_hash ON query_history(sql_hash);`,
+}
+
+// Open 打开元数据库并执行未完成的迁移
+func Open(path string) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("创建元数据库目录失败: %v", err)
+		}
+	}
+
+	// 时间以UTC按 _time_format=sqlite 写入（2006-01-02 15:04:05.999999999+00:00），按文本比较即按时间比较
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_time_format=sqlite")
+	if err != nil {
+		return fmt.Errorf("打开元数据库失败: %v", err)
+	}
+	// SQLite 只允许单个写入者，使用单连接避免 database is locked
+	conn.SetMaxOpenConns(1)
+
+	if err := migrate(context.Background(), conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	storeMu.Lock()
+	storeDB = conn
+	storeMu.Unlock()
+
+	log.Printf("元数据库已打开: %s", path)
+	return nil
+}
+
+// Close 关闭元数据库
+func Close() {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if storeDB != nil {
+		storeDB.Close()
+		storeDB = nil
+	}
+}
+
+// getDB 返回当前元数据库连接
+func getDB() (*sql.DB, error) {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	if storeDB == nil {
+		return nil, ErrNotOpen
+	}
+	return storeDB, nil
+}
+
+// withTx 在事务中执行 fn，fn 返回错误时回滚
+func withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	conn, err := getDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("开启事务失败: %v", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败: %v", err)
+	}
+	return nil
+}
+
+// migrate 执行尚未执行的迁移
+func migrate(ctx context.Context, conn *sql.DB) error {
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("读取元数据库版本失败: %v", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("元数据库迁移失败: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("元数据库迁移 %d 失败: %v", i+1, err)
+		}
+		// PRAGMA 不支持占位符
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("元数据库迁移 %d 失败: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("元数据库迁移 %d 失败: %v", i+1, err)
+		}
+		log.Printf("元数据库迁移到版本 %d", i+1)
+	}
+	return nil
+}
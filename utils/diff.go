@@ -0,0 +1,113 @@
+package utils
+
+import "strings"
+
+// 行差异类型
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffLine 逐行对比的一行结果，OldLine/NewLine 为从1开始的行号，不存在时为0
+type DiffLine struct {
+	Op      string `json:"op"`
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+	Text    string `json:"text"`
+}
+
+// maxDiffCells 最长公共子序列表的最大格数，超过时中间部分按整体删除再插入处理
+const maxDiffCells = 4 << 20
+
+// DiffLines 按行对比两段文本（最长公共子序列）
+func DiffLines(oldText, newText string) []DiffLine {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// 先去掉相同的首尾行，缩小比较范围
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	result := make([]DiffLine, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		result = append(result, DiffLine{Op: DiffEqual, OldLine: i + 1, NewLine: i + 1, Text: a[i]})
+	}
+	result = append(result, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		oi, ni := len(a)-i, len(b)-i
+		result = append(result, DiffLine{Op: DiffEqual, OldLine: oi + 1, NewLine: ni + 1, Text: a[oi]})
+	}
+	return result
+}
+
+// diffMiddle 对比去掉公共首尾后的部分，offset 为行号偏移
+func diffMiddle(a, b []string, oldOffset, newOffset int) []DiffLine {
+	n, m := len(a), len(b)
+	var result []DiffLine
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxDiffCells {
+		for i, line := range a {
+			result = append(result, DiffLine{Op: DiffDelete, OldLine: oldOffset + i + 1, Text: line})
+		}
+		for j, line := range b {
+			result = append(result, DiffLine{Op: DiffInsert, NewLine: newOffset + j + 1, Text: line})
+		}
+		return result
+	}
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, DiffLine{Op: DiffEqual, OldLine: oldOffset + i + 1, NewLine: newOffset + j + 1, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{Op: DiffDelete, OldLine: oldOffset + i + 1, Text: a[i]})
+			i++
+		default:
+			result = append(result, DiffLine{Op: DiffInsert, NewLine: newOffset + j + 1, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		result = append(result, DiffLine{Op: DiffDelete, OldLine: oldOffset + i + 1, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		result = append(result, DiffLine{Op: DiffInsert, NewLine: newOffset + j + 1, Text: b[j]})
+	}
+	return result
+}
+
+// splitLines 按行拆分文本，统一换行符，忽略末尾的换行
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func eq(oldLine, newLine int, text string) DiffLine {
+	return DiffLine{Op: DiffEqual, OldLine: oldLine, NewLine: newLine, Text: text}
+}
+
+func del(oldLine int, text string) DiffLine {
+	return DiffLine{Op: DiffDelete, OldLine: oldLine, Text: text}
+}
+
+func ins(newLine int, text string) DiffLine {
+	return DiffLine{Op: DiffInsert, NewLine: newLine, Text: text}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name             string
+		oldText, newText string
+		want             []DiffLine
+	}{
+		{"都为空", "", "", []DiffLine{}},
+		{"旧文本为空", "", "a\nb", []DiffLine{ins(1, "a"), ins(2, "b")}},
+		{"新文本为空", "a\nb\n", "", []DiffLine{del(1, "a"), del(2, "b")}},
+		{"相同", "SELECT 1\nFROM t", "SELECT 1\nFROM t", []DiffLine{eq(1, 1, "SELECT 1"), eq(2, 2, "FROM t")}},
+		{"中间插入", "a\nc", "a\nb\nc", []DiffLine{eq(1, 1, "a"), ins(2, "b"), eq(2, 3, "c")}},
+		{"末尾插入", "a", "a\nb", []DiffLine{eq(1, 1, "a"), ins(2, "b")}},
+		{"开头删除", "a\nb\nc", "b\nc", []DiffLine{del(1, "a"), eq(2, 1, "b"), eq(3, 2, "c")}},
+		{"中间删除", "a\nb\nc", "a\nc", []DiffLine{eq(1, 1, "a"), del(2, "b"), eq(3, 2, "c")}},
+		{"修改一行", "a\nb\nc", "a\nB\nc", []DiffLine{eq(1, 1, "a"), del(2, "b"), ins(2, "B"), eq(3, 3, "c")}},
+		{"末尾增加换行", "a\nb", "a\nb\n", []DiffLine{eq(1, 1, "a"), eq(2, 2, "b")}},
+		{"末尾去掉换行", "a\nb\n", "a\nb", []DiffLine{eq(1, 1, "a"), eq(2, 2, "b")}},
+		{"末尾增加空行", "a\n", "a\n\n", []DiffLine{eq(1, 1, "a"), ins(2, "")}},
+		{"CRLF 与 LF 相同", "a\r\nb\r\n", "a\nb", []DiffLine{eq(1, 1, "a"), eq(2, 2, "b")}},
+		{
+			"最长公共子序列",
+			"a\nb\nc\nd\ne", "x\nb\nd\ny\ne",
+			[]DiffLine{del(1, "a"), ins(1, "x"), eq(2, 2, "b"), del(3, "c"), eq(4, 3, "d"), ins(4, "y"), eq(5, 5, "e")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLines(tt.oldText, tt.newText)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines(%q, %q) =\n%+v\n应为\n%+v", tt.oldText, tt.newText, got, tt.want)
+			}
+		})
+	}
+}
+
+// 结果按顺序去掉插入行得到旧文本，去掉删除行得到新文本，行号连续
+func TestDiffLinesReconstruct(t *testing.T) {
+	oldText := strings.Repeat("SELECT a\nFROM t\nWHERE x = 1\n", 20)
+	newText := strings.ReplaceAll(oldText, "WHERE x = 1", "WHERE x = 2\nAND y = 3")
+
+	var oldLines, newLines []string
+	for _, d := range DiffLines(oldText, newText) {
+		if d.Op != DiffInsert {
+			if d.OldLine != len(oldLines)+1 {
+				t.Fatalf("旧文本行号 %d 不连续，应为 %d", d.OldLine, len(oldLines)+1)
+			}
+			oldLines = append(oldLines, d.Text)
+		}
+		if d.Op != DiffDelete {
+			if d.NewLine != len(newLines)+1 {
+				t.Fatalf("新文本行号 %d 不连续，应为 %d", d.NewLine, len(newLines)+1)
+			}
+			newLines = append(newLines, d.Text)
+		}
+	}
+	if got := strings.Join(oldLines, "\n") + "\n"; got != oldText {
+		t.Errorf("还原的旧文本不一致:\n%s", got)
+	}
+	if got := strings.Join(newLines, "\n") + "\n"; got != newText {
+		t.Errorf("还原的新文本不一致:\n%s", got)
+	}
+}